@@ -0,0 +1,336 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jaeiya/hashimg/lib/ui"
+)
+
+const defaultCommand = "dedupe"
+
+type command struct {
+	name string
+	// Arguments after the flags, for the usage line
+	args    string
+	summary string
+	// Shown after the summary in the help of the command
+	details string
+	// Commands that scan a directory share the global flags
+	usesDir bool
+	// Runs the terminal UI, which logging to stderr would garble
+	tui bool
+	// Adds the flags only this command has
+	flags func(fs *flag.FlagSet)
+	run   func(fs *flag.FlagSet) int
+}
+
+var (
+	commands []command
+	// Flags of single commands
+	resume  bool
+	restore bool
+)
+
+// Commands are set up in init, because help refers back to them
+func init() {
+	commands = []command{
+		{
+			name:    "dedupe",
+			args:    "[dir]",
+			summary: "rename images to their hash and dispose of the dupes (default)",
+			usesDir: true,
+			tui:     true,
+			flags:   func(fs *flag.FlagSet) { reportFlags(fs); tuiFlags(fs) },
+			run:     func(fs *flag.FlagSet) int { return run(dirArg(fs), false, ui.ReviewAsk) },
+		},
+		{
+			name:    "scan",
+			args:    "[dir]",
+			summary: "list the dupes and renames without changing anything",
+			usesDir: true,
+			run:     func(fs *flag.FlagSet) int { return runScan(dirArg(fs)) },
+		},
+		{
+			name:    "review",
+			args:    "[dir]",
+			summary: "review the dupes before they're disposed of, or finish an interrupted review",
+			usesDir: true,
+			tui:     true,
+			flags: func(fs *flag.FlagSet) {
+				reportFlags(fs)
+				tuiFlags(fs)
+				fs.BoolVar(&resume, "resume", false, "finish an interrupted review, disposing of the dupes")
+				fs.BoolVar(&restore, "restore", false, "roll back an interrupted review, like undo")
+			},
+			run: func(fs *flag.FlagSet) int { return runReview(dirArg(fs), resume, restore) },
+		},
+		{
+			name:    "undo",
+			args:    "[dir]",
+			summary: "roll back an interrupted review, keeping every image",
+			usesDir: true,
+			run:     func(fs *flag.FlagSet) int { return runUndo(dirArg(fs)) },
+		},
+		{
+			name:    "report",
+			args:    "[dir]",
+			summary: "write a report of the dupes without changing anything, as CSV to stdout by default",
+			usesDir: true,
+			flags:   reportFlags,
+			run:     func(fs *flag.FlagSet) int { return runReport(dirArg(fs)) },
+		},
+		{
+			name:    "verify",
+			args:    "[dir]",
+			summary: "rehash the renamed images and list the ones that changed since",
+			usesDir: true,
+			run:     func(fs *flag.FlagSet) int { return runVerify(dirArg(fs)) },
+		},
+		{
+			name:    "count",
+			args:    "[dir]",
+			summary: "count the images without reading them",
+			usesDir: true,
+			flags: func(fs *flag.FlagSet) {
+				jsonFlag(fs, "counts")
+			},
+			run: func(fs *flag.FlagSet) int { return runCount(dirArg(fs)) },
+		},
+		{
+			name:    "stats",
+			args:    "[dir]",
+			summary: "show the totals of every run so far, or only the runs in dir",
+			flags: func(fs *flag.FlagSet) {
+				jsonFlag(fs, "stats")
+			},
+			run: func(fs *flag.FlagSet) int { return runStats(fs.Arg(0)) },
+		},
+		{
+			name:    "migrate",
+			args:    "[dir]",
+			summary: "rehash the images renamed with another hash length, prefix or algorithm",
+			details: "Names don't show the algorithm, so it's taken from the run history of\n" +
+				"the directory. Images renamed before the first recorded run are assumed\n" +
+				"to use the configured algorithm.",
+			usesDir: true,
+			tui:     true,
+			flags:   func(fs *flag.FlagSet) { reportFlags(fs); tuiFlags(fs) },
+			run:     func(fs *flag.FlagSet) int { return run(dirArg(fs), true, ui.ReviewAsk) },
+		},
+		{
+			name:    "extract",
+			args:    "[dir]",
+			summary: "extract the images inside archives that aren't dupes",
+			usesDir: true,
+			run:     func(fs *flag.FlagSet) int { return runExtract(dirArg(fs)) },
+		},
+		{
+			name:    "watch",
+			args:    "[dir]",
+			summary: "dedupe images as they arrive, until interrupted",
+			usesDir: true,
+			flags: func(fs *flag.FlagSet) {
+				fs.DurationVar(&debounce, "debounce", 2*time.Second, "how long to wait for new images to stop arriving")
+			},
+			run: func(fs *flag.FlagSet) int { return runWatch(dirArg(fs)) },
+		},
+		{
+			name:    "serve",
+			args:    "[dir]",
+			summary: "serve a web UI to review and dedupe images",
+			usesDir: true,
+			flags: func(fs *flag.FlagSet) {
+				fs.StringVar(
+					&addr,
+					"addr",
+					"localhost:8080",
+					"`address` to listen on, which is only reachable from this machine by default",
+				)
+			},
+			run: func(fs *flag.FlagSet) int { return runServe(dirArg(fs)) },
+		},
+		{
+			name:    "version",
+			summary: "print the version and how hashimg was built",
+			flags: func(fs *flag.FlagSet) {
+				jsonFlag(fs, "build info")
+			},
+			run: func(*flag.FlagSet) int { return runVersion() },
+		},
+		{
+			name:    "completion",
+			args:    "bash|zsh|fish",
+			summary: "print the shell completion script",
+			run:     func(fs *flag.FlagSet) int { return runCompletion(os.Stdout, fs.Arg(0)) },
+		},
+		{
+			name:    "help",
+			args:    "[command]",
+			summary: "print the help of a command",
+			run:     func(fs *flag.FlagSet) int { return runHelp(fs.Arg(0)) },
+		},
+	}
+}
+
+/*
+runCommand runs the command named by the first argument, which is dedupe
+when the arguments start with a flag, or there are none.
+*/
+func runCommand(args []string) int {
+	if len(args) > 0 && isHelpFlag(args[0]) {
+		printUsage(os.Stdout)
+		return 0
+	}
+	if len(args) > 0 && isVersionFlag(args[0]) {
+		return runVersion()
+	}
+
+	name := defaultCommand
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	cmd, ok := findCommand(name)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q, run \"hashimg help\" to list the commands\n", name)
+		return 2
+	}
+
+	fs := cmd.flagSet()
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	// Parsing stops at the first argument, so flags after it end up here
+	if extra := fs.Args()[min(fs.NArg(), cmd.maxArgs()):]; len(extra) > 0 {
+		fmt.Fprintf(os.Stderr, "Unexpected arguments %q, flags must come before the arguments\n\n", extra)
+		cmd.printUsage(os.Stderr)
+		return 2
+	}
+
+	if cmd.usesDir {
+		// Resumed and restored reviews are the only ones without the terminal UI
+		closeLog, err := setupLogging(!cmd.tui || resume || restore)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		defer closeLog()
+		if logger != nil {
+			logger.Info("command started", "command", cmd.name, "args", args)
+		}
+		defer skipped.print()
+	}
+	return cmd.run(fs)
+}
+
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+func (cmd command) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	if cmd.flags != nil {
+		cmd.flags(fs)
+	}
+	if cmd.usesDir {
+		globalFlags(fs)
+	}
+	fs.Usage = func() { cmd.printUsage(fs.Output()) }
+	return fs
+}
+
+// maxArgs is how many arguments the command takes after its flags
+func (cmd command) maxArgs() int {
+	if cmd.args == "" {
+		return 0
+	}
+	return 1
+}
+
+// printUsage lists the flags of the command apart from the global ones
+func (cmd command) printUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: hashimg %s", cmd.name)
+	if cmd.flags != nil || cmd.usesDir {
+		fmt.Fprint(w, " [flags]")
+	}
+	if cmd.args != "" {
+		fmt.Fprint(w, " "+cmd.args)
+	}
+	fmt.Fprintf(w, "\n\n%s%s.\n", strings.ToUpper(cmd.summary[:1]), cmd.summary[1:])
+	if cmd.details != "" {
+		fmt.Fprintln(w, "\n"+cmd.details)
+	}
+
+	if cmd.flags != nil {
+		local := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+		cmd.flags(local)
+		local.SetOutput(w)
+		fmt.Fprintln(w, "\nFlags:")
+		local.PrintDefaults()
+	}
+	if cmd.usesDir {
+		printGlobalFlags(w)
+	}
+}
+
+func printGlobalFlags(w io.Writer) {
+	global := flag.NewFlagSet("global", flag.ContinueOnError)
+	globalFlags(global)
+	global.SetOutput(w)
+	fmt.Fprintln(w, "\nGlobal flags:")
+	global.PrintDefaults()
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprint(w, "Usage: hashimg [command] [flags] [dir]\n\n")
+	fmt.Fprint(w, "Finds duplicate images in dir, or the current directory, by hashing them.\n\n")
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-11s %s\n", cmd.name, cmd.summary)
+	}
+	printGlobalFlags(w)
+	fmt.Fprintln(w, "\nRun \"hashimg help <command>\" for the flags of a command, or \"hashimg --version\" for the version.")
+}
+
+func runHelp(name string) int {
+	if name == "" {
+		printUsage(os.Stdout)
+		return 0
+	}
+	cmd, ok := findCommand(name)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n", name)
+		return 2
+	}
+	cmd.printUsage(os.Stdout)
+	return 0
+}
+
+func isHelpFlag(arg string) bool {
+	return arg == "-h" || arg == "-help" || arg == "--help"
+}
+
+func isVersionFlag(arg string) bool {
+	return arg == "-version" || arg == "--version"
+}
+
+// dirArg is the directory to scan, which is the current one by default
+func dirArg(fs *flag.FlagSet) string {
+	if fs.NArg() == 0 {
+		return "."
+	}
+	return fs.Arg(0)
+}
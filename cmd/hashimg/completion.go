@@ -0,0 +1,208 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+/*
+runCompletion prints the completion script of the shell, which is built
+from the commands and their flags, so it never goes out of date.
+*/
+func runCompletion(w io.Writer, shell string) int {
+	switch shell {
+	case "bash":
+		writeBashCompletion(w)
+	case "zsh":
+		writeZshCompletion(w)
+	case "fish":
+		writeFishCompletion(w)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown shell %q, expected bash, zsh or fish\n", shell)
+		return 2
+	}
+	return 0
+}
+
+type completionFlag struct {
+	name  string
+	usage string
+	// Flags that aren't booleans take a value, like a file
+	valueName string
+}
+
+func (cmd command) completionFlags() []completionFlag {
+	flags := []completionFlag{}
+	fs := cmd.flagSet()
+	fs.VisitAll(func(f *flag.Flag) {
+		valueName, usage := flag.UnquoteUsage(f)
+		flags = append(flags, completionFlag{name: f.Name, usage: usage, valueName: valueName})
+	})
+	return flags
+}
+
+// completesWords are the arguments of commands that don't take a directory
+func (cmd command) completesWords() []string {
+	switch cmd.name {
+	case "completion":
+		return []string{"bash", "zsh", "fish"}
+	case "help":
+		return commandNames()
+	}
+	return nil
+}
+
+func commandNames() []string {
+	names := make([]string, 0, len(commands))
+	for _, cmd := range commands {
+		names = append(names, cmd.name)
+	}
+	return names
+}
+
+func writeBashCompletion(w io.Writer) {
+	fmt.Fprintf(w, `# bash completion for hashimg
+_hashimg() {
+    local cur=${COMP_WORDS[COMP_CWORD]}
+    if [[ $COMP_CWORD -eq 1 && $cur != -* ]]; then
+        COMPREPLY=($(compgen -W "%s" -- "$cur"))
+        return
+    fi
+
+    local cmd=%s
+    if [[ ${COMP_WORDS[1]} != -* ]]; then
+        cmd=${COMP_WORDS[1]}
+    fi
+
+    if [[ $cur == -* ]]; then
+        case $cmd in
+`, strings.Join(commandNames(), " "), defaultCommand)
+
+	for _, cmd := range commands {
+		names := []string{}
+		for _, f := range cmd.completionFlags() {
+			names = append(names, "--"+f.name)
+		}
+		if len(names) > 0 {
+			fmt.Fprintf(w, "            %s) COMPREPLY=($(compgen -W \"%s\" -- \"$cur\")) ;;\n", cmd.name, strings.Join(names, " "))
+		}
+	}
+
+	fmt.Fprint(w, `        esac
+        return
+    fi
+
+    case $cmd in
+`)
+	for _, cmd := range commands {
+		if words := cmd.completesWords(); words != nil {
+			fmt.Fprintf(w, "        %s) COMPREPLY=($(compgen -W \"%s\" -- \"$cur\")) ;;\n", cmd.name, strings.Join(words, " "))
+		}
+	}
+	fmt.Fprint(w, `        *) COMPREPLY=($(compgen -d -- "$cur")) ;;
+    esac
+}
+complete -o filenames -F _hashimg hashimg
+`)
+}
+
+func writeZshCompletion(w io.Writer) {
+	fmt.Fprint(w, `#compdef hashimg
+
+_hashimg() {
+    local -a commands
+    commands=(
+`)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "        '%s:%s'\n", cmd.name, zshEscape(cmd.summary))
+	}
+	fmt.Fprintf(w, `    )
+
+    if (( CURRENT == 2 )) && [[ $words[2] != -* ]]; then
+        _describe 'command' commands
+        return
+    fi
+
+    local cmd=%s
+    if [[ $words[2] != -* ]]; then
+        cmd=$words[2]
+        shift words
+        (( CURRENT-- ))
+    fi
+
+    case $cmd in
+`, defaultCommand)
+
+	for _, cmd := range commands {
+		specs := []string{}
+		for _, f := range cmd.completionFlags() {
+			spec := fmt.Sprintf("'--%s[%s]", f.name, zshEscape(f.usage))
+			switch f.valueName {
+			case "":
+			case "file":
+				spec += ":file:_files"
+			default:
+				spec += ":" + f.valueName + ": "
+			}
+			specs = append(specs, spec+"'")
+		}
+		switch {
+		case cmd.completesWords() != nil:
+			specs = append(specs, fmt.Sprintf("'1:%s:(%s)'", cmd.name, strings.Join(cmd.completesWords(), " ")))
+		case cmd.usesDir:
+			specs = append(specs, "'1:directory:_files -/'")
+		}
+		fmt.Fprintf(w, "        %s)\n            _arguments \\\n                %s ;;\n", cmd.name, strings.Join(specs, " \\\n                "))
+	}
+
+	fmt.Fprint(w, `    esac
+}
+
+compdef _hashimg hashimg
+`)
+}
+
+func writeFishCompletion(w io.Writer) {
+	fmt.Fprint(w, "# fish completion for hashimg\ncomplete -c hashimg -f\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "complete -c hashimg -n __fish_use_subcommand -a %s -d '%s'\n", cmd.name, fishEscape(cmd.summary))
+	}
+
+	for _, cmd := range commands {
+		condition := fmt.Sprintf("'__fish_seen_subcommand_from %s'", cmd.name)
+		// Flags of the default command work without naming it
+		if cmd.name == defaultCommand {
+			condition = fmt.Sprintf("'__fish_use_subcommand; or __fish_seen_subcommand_from %s'", cmd.name)
+		}
+
+		for _, f := range cmd.completionFlags() {
+			value := ""
+			switch f.valueName {
+			case "":
+			case "file":
+				value = " -r -F"
+			default:
+				value = " -r"
+			}
+			fmt.Fprintf(w, "complete -c hashimg -n %s -l %s%s -d '%s'\n", condition, f.name, value, fishEscape(f.usage))
+		}
+		switch {
+		case cmd.completesWords() != nil:
+			fmt.Fprintf(w, "complete -c hashimg -n %s -a '%s'\n", condition, strings.Join(cmd.completesWords(), " "))
+		case cmd.usesDir:
+			fmt.Fprintf(w, "complete -c hashimg -n %s -a '(__fish_complete_directories)'\n", condition)
+		}
+	}
+}
+
+// zshEscape escapes the characters that end a description in _arguments
+func zshEscape(s string) string {
+	return strings.NewReplacer("'", `'\''`, "[", `\[`, "]", `\]`, ":", `\:`).Replace(s)
+}
+
+func fishEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s)
+}
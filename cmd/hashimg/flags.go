@@ -0,0 +1,37 @@
+package main
+
+import "strings"
+
+// extensionList is a flag of comma separated extensions, which can be
+// repeated. The leading dot is optional.
+type extensionList []string
+
+func (el *extensionList) String() string {
+	return strings.Join(*el, ",")
+}
+
+func (el *extensionList) Set(value string) error {
+	for _, ext := range strings.Split(value, ",") {
+		ext = strings.TrimSpace(ext)
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		*el = append(*el, ext)
+	}
+	return nil
+}
+
+// patternList is a flag that can be repeated to add more patterns.
+type patternList []string
+
+func (pl *patternList) String() string {
+	return strings.Join(*pl, " ")
+}
+
+func (pl *patternList) Set(value string) error {
+	*pl = append(*pl, value)
+	return nil
+}
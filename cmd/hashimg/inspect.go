@@ -0,0 +1,256 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/jaeiya/hashimg"
+	"github.com/jaeiya/hashimg/lib"
+	"github.com/jaeiya/hashimg/lib/ui"
+	"github.com/jaeiya/hashimg/lib/utils"
+)
+
+// The commands in this file never change any image.
+
+// runScan lists what dedupe would do to dir.
+func runScan(dir string) int {
+	scanner, lock, ok := openDir(dir, hashimg.WithHDD(driveIsHDD(dir)))
+	if !ok {
+		return 1
+	}
+	defer lock.Unlock()
+	dir = scanner.Dir()
+
+	plan, err := scanner.Scan()
+	if errors.Is(err, hashimg.ErrNoImages) {
+		fmt.Println(ui.CautionStyle.Render("No images found in " + dir))
+		return 0
+	}
+	if err != nil {
+		fmt.Println(ui.CautionStyle.Render(err.Error()))
+		return 1
+	}
+
+	dupes := 0
+	for _, g := range plan.Groups {
+		fmt.Printf("%s\n  keep %s -> %s\n", g.Hash, relPath(dir, g.Keeper.Path), g.KeeperName)
+		for _, dupe := range g.Dupes {
+			fmt.Printf("  dupe %s\n", relPath(dir, dupe.Path))
+		}
+		dupes += len(g.Dupes)
+	}
+	for _, r := range plan.Renames {
+		fmt.Printf("rename %s -> %s\n", relPath(dir, r.Path), filepath.Base(r.Target))
+	}
+
+	savings := fmt.Sprintf("reclaim %s", lib.ByteSize(plan.ReclaimableBytes()))
+	if plan.ReclaimableBytes() == 0 && plan.DisposableBytes() > 0 {
+		savings = fmt.Sprintf("move %s to the disposal folder", lib.ByteSize(plan.DisposableBytes()))
+	}
+	fmt.Printf(
+		"\n%d dupe(s) in %d group(s) and %d new image(s), which would %s. Nothing was changed.\n",
+		dupes,
+		len(plan.Groups),
+		len(plan.Renames),
+		savings,
+	)
+	printArchiveDupes(plan.Archived, dir)
+	return 0
+}
+
+// runReport writes the reports of dir, as CSV to stdout when none was asked for.
+func runReport(dir string) int {
+	if csvReport == "" && htmlReport == "" && jsonStatus == "" {
+		csvReport = "-"
+	}
+	scanner, lock, ok := openDir(
+		dir,
+		hashimg.WithHDD(driveIsHDD(dir)),
+		hashimg.WithReport(htmlReport != ""),
+	)
+	if !ok {
+		return 1
+	}
+	defer lock.Unlock()
+
+	ip, err := scanner.NewProcessor()
+	if err == nil {
+		err = ip.ProcessImages(scanner.IsHDD())
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, ui.CautionStyle.Render(err.Error()))
+		return 1
+	}
+
+	if err := writeReports(ip.Report); err != nil {
+		fmt.Fprintln(os.Stderr, "Error writing report:", err)
+		return 1
+	}
+	if err := writeStatus(ip.Status); err != nil {
+		fmt.Fprintln(os.Stderr, "Error writing JSON:", err)
+		return 1
+	}
+	return 0
+}
+
+// runVerify exits with 1 when a renamed image changed since it was renamed.
+func runVerify(dir string) int {
+	scanner, lock, ok := openDir(dir, hashimg.WithHDD(driveIsHDD(dir)))
+	if !ok {
+		return 1
+	}
+	defer lock.Unlock()
+
+	mismatches, err := scanner.Verify()
+	if errors.Is(err, hashimg.ErrNoImages) {
+		fmt.Println(ui.CautionStyle.Render("No images found in " + scanner.Dir()))
+		return 0
+	}
+	if err != nil {
+		fmt.Println(ui.CautionStyle.Render(err.Error()))
+		return 1
+	}
+
+	if len(mismatches) == 0 {
+		fmt.Println("Every renamed image still matches its hash")
+		return 0
+	}
+	fmt.Println(ui.CautionStyle.Render(
+		fmt.Sprintf("%d image(s) changed since they were renamed:", len(mismatches)),
+	))
+	for _, m := range mismatches {
+		fmt.Printf("  %s now hashes to %s\n", relPath(scanner.Dir(), m.Path), m.Hash)
+	}
+	return 1
+}
+
+func runCount(dir string) int {
+	scanner, ok := newScanner(dir)
+	if !ok {
+		return 2
+	}
+
+	stats, err := scanner.Stats()
+	if err != nil && !errors.Is(err, hashimg.ErrNoImages) {
+		fmt.Println(ui.CautionStyle.Render(err.Error()))
+		return 1
+	}
+
+	if err := writeJSON(stats); err != nil {
+		fmt.Println("Error writing JSON:", err)
+		return 1
+	}
+	// The JSON would be mixed up with the text otherwise
+	if jsonStatus == "-" {
+		return 0
+	}
+
+	fmt.Printf("Images:   %d (%d bytes)\n", stats.Images, stats.Bytes)
+	fmt.Printf("Cached:   %d\n", stats.Cached)
+	fmt.Printf("New:      %d\n", stats.New)
+	fmt.Printf("Stale:    %d\n", stats.Stale)
+	fmt.Printf("Videos:   %d\n", stats.Videos)
+	if stats.Archived > 0 {
+		fmt.Printf("Archived: %d\n", stats.Archived)
+	}
+	return 0
+}
+
+/*
+runStats shows the totals of the run history, which only has the runs in
+dir when it's set.
+*/
+func runStats(dir string) int {
+	records, err := hashimg.ReadHistory()
+	if err != nil {
+		fmt.Println(ui.CautionStyle.Render("Cannot read the history: " + err.Error()))
+		return 1
+	}
+
+	if dir != "" {
+		if dir, err = filepath.Abs(dir); err != nil {
+			fmt.Println(err)
+			return 2
+		}
+		records = slices.DeleteFunc(records, func(rec hashimg.RunRecord) bool {
+			return rec.Dir != dir
+		})
+	}
+	stats := hashimg.SummarizeHistory(records)
+
+	if err := writeJSON(stats); err != nil {
+		fmt.Println("Error writing JSON:", err)
+		return 1
+	}
+	if jsonStatus == "-" {
+		return 0
+	}
+	fmt.Print(ui.RenderHistory(stats))
+	return 0
+}
+
+func runVersion() int {
+	info := lib.GetBuildInfo()
+	if err := writeJSON(info); err != nil {
+		fmt.Println("Error writing JSON:", err)
+		return 1
+	}
+	// The JSON would be mixed up with the text otherwise
+	if jsonStatus == "-" {
+		return 0
+	}
+
+	commit := info.Commit
+	if commit == "" {
+		commit = "unknown"
+	}
+	if info.Modified {
+		commit += " (modified)"
+	}
+	buildDate := info.BuildDate
+	if buildDate == "" {
+		buildDate = "unknown"
+	}
+
+	fmt.Printf("hashimg %s\n", info.Version)
+	fmt.Printf("Commit:   %s\n", commit)
+	fmt.Printf("Built:    %s\n", buildDate)
+	fmt.Printf("Go:       %s\n", info.GoVersion)
+	fmt.Printf("Platform: %s/%s\n", info.OS, info.Arch)
+	if len(info.Deps) > 0 {
+		fmt.Println("Dependencies:")
+		for _, dep := range info.Deps {
+			if dep.Replace != "" {
+				fmt.Printf("  %s => %s %s\n", dep.Path, dep.Replace, dep.Version)
+				continue
+			}
+			fmt.Printf("  %s %s\n", dep.Path, dep.Version)
+		}
+	}
+	return 0
+}
+
+/*
+driveIsHDD decides the kind of drive without asking, for the commands
+that don't have a terminal UI.
+*/
+func driveIsHDD(dir string) bool {
+	switch drive {
+	case "hdd":
+		return true
+	case "ssd":
+		return false
+	}
+	info, err := utils.DetectDrive(dir)
+	return err == nil && info.Rotational
+}
+
+func relPath(dir, path string) string {
+	if rel, err := filepath.Rel(dir, path); err == nil {
+		return rel
+	}
+	return path
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/jaeiya/hashimg/lib"
+	"github.com/jaeiya/hashimg/lib/ui"
+)
+
+var (
+	logFormat = string(lib.LogText)
+	logLevel  = slog.LevelInfo
+	logStderr bool
+	// Logs the file operations of the running command, once it's set up
+	logger *slog.Logger
+)
+
+func logFlags(fs *flag.FlagSet) {
+	fs.StringVar(&logFormat, "log-format", logFormat, "`format` of the log: text or json")
+	fs.TextVar(&logLevel, "log-level", logLevel, "only log records at `level` or above: debug, info, warn or error")
+	fs.BoolVar(
+		&logStderr,
+		"log-stderr",
+		false,
+		"also log to stderr, unless the terminal UI is running",
+	)
+}
+
+/*
+setupLogging logs to the log file, and to stderr when asked to by a
+command without the terminal UI. A log file that can't be opened is
+only warned about, since it's no reason to stop deduping.
+*/
+func setupLogging(headless bool) (closeLog func(), err error) {
+	format := lib.LogFormat(logFormat)
+	if err := format.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %q", err, logFormat)
+	}
+
+	closeLog = func() {}
+	writers := []io.Writer{}
+	if file, err := lib.OpenLogFile(); err == nil {
+		writers = append(writers, file)
+		closeLog = func() { file.Close() }
+	} else {
+		fmt.Fprintln(os.Stderr, ui.CautionStyle.Render("Cannot open the log file: "+err.Error()))
+	}
+	if logStderr && headless {
+		writers = append(writers, os.Stderr)
+	}
+	if len(writers) == 0 {
+		return closeLog, nil
+	}
+
+	logger, err = lib.NewLogger(format, logLevel, writers...)
+	return closeLog, err
+}
@@ -1,47 +1,724 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/jaeiya/hashimg"
 	"github.com/jaeiya/hashimg/lib"
 	"github.com/jaeiya/hashimg/lib/ui"
+	"github.com/jaeiya/hashimg/lib/utils"
 )
 
 const (
-	hashPrefix       = "0x@"
-	hashLength       = 32
-	dupeReviewFolder = "__dupes"
+	interruptedReviewText = "An unfinished review was found. Run \"hashimg review --resume\"" +
+		" to finish it, or \"hashimg undo\" to roll it back."
+	lockedText = "Another hashimg%s is already running in this directory. If it" +
+		" crashed, run again with --break-lock."
+	staleCacheText = "%d image(s) were renamed with another hash length, prefix or algorithm, so" +
+		" their dupes can't be found. Run \"hashimg migrate\" to rehash them."
 )
 
+var (
+	csvReport      string
+	htmlReport     string
+	jsonStatus     string
+	termReview     bool
+	drive          string
+	preview        string
+	videos         bool
+	archives       bool
+	addr           string
+	debounce       time.Duration
+	breakLock      bool
+	enableExts     = extensionList{}
+	disableExts    = extensionList{}
+	excludes       = patternList{}
+	minSize        = lib.ByteSize(0)
+	maxSize        = lib.ByteSize(0)
+	modifiedAfter  = lib.FilterTime{}
+	modifiedBefore = lib.FilterTime{}
+)
+
+// globalFlags are shared by every command that scans a directory
+func globalFlags(fs *flag.FlagSet) {
+	fs.Var(&enableExts, "enable-ext", "treat files with these comma separated `extensions` as images")
+	fs.Var(&disableExts, "disable-ext", "ignore files with these comma separated `extensions`")
+	fs.Var(&excludes, "exclude", "skip files matching the gitignore-style `pattern`, can be repeated")
+	fs.TextVar(&minSize, "min-size", minSize, "skip images smaller than `size`, like 10KB")
+	fs.TextVar(&maxSize, "max-size", maxSize, "skip images larger than `size`, like 1.5GiB")
+	fs.TextVar(
+		&modifiedAfter,
+		"modified-after",
+		modifiedAfter,
+		"skip images modified before `date`, like 2024-01-31",
+	)
+	fs.TextVar(
+		&modifiedBefore,
+		"modified-before",
+		modifiedBefore,
+		"skip images modified after `date`, like 2024-01-31",
+	)
+	fs.BoolVar(&videos, "videos", false, "dedupe videos along with images")
+	fs.BoolVar(
+		&archives,
+		"archives",
+		false,
+		"find dupes of the images inside zip and tar archives, which are never changed",
+	)
+	fs.StringVar(&drive, "drive", "auto", "`kind` of drive the images are on: auto, hdd, ssd or ask")
+	fs.BoolVar(
+		&breakLock,
+		"break-lock",
+		false,
+		"remove the lock left behind by a hashimg that's no longer running",
+	)
+	logFlags(fs)
+}
+
+// reportFlags choose where the results of a scan are written
+func reportFlags(fs *flag.FlagSet) {
+	fs.StringVar(&csvReport, "csv", "", "write a CSV report of all duplicates to `file`, or to stdout when it's \"-\"")
+	fs.StringVar(&htmlReport, "html", "", "write an HTML report of all duplicates to `file`, or to stdout when it's \"-\"")
+	jsonFlag(fs, "results")
+}
+
+// jsonFlag writes what a command prints as JSON, like the results of a scan
+func jsonFlag(fs *flag.FlagSet, what string) {
+	fs.StringVar(&jsonStatus, "json", "", "write the "+what+" as JSON to `file`, or to stdout when it's \"-\"")
+}
+
+// tuiFlags change how the terminal UI reviews duplicates
+func tuiFlags(fs *flag.FlagSet) {
+	fs.BoolVar(
+		&termReview,
+		"terminal-review",
+		!utils.CanOpenFolder(),
+		"review duplicates in the terminal instead of opening a folder",
+	)
+	fs.StringVar(
+		&preview,
+		"preview",
+		"auto",
+		"image preview `protocol` for terminal reviews: auto, kitty, iterm, sixel or blocks",
+	)
+}
+
 func main() {
-	wd, _ := os.Getwd()
-	iMap, err := lib.MapImages(wd, hashPrefix)
+	os.Exit(runCommand(os.Args[1:]))
+}
+
+/*
+run dedupes dir in the terminal UI. It returns the exit code, so the
+directory is unlocked before exiting. Migrating rehashes the images that
+were renamed with another hash length, prefix or algorithm, along with
+the new images.
+*/
+func run(dir string, migrate bool, review ui.ReviewChoice) int {
+	graphics, err := ui.ParseGraphicsProtocol(preview)
+	if err != nil {
+		fmt.Println(err)
+		return 2
+	}
+
+	wd, err := filepath.Abs(dir)
 	if err != nil {
-		if errors.Is(err, lib.ErrNoImages) {
-			fmt.Println(ui.CautionStyle.Render("No images found in current directory"))
-			os.Exit(0)
-			return
+		fmt.Println(err)
+		return 2
+	}
+	opts := []hashimg.Option{
+		hashimg.WithMigrate(migrate),
+		hashimg.WithOpenReviewFolder(!termReview),
+	}
+	if csvReport != "" || htmlReport != "" {
+		opts = append(opts, hashimg.WithReport(htmlReport != ""))
+	}
+	scanner, ok := newScanner(wd, opts...)
+	if !ok {
+		return 2
+	}
+
+	lock, ok := lockDir(wd, breakLock)
+	if !ok {
+		return 1
+	}
+	defer lock.Unlock()
+
+	if scanner.HasInterruptedReview() {
+		fmt.Println(ui.CautionStyle.Render(interruptedReviewText))
+		return 1
+	}
+
+	stale, err := scanner.StaleImages()
+	if err != nil {
+		if errors.Is(err, hashimg.ErrNoImages) {
+			fmt.Println(ui.CautionStyle.Render("No images found in " + wd))
+			return 0
 		}
-		panic(err)
+		fmt.Println(ui.CautionStyle.Render("Cannot find images: " + err.Error()))
+		return 1
+	}
+	if migrate && len(stale) == 0 {
+		fmt.Println(ui.CautionStyle.Render("Nothing to migrate"))
+		return 0
 	}
 
-	imgProcessor := lib.NewImageProcessor(
-		lib.ImageProcessorConfig{
-			WorkingDir:       wd,
-			Prefix:           hashPrefix,
-			ImageMap:         iMap,
-			HashLength:       hashLength,
-			DupeReviewFolder: dupeReviewFolder,
-			OpenReviewFolder: true,
-		},
-	)
+	imgProcessor, err := scanner.NewProcessor()
+	if err != nil {
+		fmt.Println(ui.CautionStyle.Render("Cannot find images: " + err.Error()))
+		return 1
+	}
+
+	tuiCfg := ui.TuiConfig{
+		TerminalReview: termReview,
+		Graphics:       graphics,
+		Review:         review,
+	}
+	if err := setDrive(&tuiCfg, wd); err != nil {
+		fmt.Println(err)
+		return 2
+	}
 
-	tui := ui.NewTUI(imgProcessor)
+	tui := ui.NewTUI(imgProcessor, tuiCfg)
 
+	startedAt := time.Now()
 	if _, err := tea.NewProgram(tui).Run(); err != nil {
 		fmt.Println("Error running program:", err)
 	}
+	recordRun(scanner, imgProcessor, startedAt)
+
+	// The user quit in the middle of reviewing
+	if scanner.HasInterruptedReview() {
+		fmt.Println(ui.CautionStyle.Render(interruptedReviewText))
+	}
+
+	printConflicts(imgProcessor.Conflicts, wd)
+	printArchiveDupes(imgProcessor.Archived, wd)
+
+	if !migrate && len(stale) > 0 {
+		fmt.Println(ui.CautionStyle.Render(fmt.Sprintf(staleCacheText, len(stale))))
+	}
+
+	if err := writeReports(imgProcessor.Report); err != nil {
+		fmt.Println("Error writing report:", err)
+		return 1
+	}
+
+	if err := writeStatus(imgProcessor.Status); err != nil {
+		fmt.Println("Error writing JSON:", err)
+		return 1
+	}
+
+	return 0
+}
+
+/*
+recordRun adds the run to the history, once the images were updated.
+The history is only for stats, so failing to write it isn't fatal.
+*/
+func recordRun(scanner *hashimg.Scanner, ip *hashimg.Processor, startedAt time.Time) {
+	if err := scanner.RecordRun(ip, startedAt); err != nil {
+		fmt.Println(ui.CautionStyle.Render("Cannot record the run: " + err.Error()))
+	}
+}
+
+/*
+newScanner prints why the config or the flags are invalid. Every command
+checks the cached images against the algorithm in the history, so they
+agree on which images are stale.
+*/
+func newScanner(dir string, opts ...hashimg.Option) (*hashimg.Scanner, bool) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		fmt.Println(err)
+		return nil, false
+	}
+	opts = append(
+		append(scanOptions(), hashimg.WithCachedAlgorithm(hashimg.CachedAlgorithm(abs))),
+		opts...,
+	)
+	scanner, err := hashimg.New(abs, opts...)
+	if err != nil {
+		fmt.Println(ui.CautionStyle.Render("Invalid config: " + err.Error()))
+		return nil, false
+	}
+	return scanner, true
+}
+
+/*
+scanOptions loads the config files, then overrides them with the flags
+that choose which files are processed.
+*/
+func scanOptions() []hashimg.Option {
+	opts := []hashimg.Option{
+		hashimg.WithConfigFiles(),
+		hashimg.WithDisabledExtensions(disableExts...),
+		// Enabling an extension undoes disabling it
+		hashimg.WithEnabledExtensions(enableExts...),
+		hashimg.WithIgnore(excludes...),
+		hashimg.WithLogger(logger),
+		hashimg.WithOnSkip(skipped.add),
+	}
+	if videos {
+		opts = append(opts, hashimg.WithVideos(true))
+	}
+	if archives {
+		opts = append(opts, hashimg.WithArchives(true))
+	}
+	if minSize != 0 {
+		opts = append(opts, hashimg.WithMinSize(minSize))
+	}
+	if maxSize != 0 {
+		opts = append(opts, hashimg.WithMaxSize(maxSize))
+	}
+	if !modifiedAfter.IsZero() {
+		opts = append(opts, hashimg.WithModifiedAfter(modifiedAfter.Time))
+	}
+	if !modifiedBefore.IsZero() {
+		opts = append(opts, hashimg.WithModifiedBefore(modifiedBefore.Time))
+	}
+	return opts
+}
+
+/*
+lockDir locks the working directory for the whole run, so that two
+instances never rename or delete the same files. Stale locks are
+broken first when asked to.
+*/
+func lockDir(wd string, breakStale bool) (*utils.DirLock, bool) {
+	if breakStale {
+		if err := utils.BreakLock(wd); err != nil {
+			fmt.Println(ui.CautionStyle.Render("Cannot break lock: " + err.Error()))
+			return nil, false
+		}
+	}
+
+	lock, err := utils.LockDir(wd)
+	if err == nil {
+		return lock, true
+	}
+
+	var lockErr *utils.LockError
+	if errors.As(err, &lockErr) {
+		holder := ""
+		if lockErr.PID != 0 {
+			holder = fmt.Sprintf(" (PID %d)", lockErr.PID)
+		}
+		fmt.Println(ui.CautionStyle.Render(fmt.Sprintf(lockedText, holder)))
+		return nil, false
+	}
+
+	fmt.Println(ui.CautionStyle.Render("Cannot lock directory: " + err.Error()))
+	return nil, false
+}
+
+/*
+setDrive sets the kind of drive from the flag, detecting it when asked
+to. When detection fails, the user is asked instead.
+*/
+func setDrive(cfg *ui.TuiConfig, wd string) error {
+	switch drive {
+	case "hdd":
+		cfg.Drive = ui.DriveHDD
+		return nil
+	case "ssd":
+		cfg.Drive = ui.DriveSSD
+		return nil
+	case "auto", "ask":
+		cfg.AskDrive = drive == "ask"
+	default:
+		return fmt.Errorf("unknown drive kind: %q", drive)
+	}
+
+	info, err := utils.DetectDrive(wd)
+	if err != nil {
+		return nil
+	}
+
+	cfg.DriveName = info.Name
+	cfg.Drive = ui.DriveSSD
+	if info.Rotational {
+		cfg.Drive = ui.DriveHDD
+	}
+	return nil
+}
+
+/*
+runReview dedupes dir after the user reviewed the dupes, or finishes an
+interrupted review when resuming. Restoring rolls it back instead, like
+undo does.
+*/
+func runReview(dir string, resume, restore bool) int {
+	switch {
+	case resume && restore:
+		fmt.Println(ui.CautionStyle.Render("Cannot both resume and restore a review"))
+		return 2
+	case resume:
+		return finishReview(dir, (*hashimg.Scanner).ResumeReview, "Review finished")
+	case restore:
+		return runUndo(dir)
+	}
+	return run(dir, false, ui.ReviewAlways)
+}
+
+// runUndo rolls back an interrupted review, keeping every image.
+func runUndo(dir string) int {
+	return finishReview(dir, (*hashimg.Scanner).RollbackReview, "Review rolled back")
+}
+
+// finishReview resumes or rolls back the interrupted review of dir
+func finishReview(
+	dir string,
+	finish func(*hashimg.Scanner) error,
+	done string,
+) int {
+	scanner, ok := newScanner(dir)
+	if !ok {
+		return 2
+	}
+
+	lock, ok := lockDir(scanner.Dir(), breakLock)
+	if !ok {
+		return 1
+	}
+	defer lock.Unlock()
+
+	if err := finish(scanner); err != nil {
+		fmt.Println(ui.CautionStyle.Render(err.Error()))
+		return 1
+	}
+	fmt.Println(ui.CautionStyle.Render(done))
+	return 0
+}
+
+/*
+skippedFiles are the files that couldn't be read, by path. Images are
+found more than once in a run, so each is only warned about once.
+*/
+type skippedFiles struct {
+	mux   sync.Mutex
+	files map[string]error
+}
+
+var skipped = &skippedFiles{files: map[string]error{}}
+
+func (sf *skippedFiles) add(path string, err error) {
+	sf.mux.Lock()
+	defer sf.mux.Unlock()
+	sf.files[path] = err
+}
+
+/*
+print warns about the skipped files on stderr, so that the output of
+commands printing JSON stays valid.
+*/
+func (sf *skippedFiles) print() {
+	sf.mux.Lock()
+	defer sf.mux.Unlock()
+	paths := make([]string, 0, len(sf.files))
+	for path := range sf.files {
+		paths = append(paths, path)
+	}
+	if len(paths) == 0 {
+		return
+	}
+	slices.Sort(paths)
+
+	fmt.Fprintln(os.Stderr, ui.CautionStyle.Render(
+		fmt.Sprintf("%d file(s) were skipped, because they can't be read:", len(paths)),
+	))
+	for _, path := range paths {
+		fmt.Fprintf(os.Stderr, "  %s: %v\n", path, sf.files[path])
+	}
+}
+
+// printConflicts lists the images that weren't renamed, because their name was taken
+func printConflicts(conflicts []hashimg.Conflict, wd string) {
+	if len(conflicts) == 0 {
+		return
+	}
+
+	fmt.Println(ui.CautionStyle.Render(
+		fmt.Sprintf("%d image(s) kept their name, because it was taken:", len(conflicts)),
+	))
+	for _, c := range conflicts {
+		path, _ := filepath.Rel(wd, c.Path)
+		target, _ := filepath.Rel(wd, c.Target)
+		fmt.Printf("  %s -> %s\n", path, target)
+	}
+}
+
+// printArchiveDupes lists the images inside archives that are dupes
+func printArchiveDupes(archived []hashimg.ArchivedImage, wd string) {
+	dupes := []hashimg.ArchivedImage{}
+	for _, ai := range archived {
+		if ai.DupeOf != "" {
+			dupes = append(dupes, ai)
+		}
+	}
+	if len(dupes) == 0 {
+		return
+	}
+
+	fmt.Println(ui.CautionStyle.Render(
+		fmt.Sprintf("%d image(s) inside archives are dupes:", len(dupes)),
+	))
+	for _, ai := range dupes {
+		path, _ := filepath.Rel(wd, ai.Path())
+		dupeOf, _ := filepath.Rel(wd, ai.DupeOf)
+		fmt.Printf("  %s = %s\n", path, dupeOf)
+	}
+}
+
+/*
+openDir locks dir for commands that keep running, like watch and serve,
+once it's sure that there's no unfinished review.
+*/
+func openDir(dir string, opts ...hashimg.Option) (*hashimg.Scanner, *utils.DirLock, bool) {
+	if dir == "" {
+		dir = "."
+	}
+	scanner, ok := newScanner(dir, opts...)
+	if !ok {
+		return nil, nil, false
+	}
+
+	lock, ok := lockDir(scanner.Dir(), breakLock)
+	if !ok {
+		return nil, nil, false
+	}
+
+	if scanner.HasInterruptedReview() {
+		fmt.Println(ui.CautionStyle.Render(interruptedReviewText))
+		lock.Unlock()
+		return nil, nil, false
+	}
+	return scanner, lock, true
+}
+
+/*
+runWatch dedupes images as they arrive in dir, until it's interrupted or
+terminated. Images that arrived before stopping are still processed.
+*/
+func runWatch(dir string) int {
+	scanner, lock, ok := openDir(dir, hashimg.WithHistory())
+	if !ok {
+		return 1
+	}
+	defer lock.Unlock()
+	dir = scanner.Dir()
+
+	watcher, err := scanner.NewWatcher(debounce, func(stats hashimg.WatchStats, err error) {
+		if err != nil {
+			fmt.Printf("\r\033[K%s\n", ui.CautionStyle.Render("Error: "+err.Error()))
+		}
+		printWatchStatus(dir, stats)
+	})
+	if err != nil {
+		fmt.Println(ui.CautionStyle.Render("Cannot watch directory: " + err.Error()))
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	printWatchStatus(dir, watcher.Stats)
+	err = watcher.Run(ctx)
+	fmt.Println()
+	if err != nil {
+		fmt.Println(ui.CautionStyle.Render(err.Error()))
+		return 1
+	}
+	return 0
+}
+
+/*
+runExtract extracts the images inside the archives in dir that aren't
+dupes, named after their hash. Nothing else is changed.
+*/
+func runExtract(dir string) int {
+	scanner, lock, ok := openDir(dir, hashimg.WithArchives(true))
+	if !ok {
+		return 1
+	}
+	defer lock.Unlock()
+	dir = scanner.Dir()
+
+	plan, err := scanner.Scan()
+	if errors.Is(err, hashimg.ErrNoImages) {
+		fmt.Println(ui.CautionStyle.Render("No images found in " + dir))
+		return 0
+	}
+	if err != nil {
+		fmt.Println(ui.CautionStyle.Render(err.Error()))
+		return 1
+	}
+
+	extracted, conflicts, err := plan.ExtractNovel()
+	fmt.Printf("Extracted %d novel image(s) from archives\n", len(extracted))
+	if len(conflicts) > 0 {
+		fmt.Println(ui.CautionStyle.Render(
+			fmt.Sprintf("%d image(s) weren't extracted, because their name was taken:", len(conflicts)),
+		))
+		for _, c := range conflicts {
+			target, _ := filepath.Rel(dir, c.Target)
+			path, _ := filepath.Rel(dir, c.Path)
+			fmt.Printf("  %s -> %s\n", path, target)
+		}
+	}
+	printArchiveDupes(plan.Archived, dir)
+	if err != nil {
+		fmt.Println(ui.CautionStyle.Render(err.Error()))
+		return 1
+	}
+	return 0
+}
+
+/*
+runServe serves the web UI and API for dir, until it's interrupted or
+terminated. The token is read from $HASHIMG_TOKEN, so it doesn't show up
+in the process list, or generated when it's not set.
+*/
+func runServe(dir string) int {
+	token := os.Getenv("HASHIMG_TOKEN")
+	if token == "" {
+		var err error
+		if token, err = hashimg.NewToken(); err != nil {
+			fmt.Println(err)
+			return 1
+		}
+	}
+
+	isHDD := false
+	if info, err := utils.DetectDrive(dir); err == nil {
+		isHDD = info.Rotational
+	}
+
+	scanner, lock, ok := openDir(dir, hashimg.WithHDD(isHDD), hashimg.WithHistory())
+	if !ok {
+		return 1
+	}
+	defer lock.Unlock()
+	dir = scanner.Dir()
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		fmt.Println(ui.CautionStyle.Render("Cannot listen: " + err.Error()))
+		return 1
+	}
+
+	api := scanner.NewServer(token)
+	srv := &http.Server{
+		Handler:           api.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	shutdown := make(chan struct{})
+	go func() {
+		defer close(shutdown)
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Printf("Serving %s at http://%s/#token=%s\n", dir, listener.Addr(), token)
+	if err := srv.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		fmt.Println(ui.CautionStyle.Render(err.Error()))
+		return 1
+	}
+
+	// Serve returns as soon as Shutdown starts, while a scan or an update
+	// may still be moving files
+	<-shutdown
+	fmt.Println("Waiting for the running scan or update to finish...")
+	api.Close()
+	return 0
+}
+
+// printWatchStatus replaces the status line
+func printWatchStatus(dir string, stats hashimg.WatchStats) {
+	last := "waiting for images"
+	if !stats.LastBatch.IsZero() {
+		last = "last update " + stats.LastBatch.Format(time.TimeOnly)
+	}
+	status := fmt.Sprintf(
+		"Watching %s: %d new, %d dupes, %s reclaimed, %d conflicts, %s",
+		dir,
+		stats.NewImages,
+		stats.DupeImages,
+		lib.ByteSize(stats.ReclaimedBytes),
+		stats.Conflicts,
+		last,
+	)
+	fmt.Print("\r\033[K" + status)
+}
+
+func writeReports(r *hashimg.Report) error {
+	if r == nil {
+		return nil
+	}
+
+	if csvReport != "" {
+		if err := writeReport(csvReport, r.WriteCSV); err != nil {
+			return err
+		}
+	}
+
+	if htmlReport != "" {
+		if err := writeReport(htmlReport, r.WriteHTML); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeStatus(status *hashimg.Status) error {
+	return writeJSON(status)
+}
+
+// writeJSON writes v to the --json file, when it's set
+func writeJSON(v any) error {
+	if jsonStatus == "" {
+		return nil
+	}
+	return writeReport(jsonStatus, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	})
+}
+
+// writeReport writes to the file at path, or to stdout when it's "-"
+func writeReport(path string, write func(io.Writer) error) error {
+	if path == "-" {
+		return write(os.Stdout)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := write(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
 }
@@ -0,0 +1,291 @@
+/*
+Package hashimg finds duplicate images in a directory by hashing them,
+then disposes of the duplicates and renames the rest to their hash, so
+that the next scan doesn't need to read them again.
+
+A scan is planned first, so the plan can be changed before it's applied:
+
+	scanner, err := hashimg.New(dir, hashimg.WithKeeperPolicy(hashimg.KeepOldest))
+	if err != nil {
+		return err
+	}
+	plan, err := scanner.Scan()
+	if err != nil {
+		return err
+	}
+	for _, group := range plan.Groups {
+		fmt.Println(group.Keeper.Path, "has", len(group.Dupes), "dupes")
+	}
+	result, err := plan.Apply()
+*/
+package hashimg
+
+import (
+	"errors"
+	"path/filepath"
+	"time"
+
+	"github.com/jaeiya/hashimg/lib"
+	"github.com/jaeiya/hashimg/lib/models"
+	"github.com/jaeiya/hashimg/lib/server"
+)
+
+type (
+	HashAlgorithm = lib.HashAlgorithm
+	KeeperPolicy  = lib.KeeperPolicy
+	ByteSize      = lib.ByteSize
+	// Conflict is an image that wasn't renamed, because its name was taken
+	Conflict = lib.RenameConflict
+	// ArchivedImage is an image inside an archive, which is never changed
+	ArchivedImage = lib.ArchivedImage
+	// CacheMismatch is a cached image whose content doesn't match its name
+	CacheMismatch = lib.CacheMismatch
+	Stats         = lib.ImageStats
+	// Config is every setting of a scan, like a .hashimg file has
+	Config = lib.Config
+	// FS is the filesystem images are found in, like an OSFS or a MemFS
+	FS    = lib.FS
+	OSFS  = lib.OSFS
+	MemFS = lib.MemFS
+	// Status is the progress of a scan, along with its counts
+	Status = models.ProcessStatus
+	// Processor runs each step of a scan itself
+	Processor  = lib.ImageProcessor
+	Watcher    = lib.Watcher
+	WatchStats = lib.WatchStats
+	Server     = server.Server
+	// Report is every dupe group of a scan, for CSV and HTML reports
+	Report = lib.Report
+)
+
+const (
+	SHA256 = lib.SHA256
+	SHA512 = lib.SHA512
+	SHA1   = lib.SHA1
+	MD5    = lib.MD5
+
+	KeepFirst  = lib.KeepFirst
+	KeepOldest = lib.KeepOldest
+	KeepNewest = lib.KeepNewest
+)
+
+var (
+	ErrNoImages    = lib.ErrNoImages
+	ErrUnknownDupe = lib.ErrUnknownDupe
+	// Watching relies on OS events, which other filesystems don't have
+	ErrWatchFS = errors.New("only the OS filesystem can be watched")
+	// Review manifests are only kept on the OS filesystem
+	ErrReviewFS = errors.New("only reviews on the OS filesystem can be resumed")
+)
+
+// Scanner scans a single directory, without its subdirectories.
+type Scanner struct {
+	dir  string
+	cfg  lib.Config
+	opts options
+}
+
+// New checks the options, but doesn't touch any image yet.
+func New(dir string, opts ...Option) (*Scanner, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	cfg := lib.DefaultConfig()
+	switch {
+	case o.base != nil:
+		cfg = *o.base
+	case o.configFiles:
+		if cfg, err = lib.LoadConfig(dir); err != nil {
+			return nil, err
+		}
+	}
+	for _, override := range o.overrides {
+		override(&cfg)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &Scanner{dir: dir, cfg: cfg, opts: o}, nil
+}
+
+// NewToken is a random token for NewServer.
+func NewToken() (string, error) {
+	return server.NewToken()
+}
+
+// NewMemFS is an empty in-memory filesystem, for WithFS.
+func NewMemFS() *MemFS {
+	return lib.NewMemFS()
+}
+
+func (s *Scanner) Dir() string {
+	return s.dir
+}
+
+// Config is the config after every option was applied.
+func (s *Scanner) Config() Config {
+	return s.cfg
+}
+
+// IsHDD reports whether images are read like they're on a spinning disk.
+func (s *Scanner) IsHDD() bool {
+	return s.opts.isHDD
+}
+
+/*
+StaleImages returns the images that were renamed with another hash
+length, prefix or algorithm. Their dupes aren't found unless they're
+migrated.
+*/
+func (s *Scanner) StaleImages() ([]string, error) {
+	iMap, err := lib.MapImagesWithConfig(s.mapperConfig())
+	if err != nil {
+		return nil, err
+	}
+	return iMap.StaleCacheNames(s.cfg.Prefix, s.cfg.Length, s.algorithmChanged()), nil
+}
+
+// algorithmChanged reports whether the cached images used another algorithm
+func (s *Scanner) algorithmChanged() bool {
+	return s.opts.cachedAlgorithm != "" && s.opts.cachedAlgorithm != s.cfg.Algorithm
+}
+
+// Stats counts the images in the directory, without reading them.
+func (s *Scanner) Stats() (Stats, error) {
+	iMap, err := lib.MapImagesWithConfig(s.mapperConfig())
+	if err != nil {
+		return Stats{}, err
+	}
+	return iMap.Stats(s.mapperConfig(), s.cfg.Length)
+}
+
+/*
+Verify rehashes the cached images and returns the ones whose content no
+longer matches their name. Nothing is changed.
+*/
+func (s *Scanner) Verify() ([]CacheMismatch, error) {
+	ip, err := s.NewProcessor()
+	if err != nil {
+		return nil, err
+	}
+	return ip.VerifyCache(s.opts.isHDD)
+}
+
+/*
+NewProcessor finds the images and returns a processor for them, for
+front ends that run each step themselves, like the terminal UI.
+*/
+func (s *Scanner) NewProcessor() (*Processor, error) {
+	iMap, err := lib.MapImagesWithConfig(s.mapperConfig())
+	if err != nil {
+		return nil, err
+	}
+	if s.opts.migrate {
+		iMap.Migrate(s.cfg.Prefix, s.cfg.Length, s.algorithmChanged())
+	}
+
+	ipCfg := s.processorConfig()
+	ipCfg.ImageMap = iMap
+	return lib.NewImageProcessor(ipCfg), nil
+}
+
+// NewWatcher starts watching the directory for new images.
+func (s *Scanner) NewWatcher(
+	debounce time.Duration,
+	onBatch func(WatchStats, error),
+) (*Watcher, error) {
+	if s.opts.fs != nil {
+		return nil, ErrWatchFS
+	}
+	return lib.NewWatcher(lib.WatchConfig{
+		Mapper:    s.mapperConfig(),
+		Processor: s.processorConfig(),
+		Debounce:  debounce,
+		OnBatch:   onBatch,
+		OnUpdate:  s.onUpdate(),
+	})
+}
+
+// NewServer serves the web UI and API, which require the token.
+func (s *Scanner) NewServer(token string) *Server {
+	return server.New(server.Config{
+		Mapper:    s.mapperConfig(),
+		Processor: s.processorConfig(),
+		IsHDD:     s.opts.isHDD,
+		Token:     token,
+		OnUpdate:  s.onUpdate(),
+	})
+}
+
+// ReviewFolder is the folder dupes are reviewed in.
+func (s *Scanner) ReviewFolder() string {
+	return filepath.Join(s.dir, s.cfg.ReviewFolder)
+}
+
+/*
+HasInterruptedReview reports whether a review of the directory was
+interrupted. It has to be resumed or rolled back before scanning again,
+or the dupes inside the review folder would be lost.
+*/
+func (s *Scanner) HasInterruptedReview() bool {
+	if s.opts.fs != nil {
+		return false
+	}
+	_, err := lib.LoadReviewManifest(s.ReviewFolder())
+	return err == nil
+}
+
+/*
+ResumeReview finishes the interrupted review, disposing of the dupes
+that weren't kept.
+*/
+func (s *Scanner) ResumeReview() error {
+	if s.opts.fs != nil {
+		return ErrReviewFS
+	}
+	return lib.ResumeReview(s.ReviewFolder(), s.opts.logger)
+}
+
+// RollbackReview moves every file of the interrupted review back.
+func (s *Scanner) RollbackReview() error {
+	if s.opts.fs != nil {
+		return ErrReviewFS
+	}
+	return lib.RollbackReview(s.ReviewFolder(), s.opts.logger)
+}
+
+func (s *Scanner) mapperConfig() lib.MapperConfig {
+	mapperCfg := s.cfg.MapperConfig(s.dir)
+	mapperCfg.FS = s.opts.fs
+	mapperCfg.OnSkip = s.skip
+	return mapperCfg
+}
+
+// skip warns about a file that was skipped, because it can't be read
+func (s *Scanner) skip(path string, err error) {
+	if s.opts.logger != nil {
+		s.opts.logger.Warn("skipped", "path", path, "err", err)
+	}
+	if s.opts.onSkip != nil {
+		s.opts.onSkip(path, err)
+	}
+}
+
+func (s *Scanner) processorConfig() lib.ImageProcessorConfig {
+	ipCfg := s.cfg.ProcessorConfig(s.dir)
+	ipCfg.Readers = s.opts.readers
+	ipCfg.CollectReport = s.opts.collectReport
+	ipCfg.ReportThumbnails = s.opts.reportThumbnails
+	ipCfg.OpenReviewFolder = s.opts.openReviewFolder
+	ipCfg.FS = s.opts.fs
+	ipCfg.Logger = s.opts.logger
+	return ipCfg
+}
@@ -0,0 +1,217 @@
+package hashimg
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeImages(t *testing.T, images map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range images {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600))
+	}
+	return dir
+}
+
+func hashOf(content string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(content)))[:32]
+}
+
+func TestScanner(t *testing.T) {
+	t.Run("should reject invalid options", func(t *testing.T) {
+		t.Parallel()
+		_, err := New(t.TempDir(), WithHashLength(2))
+		assert.Error(t, err)
+		_, err = New(t.TempDir(), WithKeeperPolicy("largest"))
+		assert.Error(t, err)
+	})
+
+	t.Run("should apply later options over earlier ones", func(t *testing.T) {
+		t.Parallel()
+		s, err := New(
+			t.TempDir(),
+			WithDisabledExtensions(".PNG"),
+			WithEnabledExtensions(".png"),
+			WithPrefix("img_"),
+		)
+		require.NoError(t, err)
+		cfg := s.Config()
+		assert.Equal(t, "img_", cfg.Prefix)
+		assert.Empty(t, cfg.DisableExtensions)
+	})
+
+	t.Run("should plan and apply a scan", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		dir := writeImages(t, map[string]string{
+			"a.png": "dupe",
+			"b.PNG": "dupe",
+			"c.jpg": "novel",
+			"d.txt": "dupe",
+		})
+		s, err := New(dir)
+		require.NoError(t, err)
+
+		plan, err := s.Scan()
+		require.NoError(t, err)
+		require.Len(t, plan.Groups, 1)
+		group := plan.Groups[0]
+		a.Equal(hashOf("dupe"), group.Hash)
+		require.Len(t, group.Dupes, 1)
+		require.Len(t, plan.Renames, 1)
+		a.Equal(filepath.Join(dir, "0x@"+hashOf("novel")+".jpg"), plan.Renames[0].Target)
+
+		a.ErrorIs(plan.SetKeeper(group.Hash, filepath.Join(dir, "c.jpg")), ErrUnknownDupe)
+		// The dupe becomes the keeper
+		require.NoError(t, plan.SetKeeper(group.Hash, group.Dupes[0].Path))
+		a.Equal(group.Dupes[0].Path, plan.Groups[0].Keeper.Path)
+		a.Equal(group.Keeper.Path, plan.Groups[0].Dupes[0].Path)
+
+		result, err := plan.Apply()
+		require.NoError(t, err)
+		a.Len(result.Disposed, 1)
+		a.Len(result.Renamed, 2)
+		a.Empty(result.Conflicts)
+		for _, r := range result.Renamed {
+			a.FileExists(r.Target)
+		}
+		a.NoFileExists(plan.Groups[0].Dupes[0].Path)
+		a.FileExists(filepath.Join(dir, "d.txt"))
+
+		_, err = plan.Apply()
+		a.ErrorIs(err, ErrPlanApplied)
+	})
+
+	t.Run("should keep groups and files", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		dir := writeImages(t, map[string]string{
+			"a.png": "1",
+			"b.png": "1",
+			"c.png": "1",
+			"d.png": "2",
+			"e.png": "2",
+		})
+		s, err := New(dir, WithDisposalFolder("trash"))
+		require.NoError(t, err)
+		plan, err := s.Scan()
+		require.NoError(t, err)
+		require.Len(t, plan.Groups, 2)
+		a.EqualValues(3, plan.DisposableBytes())
+		a.Zero(plan.ReclaimableBytes(), "moving dupes should never free space")
+
+		one, two := plan.Groups[0], plan.Groups[1]
+		if one.Hash != hashOf("1") {
+			one, two = two, one
+		}
+		require.NoError(t, plan.KeepFile(one.Hash, one.Dupes[0].Path))
+		require.NoError(t, plan.KeepGroup(two.Hash))
+		a.EqualValues(1, plan.DisposableBytes(), "should skip kept dupes")
+
+		result, err := plan.Apply()
+		require.NoError(t, err)
+		require.Len(t, result.Disposed, 1)
+		a.Zero(result.Status.ReclaimedBytes)
+		a.EqualValues(1, result.Status.MovedBytes)
+		a.Equal(one.Dupes[1].Path, result.Disposed[0].Path)
+		a.FileExists(filepath.Join(dir, "trash", filepath.Base(one.Dupes[1].Path)))
+		a.FileExists(one.Dupes[0].Path)
+		a.FileExists(two.Dupes[0].Path)
+	})
+
+	t.Run("should find images cached with another algorithm", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		dir := writeImages(t, map[string]string{
+			"0x@" + hashOf("1") + ".png": "1",
+			"b.png":                      "2",
+		})
+
+		s, err := New(dir, WithCachedAlgorithm(SHA256))
+		require.NoError(t, err)
+		stale, err := s.StaleImages()
+		require.NoError(t, err)
+		a.Empty(stale)
+
+		s, err = New(dir, WithCachedAlgorithm(MD5))
+		require.NoError(t, err)
+		stale, err = s.StaleImages()
+		require.NoError(t, err)
+		a.Equal([]string{"0x@" + hashOf("1") + ".png"}, stale)
+	})
+
+	t.Run("should scan an in-memory filesystem", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		fsys := NewMemFS()
+		dir := filepath.Join(t.TempDir(), "images")
+		require.NoError(t, fsys.MkdirAll(dir, 0o755))
+		for name, content := range map[string]string{"a.png": "1", "b.png": "1"} {
+			require.NoError(t, fsys.WriteFile(filepath.Join(dir, name), []byte(content), 0o600))
+		}
+
+		s, err := New(dir, WithFS(fsys), WithDisposalFolder("trash"))
+		require.NoError(t, err)
+		plan, err := s.Scan()
+		require.NoError(t, err)
+		require.Len(t, plan.Groups, 1)
+		result, err := plan.Apply()
+		require.NoError(t, err)
+		require.Len(t, result.Disposed, 1)
+
+		_, err = fsys.Stat(filepath.Join(dir, "0x@"+hashOf("1")+".png"))
+		a.NoError(err)
+		_, err = fsys.Stat(filepath.Join(dir, "trash", filepath.Base(result.Disposed[0].Path)))
+		a.NoError(err)
+		a.NoDirExists(dir, "should never touch the OS filesystem")
+
+		_, err = s.NewWatcher(0, nil)
+		a.ErrorIs(err, ErrWatchFS)
+		a.False(s.HasInterruptedReview())
+		a.ErrorIs(s.ResumeReview(), ErrReviewFS)
+	})
+}
+
+func TestHistory(t *testing.T) {
+	t.Run("should record applied plans with WithHistory", func(t *testing.T) {
+		a := assert.New(t)
+		t.Setenv("XDG_STATE_HOME", t.TempDir())
+		dir := writeImages(t, map[string]string{"a.png": "1", "b.png": "1"})
+
+		s, err := New(dir, WithAlgorithm(MD5), WithHistory())
+		require.NoError(t, err)
+		plan, err := s.Scan()
+		require.NoError(t, err)
+		_, err = plan.Apply()
+		require.NoError(t, err)
+
+		records, err := ReadHistory()
+		require.NoError(t, err)
+		require.Len(t, records, 1)
+		a.Equal(s.Dir(), records[0].Dir)
+		a.Equal(int32(1), records[0].Dupes)
+		a.Equal(MD5, CachedAlgorithm(s.Dir()))
+	})
+
+	t.Run("should not record plans without WithHistory", func(t *testing.T) {
+		t.Setenv("XDG_STATE_HOME", t.TempDir())
+		dir := writeImages(t, map[string]string{"a.png": "1", "b.png": "1"})
+
+		s, err := New(dir)
+		require.NoError(t, err)
+		plan, err := s.Scan()
+		require.NoError(t, err)
+		_, err = plan.Apply()
+		require.NoError(t, err)
+
+		records, err := ReadHistory()
+		require.NoError(t, err)
+		assert.Empty(t, records)
+	})
+}
@@ -0,0 +1,75 @@
+package hashimg
+
+import (
+	"time"
+
+	"github.com/jaeiya/hashimg/lib"
+)
+
+type (
+	// RunRecord is a run that updated the images of a directory
+	RunRecord    = lib.RunRecord
+	HistoryStats = lib.HistoryStats
+)
+
+// ReadHistory reads every recorded run, oldest first.
+func ReadHistory() ([]RunRecord, error) {
+	path, err := lib.HistoryPath()
+	if err != nil {
+		return nil, err
+	}
+	return lib.ReadHistory(path)
+}
+
+// SummarizeHistory totals the records, along with the trend of every directory.
+func SummarizeHistory(records []RunRecord) HistoryStats {
+	return lib.SummarizeHistory(records)
+}
+
+/*
+CachedAlgorithm is the algorithm the cached images in dir were named
+with, according to the history. It's empty when the history can't tell,
+so it can always be passed to WithCachedAlgorithm.
+*/
+func CachedAlgorithm(dir string) HashAlgorithm {
+	records, err := ReadHistory()
+	if err != nil {
+		return ""
+	}
+	return lib.CachedAlgorithm(records, dir)
+}
+
+// onUpdate records runs of watchers and servers, when WithHistory is set
+func (s *Scanner) onUpdate() func(ip *Processor, startedAt time.Time) {
+	if !s.opts.history {
+		return nil
+	}
+	return s.recordRun
+}
+
+// recordRun warns instead of failing, since the history is only for stats
+func (s *Scanner) recordRun(ip *Processor, startedAt time.Time) {
+	if err := s.RecordRun(ip, startedAt); err != nil && s.opts.logger != nil {
+		s.opts.logger.Warn("cannot record the run", "dir", s.dir, "err", err)
+	}
+}
+
+/*
+RecordRun adds the run of ip, which started at startedAt, to the
+history. Only runs that updated the images are recorded, so nothing is
+added when updating failed or never happened.
+*/
+func (s *Scanner) RecordRun(ip *Processor, startedAt time.Time) error {
+	status := ip.Status
+	if !status.UpdatingComplete || status.HashErr != nil || status.UpdateErr != nil {
+		return nil
+	}
+
+	rec := lib.NewRunRecord(ip, s.cfg, startedAt)
+	rec.Options.Migrate = s.opts.migrate
+	path, err := lib.HistoryPath()
+	if err != nil {
+		return err
+	}
+	return lib.AppendHistory(path, rec)
+}
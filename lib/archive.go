@@ -0,0 +1,386 @@
+package lib
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+Images inside archives are mapped as "<archive>/<member>". Loose images
+can't have a slash in their name, so the two are never confused.
+*/
+const archiveSeparator = "/"
+
+// Longest first, so .tar.gz isn't mistaken for .gz
+var archiveExtensions = []string{".tar.gz", ".tgz", ".tar", ".zip"}
+
+/*
+ArchivedImage is an image inside an archive. Archives are never changed,
+so archived images are only reported, never disposed of or renamed.
+*/
+type ArchivedImage struct {
+	// Path of the archive
+	Archive string
+	// Name of the image inside the archive
+	Name string
+	Hash string
+	// The loose image, or archived image, that has the same hash.
+	// Novel archived images don't have one.
+	DupeOf string
+}
+
+// Path is the archive path joined with the name inside it.
+func (ai ArchivedImage) Path() string {
+	return ai.Archive + archiveSeparator + ai.Name
+}
+
+// IsArchive reports whether the file is an archive that can be scanned.
+func IsArchive(fileName string) bool {
+	return archiveExt(fileName) != ""
+}
+
+func archiveExt(fileName string) string {
+	lower := strings.ToLower(fileName)
+	for _, ext := range archiveExtensions {
+		if strings.HasSuffix(lower, ext) {
+			return ext
+		}
+	}
+	return ""
+}
+
+// splitArchived splits an image map key into the archive and the name inside it
+func splitArchived(fileName string) (archive, name string, ok bool) {
+	return strings.Cut(fileName, archiveSeparator)
+}
+
+/*
+walkArchive calls fn with every regular file in the archive, in the order
+they're stored. Archives are read in a single pass, which is the only way
+to read compressed tarballs.
+*/
+func walkArchive(
+	fsys FS,
+	archivePath string,
+	fn func(info fs.FileInfo, name string, r io.Reader) error,
+) error {
+	file, err := fsys.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	switch archiveExt(archivePath) {
+	case ".zip":
+		return walkZip(file, fn)
+	case ".tar.gz", ".tgz":
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("%w: %s: %w", ErrInvalidArchive, archivePath, err)
+		}
+		defer gz.Close()
+		return walkTar(gz, fn)
+	case ".tar":
+		return walkTar(file, fn)
+	}
+	return fmt.Errorf("%w: %s", ErrInvalidArchive, archivePath)
+}
+
+func walkZip(file fs.File, fn func(fs.FileInfo, string, io.Reader) error) error {
+	readerAt, ok := file.(io.ReaderAt)
+	if !ok {
+		return fmt.Errorf("%w: zip files must be seekable", ErrInvalidArchive)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	zr, err := zip.NewReader(readerAt, info.Size())
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+	}
+
+	for _, zf := range zr.File {
+		if !zf.Mode().IsRegular() {
+			continue
+		}
+		r, err := zf.Open()
+		if err != nil {
+			return err
+		}
+		err = fn(zf.FileInfo(), zf.Name, r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func walkTar(r io.Reader, fn func(fs.FileInfo, string, io.Reader) error) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := fn(header.FileInfo(), header.Name, tr); err != nil {
+			return err
+		}
+	}
+}
+
+/*
+mapArchive adds every image inside the archive to the image map. Names
+are matched against the ignore patterns and the filter like loose files.
+Nothing is added when the archive can't be read to the end.
+*/
+func mapArchive(
+	fsys FS,
+	cfg MapperConfig,
+	archiveName string,
+	isMedia func(fileName string) bool,
+	ignore *IgnoreMatcher,
+	iMap ImageMap,
+) error {
+	images := ImageMap{}
+	err := walkArchive(
+		fsys,
+		filepath.Join(cfg.Dir, archiveName),
+		func(info fs.FileInfo, name string, _ io.Reader) error {
+			// Archives are matched like directories by the ignore patterns
+			fileName := archiveName + archiveSeparator + name
+			if !isMedia(name) || ignore.Match(fileName, false) {
+				return nil
+			}
+			if !cfg.Filter.IsZero() && !cfg.Filter.Allows(info) {
+				return nil
+			}
+			images[fileName] = NotCached
+			return nil
+		},
+	)
+	if err != nil {
+		return err
+	}
+	for fileName, cs := range images {
+		iMap[fileName] = cs
+	}
+	return nil
+}
+
+/*
+hashArchives hashes the archived images in the image map, while streaming
+through each archive once. Archives are read by as many goroutines as
+there are readers.
+*/
+func (ip *ImageProcessor) hashArchives(readers int) ([]ArchivedImage, error) {
+	names := map[string]map[string]bool{}
+	for fileName := range ip.imageMap {
+		archive, name, ok := splitArchived(fileName)
+		if !ok {
+			continue
+		}
+		if names[archive] == nil {
+			names[archive] = map[string]bool{}
+		}
+		names[archive][name] = true
+	}
+
+	var (
+		wg       sync.WaitGroup
+		archived []ArchivedImage
+		firstErr error
+		aMux     sync.Mutex
+	)
+	sem := make(chan struct{}, max(readers, 1))
+	for archive, wanted := range names {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(archive string, wanted map[string]bool) {
+			defer func() { <-sem; wg.Done() }()
+			images, err := ip.hashArchive(filepath.Join(ip.WorkingDir, archive), wanted)
+			aMux.Lock()
+			defer aMux.Unlock()
+			archived = append(archived, images...)
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}(archive, wanted)
+	}
+	wg.Wait()
+
+	sort.Slice(archived, func(i, j int) bool {
+		return archived[i].Path() < archived[j].Path()
+	})
+	return archived, firstErr
+}
+
+/*
+hashArchive hashes the wanted images in the archive. Every wanted name
+counts towards the hash progress exactly once, even when the archive has
+the name twice, or no longer has it, so the progress always completes.
+*/
+func (ip *ImageProcessor) hashArchive(archivePath string, wanted map[string]bool) ([]ArchivedImage, error) {
+	archived := []ArchivedImage{}
+	hashed := map[string]bool{}
+	defer func() {
+		for range len(wanted) - len(hashed) {
+			ip.Status.IncHashProgress()
+		}
+	}()
+
+	err := walkArchive(ip.fs, archivePath, func(_ fs.FileInfo, name string, r io.Reader) error {
+		if !wanted[name] || hashed[name] {
+			return nil
+		}
+		// The algorithm was validated by the hasher
+		sum, err := ip.algorithm.newHash()
+		if err != nil {
+			return err
+		}
+		n, err := io.Copy(sum, r)
+		if err != nil {
+			return err
+		}
+		ip.Status.AddHashedBytes(n)
+		ip.Status.IncHashProgress()
+		hashed[name] = true
+		ai := ArchivedImage{
+			Archive: archivePath,
+			Name:    name,
+			Hash:    fmt.Sprintf("%x", sum.Sum(nil))[0:ip.hashLength],
+		}
+		ip.log.Info("hashed", "path", ai.Path(), "hash", ai.Hash)
+		archived = append(archived, ai)
+		return nil
+	})
+	return archived, err
+}
+
+/*
+matchArchived finds what every archived image duplicates. Loose images
+come first, so an archived image is only novel when no loose image, or
+archived image before it, has its hash.
+*/
+func (ip *ImageProcessor) matchArchived(archived []ArchivedImage, cached map[string][]HashInfo) {
+	pi := ip.processedImages
+	known := map[string]string{}
+	for hash, hi := range pi.NewImagesByHash {
+		known[hash] = hi.path
+	}
+	for hash, olds := range cached {
+		if _, ok := known[hash]; !ok {
+			known[hash] = olds[0].path
+		}
+	}
+	for hash, dupes := range pi.DupeImagesByHash {
+		for _, dupe := range dupes {
+			if dupe.isNovel {
+				known[hash] = dupe.path
+			}
+		}
+	}
+
+	for i, ai := range archived {
+		if path, ok := known[ai.Hash]; ok {
+			archived[i].DupeOf = path
+			atomic.AddInt32(&ip.Status.ArchiveDupeCount, 1)
+			continue
+		}
+		known[ai.Hash] = ai.Path()
+	}
+	ip.Archived = archived
+}
+
+/*
+ExtractNovel extracts every archived image that isn't a dupe into the
+working directory, named after its hash like the other images. Names
+that are already taken are recorded as conflicts, so nothing is ever
+replaced. It returns the paths of the extracted images.
+*/
+func (ip *ImageProcessor) ExtractNovel() ([]string, error) {
+	if ip.processedImages == nil {
+		return nil, ErrNotProcessed
+	}
+
+	novel := map[string]map[string]string{}
+	for _, ai := range ip.Archived {
+		if ai.DupeOf != "" {
+			continue
+		}
+		if novel[ai.Archive] == nil {
+			novel[ai.Archive] = map[string]string{}
+		}
+		novel[ai.Archive][ai.Name] = ai.Hash
+	}
+
+	archives := make([]string, 0, len(novel))
+	for archive := range novel {
+		archives = append(archives, archive)
+	}
+	sort.Strings(archives)
+
+	extracted := []string{}
+	for _, archive := range archives {
+		hashes := novel[archive]
+		err := walkArchive(ip.fs, archive, func(info fs.FileInfo, name string, r io.Reader) error {
+			hash, ok := hashes[name]
+			if !ok {
+				return nil
+			}
+			target := filepath.Join(ip.WorkingDir, ip.HashName(hash, name))
+			data, err := io.ReadAll(r)
+			if err != nil {
+				return err
+			}
+
+			// Writing replaces files, so the image is only moved into
+			// place once it's written, without replacing the target
+			log := ip.log.With("path", archive+archiveSeparator+name, "target", target, "hash", hash)
+			tmpName := target + ".hashimg-extract"
+			if err := ip.fs.WriteFile(tmpName, data, 0o644); err != nil {
+				log.Error("extract failed", "err", err)
+				return err
+			}
+			err = ip.fs.Rename(tmpName, target)
+			if errors.Is(err, fs.ErrExist) {
+				log.Warn("rename conflict")
+				ip.Conflicts = append(ip.Conflicts, RenameConflict{
+					Path:   archive + archiveSeparator + name,
+					Target: target,
+				})
+				atomic.AddInt32(&ip.Status.ConflictCount, 1)
+				return ip.fs.Remove(tmpName)
+			}
+			if err != nil {
+				ip.fs.Remove(tmpName)
+				log.Error("extract failed", "err", err)
+				return err
+			}
+			log.Info("extracted")
+			extracted = append(extracted, target)
+			return nil
+		})
+		if err != nil {
+			return extracted, err
+		}
+	}
+	return extracted, nil
+}
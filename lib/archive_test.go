@@ -0,0 +1,255 @@
+package lib
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func zipArchive(t *testing.T, files map[string]string) []byte {
+	buf := bytes.Buffer{}
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func tarGzArchive(t *testing.T, files map[string]string) []byte {
+	buf := bytes.Buffer{}
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for name, content := range files {
+		err := tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0o644,
+			Size:     int64(len(content)),
+			Typeflag: tar.TypeReg,
+		})
+		require.NoError(t, err)
+		_, err = tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+	return buf.Bytes()
+}
+
+// zipWithDuplicate has the same image name twice
+func zipWithDuplicate(t *testing.T) []byte {
+	buf := bytes.Buffer{}
+	zw := zip.NewWriter(&buf)
+	for _, content := range []string{"1", "5"} {
+		w, err := zw.Create("one.png")
+		require.NoError(t, err)
+		_, err = w.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestArchives(t *testing.T) {
+	const hashPrefix = "0x@"
+	dir := filepath.Join(string(filepath.Separator), "images")
+
+	newArchiveFS := func(t *testing.T) *MemFS {
+		fsys := NewMemFS()
+		require.NoError(t, fsys.MkdirAll(dir, 0o755))
+		require.NoError(t, writeFiles(fsys, dir, []string{"loose.png", "other.png"}, []string{"1", "1"}))
+		require.NoError(t, fsys.WriteFile(filepath.Join(dir, "a.zip"), zipArchive(t, map[string]string{
+			"sub/one.png": "1",
+			"two.jpg":     "2",
+			"notes.txt":   "3",
+		}), 0o644))
+		require.NoError(t, fsys.WriteFile(filepath.Join(dir, "b.tar.gz"), tarGzArchive(t, map[string]string{
+			"two.jpg":   "2",
+			"three.gif": "3",
+		}), 0o644))
+		return fsys
+	}
+
+	process := func(t *testing.T, fsys FS, ignore ...string) *ImageProcessor {
+		iMap, err := MapImagesWithConfig(MapperConfig{
+			Dir:             dir,
+			Prefix:          hashPrefix,
+			Ignore:          ignore,
+			IncludeArchives: true,
+			FS:              fsys,
+		})
+		require.NoError(t, err)
+		ip := NewImageProcessor(ImageProcessorConfig{
+			WorkingDir: dir,
+			Prefix:     hashPrefix,
+			ImageMap:   iMap,
+			HashLength: hashLength,
+			FS:         fsys,
+		})
+		require.NoError(t, ip.ProcessImages(false))
+		return ip
+	}
+
+	t.Run("should map images inside archives", func(t *testing.T) {
+		t.Parallel()
+		iMap, err := MapImagesWithConfig(MapperConfig{
+			Dir:             dir,
+			Prefix:          hashPrefix,
+			IncludeArchives: true,
+			FS:              newArchiveFS(t),
+		})
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{
+			"loose.png",
+			"other.png",
+			"a.zip/sub/one.png",
+			"a.zip/two.jpg",
+			"b.tar.gz/two.jpg",
+			"b.tar.gz/three.gif",
+		}, mapKeys(iMap))
+	})
+
+	t.Run("should report dupes of loose and archived images", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		ip := process(t, newArchiveFS(t))
+
+		a.EqualValues(2, ip.Status.TotalImageCount)
+		a.EqualValues(4, ip.Status.ArchivedImageCount)
+		a.EqualValues(2, ip.Status.ArchiveDupeCount)
+		a.True(ip.HasDupes, "should still dedupe loose images")
+
+		require.Len(t, ip.Archived, 4)
+		dupeOf := map[string]string{}
+		for _, ai := range ip.Archived {
+			rel, err := filepath.Rel(dir, ai.Path())
+			require.NoError(t, err)
+			dupeOf[filepath.ToSlash(rel)] = ai.DupeOf
+		}
+		// Either loose image can be the keeper, since they're identical
+		keeper, ok := mustDupeGroups(t, ip)[0].Keeper()
+		require.True(t, ok)
+		a.Equal(keeper.Path, dupeOf["a.zip/sub/one.png"])
+		a.Empty(dupeOf["a.zip/two.jpg"])
+		a.Equal(filepath.Join(dir, "a.zip")+"/two.jpg", dupeOf["b.tar.gz/two.jpg"])
+		a.Empty(dupeOf["b.tar.gz/three.gif"])
+	})
+
+	t.Run("should never change archives", func(t *testing.T) {
+		t.Parallel()
+		fsys := newArchiveFS(t)
+		ip := process(t, fsys)
+		require.NoError(t, ip.UpdateImages())
+
+		fileNames, err := readDir(fsys, dir)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"a.zip", "b.tar.gz", hashPrefix + calcSha256("1") + ".png"}, fileNames)
+	})
+
+	t.Run("should skip ignored archives", func(t *testing.T) {
+		t.Parallel()
+		ip := process(t, newArchiveFS(t), "b.tar.gz")
+		assert.EqualValues(t, 2, ip.Status.ArchivedImageCount)
+	})
+
+	t.Run("should extract novel images only", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		fsys := newArchiveFS(t)
+		taken := hashPrefix + calcSha256("3") + ".gif"
+		require.NoError(t, fsys.WriteFile(filepath.Join(dir, taken), []byte("taken"), 0o644))
+		// The taken name is cached, so it has to be ignored to stay novel
+		ip := process(t, fsys, taken)
+
+		extracted, err := ip.ExtractNovel()
+		require.NoError(t, err)
+		target := filepath.Join(dir, hashPrefix+calcSha256("2")+".jpg")
+		a.Equal([]string{target}, extracted)
+		data, err := fs.ReadFile(fsys, target)
+		require.NoError(t, err)
+		a.Equal("2", string(data))
+
+		require.Len(t, ip.Conflicts, 1)
+		a.Equal(filepath.Join(dir, taken), ip.Conflicts[0].Target)
+		data, err = fs.ReadFile(fsys, filepath.Join(dir, taken))
+		require.NoError(t, err)
+		a.Equal("taken", string(data), "should never replace a file")
+
+		fileNames, err := readDir(fsys, dir)
+		require.NoError(t, err)
+		for _, name := range fileNames {
+			a.NotContains(name, ".hashimg-extract", "should remove the extracted file")
+		}
+	})
+
+	t.Run("should skip broken archives", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		fsys := newArchiveFS(t)
+		require.NoError(t, fsys.WriteFile(filepath.Join(dir, "broken.zip"), []byte("nope"), 0o644))
+		// Cut short in the header of the second image
+		gz, err := gzip.NewReader(bytes.NewReader(tarGzArchive(t, map[string]string{"four.png": "4", "five.png": "5"})))
+		require.NoError(t, err)
+		tarball, err := io.ReadAll(gz)
+		require.NoError(t, err)
+		require.NoError(t, fsys.WriteFile(filepath.Join(dir, "cut.tar"), tarball[:1100], 0o644))
+
+		skipped := map[string]error{}
+		iMap, err := MapImagesWithConfig(MapperConfig{
+			Dir:             dir,
+			Prefix:          hashPrefix,
+			IncludeArchives: true,
+			FS:              fsys,
+			OnSkip:          func(path string, err error) { skipped[path] = err },
+		})
+		require.NoError(t, err)
+		a.Len(iMap, 6, "should map the other archives")
+		a.NotContains(iMap, "cut.tar/four.png", "should not map part of an archive")
+		a.NotContains(iMap, "cut.tar/five.png", "should not map part of an archive")
+		require.Len(t, skipped, 2)
+		a.ErrorIs(skipped[filepath.Join(dir, "broken.zip")], ErrInvalidArchive)
+		a.ErrorIs(skipped[filepath.Join(dir, "cut.tar")], ErrInvalidArchive)
+	})
+
+	t.Run("should complete the progress of every archive", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		fsys := newArchiveFS(t)
+		// Zips can have the same name twice
+		require.NoError(t, fsys.WriteFile(filepath.Join(dir, "a.zip"), zipWithDuplicate(t), 0o644))
+		iMap, err := MapImagesWithConfig(MapperConfig{
+			Dir:             dir,
+			Prefix:          hashPrefix,
+			IncludeArchives: true,
+			FS:              fsys,
+		})
+		require.NoError(t, err)
+
+		// Like an archive that changed after it was mapped
+		require.NoError(t, fsys.WriteFile(filepath.Join(dir, "b.tar.gz"), tarGzArchive(t, map[string]string{
+			"two.jpg": "2",
+		}), 0o644))
+
+		ip := NewImageProcessor(ImageProcessorConfig{
+			WorkingDir: dir,
+			Prefix:     hashPrefix,
+			ImageMap:   iMap,
+			HashLength: hashLength,
+			FS:         fsys,
+		})
+		require.NoError(t, ip.ProcessImages(false))
+		a.Equal(ip.Status.MaxHashProgress, ip.Status.HashProgress)
+		a.Len(ip.Archived, 2)
+	})
+}
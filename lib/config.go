@@ -0,0 +1,281 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DirConfigName is the name of the config file inside a target directory
+const DirConfigName = ".hashimg"
+
+type KeeperPolicy string
+
+const (
+	// Keeps the cached image, otherwise the first image found
+	KeepFirst  KeeperPolicy = "first"
+	KeepOldest KeeperPolicy = "oldest"
+	KeepNewest KeeperPolicy = "newest"
+)
+
+type DisposalMode string
+
+const (
+	DisposeDelete DisposalMode = "delete"
+	// Moves dupes into the disposal folder instead of deleting them
+	DisposeMove DisposalMode = "move"
+)
+
+/*
+Config holds every setting that can be changed with a config file.
+
+🟡 Changing the prefix, length or algorithm makes previously renamed
+images look new, so they'll be hashed and renamed again.
+*/
+type Config struct {
+	Prefix    string        `yaml:"prefix"`
+	Length    int           `yaml:"length"`
+	Algorithm HashAlgorithm `yaml:"algorithm"`
+	// Extensions of files that are treated as images, which replace
+	// the built-in ones
+	Extensions []string `yaml:"extensions"`
+	// Extensions added to, or removed from, the ones above
+	EnableExtensions  []string `yaml:"enable_extensions"`
+	DisableExtensions []string `yaml:"disable_extensions"`
+	// Dedupes videos along with images
+	Videos bool `yaml:"videos"`
+	// Finds dupes of the images inside zip and tar archives, which
+	// are never changed
+	Archives bool `yaml:"archives"`
+	// Gitignore-style patterns of files to skip, on top of the
+	// .hashimgignore file
+	Ignore []string `yaml:"ignore"`
+	// Files outside these sizes and modification times are skipped
+	MinSize        ByteSize     `yaml:"min_size"`
+	MaxSize        ByteSize     `yaml:"max_size"`
+	ModifiedAfter  FilterTime   `yaml:"modified_after"`
+	ModifiedBefore FilterTime   `yaml:"modified_before"`
+	KeeperPolicy   KeeperPolicy `yaml:"keeper_policy"`
+	Disposal       DisposalMode `yaml:"disposal"`
+	// Where dupes are moved to when disposal is "move"
+	DisposalFolder string `yaml:"disposal_folder"`
+	ReviewFolder   string `yaml:"review_folder"`
+}
+
+func DefaultConfig() Config {
+	extensions := make([]string, 0, len(imageExtensions))
+	for ext := range imageExtensions {
+		extensions = append(extensions, ext)
+	}
+	return Config{
+		Prefix:         "0x@",
+		Length:         32,
+		Algorithm:      SHA256,
+		Extensions:     extensions,
+		KeeperPolicy:   KeepFirst,
+		Disposal:       DisposeDelete,
+		DisposalFolder: "__disposed",
+		ReviewFolder:   "__dupes",
+	}
+}
+
+/*
+LoadConfig loads the user config from $XDG_CONFIG_HOME/hashimg/config,
+then the .hashimg file inside dir. Settings in later files override
+earlier ones, and missing files are skipped.
+*/
+func LoadConfig(dir string) (Config, error) {
+	paths := []string{}
+	if userPath, err := UserConfigPath(); err == nil {
+		paths = append(paths, userPath)
+	}
+	paths = append(paths, filepath.Join(dir, DirConfigName))
+	return loadConfig(paths...)
+}
+
+// UserConfigPath is where the user config is, whether it exists or not.
+func UserConfigPath() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "hashimg", "config"), nil
+}
+
+func loadConfig(paths ...string) (Config, error) {
+	cfg := DefaultConfig()
+	for _, path := range paths {
+		if err := cfg.merge(path); err != nil {
+			return Config{}, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// merge overrides the settings that are set in the YAML file at path.
+func (cfg *Config) merge(path string) error {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	dec := yaml.NewDecoder(file)
+	// Typos would otherwise be silently ignored
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+/*
+Validate checks every setting and normalizes the extensions, so they're
+lowercase, and the algorithm, so it's never empty.
+*/
+func (cfg *Config) Validate() error {
+	if len(cfg.Prefix) < 3 {
+		return ErrHashPrefixTooShort
+	}
+
+	cfg.Algorithm = cfg.Algorithm.orDefault()
+
+	if err := cfg.Algorithm.validateLength(cfg.Length); err != nil {
+		return err
+	}
+
+	for _, extensions := range [][]string{
+		cfg.Extensions,
+		cfg.EnableExtensions,
+		cfg.DisableExtensions,
+	} {
+		for i, ext := range extensions {
+			if !strings.HasPrefix(ext, ".") {
+				return fmt.Errorf("%w: %q", ErrInvalidExtension, ext)
+			}
+			extensions[i] = strings.ToLower(ext)
+		}
+	}
+
+	if _, err := NewIgnoreMatcher(cfg.Ignore); err != nil {
+		return err
+	}
+
+	if err := cfg.Filter().Validate(); err != nil {
+		return err
+	}
+
+	switch cfg.KeeperPolicy {
+	case KeepFirst, KeepOldest, KeepNewest:
+	default:
+		return fmt.Errorf("%w: %q", ErrUnknownKeeperPolicy, cfg.KeeperPolicy)
+	}
+
+	switch cfg.Disposal {
+	case DisposeDelete:
+	case DisposeMove:
+		if cfg.DisposalFolder == "" {
+			return ErrNoDisposalFolder
+		}
+	default:
+		return fmt.Errorf("%w: %q", ErrUnknownDisposalMode, cfg.Disposal)
+	}
+
+	if err := validateFolderName(cfg.ReviewFolder); err != nil {
+		return err
+	}
+	if cfg.DisposalFolder != "" {
+		if err := validateFolderName(cfg.DisposalFolder); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+validateFolderName makes sure the folder is a direct child of the working
+directory. Whole folders are moved and removed when a review finishes, so
+a folder anywhere else could take unrelated files with it.
+*/
+func validateFolderName(name string) error {
+	switch {
+	case name == "", name == ".", name == "..",
+		filepath.IsAbs(name),
+		filepath.VolumeName(name) != "",
+		strings.ContainsAny(name, `/\`):
+		return fmt.Errorf("%w: %q", ErrInvalidFolder, name)
+	}
+	return nil
+}
+
+/*
+ExtensionMap is the extensions in the form used by MapperConfig.
+Disabled extensions win over enabled ones.
+*/
+func (cfg Config) ExtensionMap() ImageExtMap {
+	extMap := ImageExtMap{}
+	for _, ext := range cfg.Extensions {
+		extMap[ext] = ExtEnabled
+	}
+	for _, ext := range cfg.EnableExtensions {
+		extMap[ext] = ExtEnabled
+	}
+	for _, ext := range cfg.DisableExtensions {
+		extMap[ext] = ExtDisabled
+	}
+	return extMap
+}
+
+/*
+ProcessorConfig is the ImageProcessorConfig for images in dir. The
+ImageMap still needs to be set.
+*/
+func (cfg Config) ProcessorConfig(dir string) ImageProcessorConfig {
+	ipc := ImageProcessorConfig{
+		Prefix:           cfg.Prefix,
+		HashLength:       cfg.Length,
+		Algorithm:        cfg.Algorithm,
+		WorkingDir:       dir,
+		DupeReviewFolder: cfg.ReviewFolder,
+		KeeperPolicy:     cfg.KeeperPolicy,
+	}
+	if cfg.Disposal == DisposeMove {
+		ipc.DisposalFolder = cfg.DisposalFolder
+	}
+	return ipc
+}
+
+func (cfg Config) Filter() FileFilter {
+	return FileFilter{
+		MinSize:        cfg.MinSize,
+		MaxSize:        cfg.MaxSize,
+		ModifiedAfter:  cfg.ModifiedAfter,
+		ModifiedBefore: cfg.ModifiedBefore,
+	}
+}
+
+// MapperConfig is the MapperConfig for images in dir.
+func (cfg Config) MapperConfig(dir string) MapperConfig {
+	return MapperConfig{
+		Dir:             dir,
+		Prefix:          cfg.Prefix,
+		Extensions:      cfg.ExtensionMap(),
+		Ignore:          cfg.Ignore,
+		Filter:          cfg.Filter(),
+		IncludeVideos:   cfg.Videos,
+		IncludeArchives: cfg.Archives,
+	}
+}
@@ -0,0 +1,233 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig(t *testing.T) {
+	writeConfig := func(t *testing.T, content string) string {
+		path := filepath.Join(t.TempDir(), "config")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+		return path
+	}
+
+	t.Run("should use defaults without config files", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		cfg, err := loadConfig(filepath.Join(t.TempDir(), "missing"))
+		require.NoError(t, err)
+		a.Equal(DefaultConfig().Prefix, cfg.Prefix)
+		a.Equal(DefaultConfig().Length, cfg.Length)
+		a.ElementsMatch(DefaultConfig().Extensions, cfg.Extensions)
+	})
+
+	t.Run("should use defaults with an empty config file", func(t *testing.T) {
+		t.Parallel()
+		cfg, err := loadConfig(writeConfig(t, ""))
+		require.NoError(t, err)
+		assert.Equal(t, DefaultConfig().Prefix, cfg.Prefix)
+	})
+
+	t.Run("should let directory config override user config", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		user := writeConfig(t, "prefix: ab_\nlength: 20\nextensions: [.PNG]\n")
+		dir := writeConfig(
+			t,
+			"length: 40\nkeeper_policy: oldest\nignore: ['*.tmp.png']\n"+
+				"min_size: 10KiB\nmodified_after: 2024-01-31\n",
+		)
+
+		cfg, err := loadConfig(user, dir)
+		require.NoError(t, err)
+		a.Equal("ab_", cfg.Prefix)
+		a.Equal(40, cfg.Length)
+		a.Equal([]string{".png"}, cfg.Extensions, "extensions are replaced and lowercased")
+		a.Equal(KeepOldest, cfg.KeeperPolicy)
+		a.Equal([]string{"*.tmp.png"}, cfg.Ignore)
+		a.Equal(ByteSize(10*1024), cfg.MinSize)
+		a.Equal("2024-01-31", cfg.ModifiedAfter.Format(time.DateOnly))
+	})
+
+	t.Run("should enable and disable extensions", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		cfg, err := loadConfig(writeConfig(
+			t,
+			"enable_extensions: [.XMP, .gif]\ndisable_extensions: [.gif, .nef]\n",
+		))
+		require.NoError(t, err)
+		extMap := cfg.ExtensionMap()
+		a.Equal(ExtEnabled, extMap[".xmp"])
+		a.Equal(ExtEnabled, extMap[".png"])
+		a.Equal(ExtDisabled, extMap[".gif"], "disabling wins")
+		a.Equal(ExtDisabled, extMap[".nef"])
+	})
+
+	t.Run("should error on unknown settings", func(t *testing.T) {
+		t.Parallel()
+		_, err := loadConfig(writeConfig(t, "prefx: ab_\n"))
+		assert.Error(t, err)
+	})
+
+	md := []struct {
+		should string
+		config string
+		err    error
+	}{
+		{"reject a short prefix", "prefix: ab\n", ErrHashPrefixTooShort},
+		{"reject a short length", "length: 9\n", ErrHashLengthTooShort},
+		{"reject a length longer than the algorithm", "algorithm: md5\nlength: 33\n", ErrHashLengthTooLong},
+		{"reject unknown algorithms", "algorithm: crc32\n", ErrUnknownAlgorithm},
+		{"reject extensions without a dot", "extensions: [png]\n", ErrInvalidExtension},
+		{"reject bad ignore patterns", "ignore: ['[']\n", filepath.ErrBadPattern},
+		{"reject unknown keeper policies", "keeper_policy: biggest\n", ErrUnknownKeeperPolicy},
+		{"reject unknown disposal modes", "disposal: shred\n", ErrUnknownDisposalMode},
+		{"reject unknown size units", "min_size: 12XB\n", ErrInvalidFilter},
+		{"reject inverted size ranges", "min_size: 2MB\nmax_size: 1MB\n", ErrInvalidFilter},
+		{"reject inverted time ranges", "modified_after: 2024-02-01\nmodified_before: 2024-01-01\n", ErrInvalidFilter},
+		{"require a folder to move dupes", "disposal: move\ndisposal_folder: ''\n", ErrNoDisposalFolder},
+		{"reject an empty review folder", "review_folder: ''\n", ErrInvalidFolder},
+		{"reject the working dir as review folder", "review_folder: .\n", ErrInvalidFolder},
+		{"reject the parent dir as review folder", "review_folder: ..\n", ErrInvalidFolder},
+		{"reject an absolute review folder", "review_folder: /tmp/dupes\n", ErrInvalidFolder},
+		{"reject a nested review folder", "review_folder: a/dupes\n", ErrInvalidFolder},
+		{"reject a review folder outside the dir", "review_folder: ../dupes\n", ErrInvalidFolder},
+		{"reject backslashes in the review folder", "review_folder: 'a\\dupes'\n", ErrInvalidFolder},
+		{"reject the parent dir as disposal folder", "disposal: move\ndisposal_folder: ..\n", ErrInvalidFolder},
+		{"reject an absolute disposal folder", "disposal_folder: /tmp/disposed\n", ErrInvalidFolder},
+		{"reject a disposal folder outside the dir", "disposal_folder: ../disposed\n", ErrInvalidFolder},
+	}
+
+	for _, d := range md {
+		t.Run("should "+d.should, func(t *testing.T) {
+			t.Parallel()
+			_, err := loadConfig(writeConfig(t, d.config))
+			assert.ErrorIs(t, err, d.err)
+		})
+	}
+}
+
+func TestConfigProcessing(t *testing.T) {
+	const hashPrefix = "0x@"
+
+	t.Run("should map configured extensions and skip ignored files", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		dir := writeTempFiles(
+			t,
+			[]string{"t1.png", "t2.PNG", "t3.jpg", "t4.tmp.png"},
+			[]string{"1", "2", "3", "4"},
+		)
+		iMap, err := MapImagesWithConfig(MapperConfig{
+			Dir:        dir,
+			Prefix:     hashPrefix,
+			Extensions: ImageExtMap{".png": ExtEnabled},
+			Ignore:     []string{"*.tmp.png"},
+		})
+		require.NoError(t, err)
+		a.Equal(ImageMap{"t1.png": NotCached, "t2.PNG": NotCached}, iMap)
+	})
+
+	t.Run("should hash with the configured algorithm", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		dir := writeTempFiles(t, []string{"t1.png"}, []string{"1"})
+		iMap, err := MapImages(dir, hashPrefix)
+		require.NoError(t, err)
+		imgProcessor := NewImageProcessor(ImageProcessorConfig{
+			WorkingDir: dir,
+			Prefix:     hashPrefix,
+			ImageMap:   iMap,
+			HashLength: 40,
+			Algorithm:  SHA1,
+		})
+		require.NoError(t, imgProcessor.ProcessImages(false))
+		require.NoError(t, imgProcessor.UpdateImages())
+
+		fileNames, err := readDir(OSFS{}, dir)
+		require.NoError(t, err)
+		// SHA1 of "1"
+		a.Equal([]string{"0x@356a192b7913b04c54574d18c28d46e6395428ab.png"}, fileNames)
+	})
+
+	for _, policy := range []KeeperPolicy{KeepOldest, KeepNewest} {
+		t.Run("should keep the "+string(policy)+" image", func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+			dir := writeTempFiles(
+				t,
+				[]string{"t1.png", "t2.png", "t3.png"},
+				[]string{"0", "0", "0"},
+			)
+			now := time.Now()
+			for i, name := range []string{"t1.png", "t2.png", "t3.png"} {
+				mtime := now.Add(time.Duration(i-1) * time.Hour)
+				require.NoError(t, os.Chtimes(filepath.Join(dir, name), mtime, mtime))
+			}
+
+			iMap, err := MapImages(dir, hashPrefix)
+			require.NoError(t, err)
+			imgProcessor := NewImageProcessor(ImageProcessorConfig{
+				WorkingDir:   dir,
+				Prefix:       hashPrefix,
+				ImageMap:     iMap,
+				HashLength:   hashLength,
+				KeeperPolicy: policy,
+			})
+			require.NoError(t, imgProcessor.ProcessImages(false))
+
+			expected := "t1.png"
+			if policy == KeepNewest {
+				expected = "t3.png"
+			}
+			groups := mustDupeGroups(t, imgProcessor)
+			require.Len(t, groups, 1)
+			keeper, ok := groups[0].Keeper()
+			require.True(t, ok)
+			a.Equal(expected, filepath.Base(keeper.Path))
+		})
+	}
+
+	t.Run("should move dupes to the disposal folder", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		dir := writeTempFiles(
+			t,
+			[]string{"t1.png", "t2.png", "t3.png"},
+			[]string{"0", "0", "1"},
+		)
+		// A dupe with the same name was disposed of by an earlier run
+		disposalFolder := filepath.Join(dir, "__disposed")
+		require.NoError(t, os.Mkdir(disposalFolder, 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(disposalFolder, "t1.png"), []byte("old"), 0o644))
+		require.NoError(t, os.WriteFile(filepath.Join(disposalFolder, "t2.png"), []byte("old"), 0o644))
+
+		iMap, err := MapImages(dir, hashPrefix)
+		require.NoError(t, err)
+		imgProcessor := NewImageProcessor(ImageProcessorConfig{
+			WorkingDir:     dir,
+			Prefix:         hashPrefix,
+			ImageMap:       iMap,
+			HashLength:     hashLength,
+			DisposalFolder: "__disposed",
+		})
+		require.NoError(t, imgProcessor.ProcessImages(false))
+		require.NoError(t, imgProcessor.UpdateImages())
+
+		disposed, err := readDir(OSFS{}, disposalFolder)
+		require.NoError(t, err)
+		a.Len(disposed, 3, "nothing in the folder is replaced")
+		a.Subset(disposed, []string{"t1.png", "t2.png"})
+
+		fileNames, err := readDir(OSFS{}, dir)
+		require.NoError(t, err)
+		a.Len(fileNames, 3, "2 keepers and the disposal folder")
+	})
+}
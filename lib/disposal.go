@@ -0,0 +1,51 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Makes sure two dupes are never moved to the same name
+var disposalMux = sync.Mutex{}
+
+/*
+disposeFile deletes the file at path, or moves it into folder as name
+when folder is set. Names that are already taken in the folder get a
+number added, so nothing in the folder is ever replaced.
+*/
+func disposeFile(fsys FS, log *slog.Logger, path, name, folder string) error {
+	if folder == "" {
+		err := fsys.Remove(path)
+		logResult(log.With("path", path), "deleted", "delete failed", err)
+		return err
+	}
+
+	disposalMux.Lock()
+	defer disposalMux.Unlock()
+
+	if err := fsys.MkdirAll(folder, 0o755); err != nil {
+		return err
+	}
+
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	target := filepath.Join(folder, name)
+	for i := 1; ; i++ {
+		err := fsys.Rename(path, target)
+		if !errors.Is(err, fs.ErrExist) {
+			logResult(
+				log.With("path", path, "target", target),
+				"moved to disposal folder",
+				"move to disposal folder failed",
+				err,
+			)
+			return err
+		}
+		target = filepath.Join(folder, fmt.Sprintf("%s_%d%s", base, i, ext))
+	}
+}
@@ -0,0 +1,128 @@
+package lib
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DupeFile is a single file that belongs to a group of duplicates.
+type DupeFile struct {
+	// Where the file was found, which is where kept dupes stay
+	Path string
+	// Where the file was moved to while being reviewed, if anywhere
+	ReviewPath string
+	Size       int64
+	ModTime    time.Time
+	// Cached files were already renamed by a previous run
+	Cached bool
+	// The keeper is the one file in a group that survives
+	Keeper bool
+}
+
+// DupeGroup is every file that shares the same hash.
+type DupeGroup struct {
+	Hash  string
+	Files []DupeFile
+}
+
+/*
+DupeGroups returns every group of duplicates found by the last call to
+ProcessImages, sorted by hash. The file info is read from disk, so it
+should be called before the images are updated.
+*/
+func (ip *ImageProcessor) DupeGroups() ([]DupeGroup, error) {
+	if ip.processedImages == nil {
+		return nil, ErrNotProcessed
+	}
+
+	groups := make([]DupeGroup, 0, len(ip.processedImages.DupeImagesByHash))
+	for hash, dupes := range ip.processedImages.DupeImagesByHash {
+		group := DupeGroup{Hash: hash}
+		for _, dupe := range dupes {
+			df := DupeFile{Path: dupe.path, ReviewPath: dupe.reviewPath}
+			info, err := ip.fs.Stat(df.CurrentPath())
+			if err != nil {
+				return nil, err
+			}
+			df.Size = info.Size()
+			df.ModTime = info.ModTime()
+			df.Cached = dupe.cached
+			df.Keeper = dupe.isNovel
+			group.Files = append(group.Files, df)
+		}
+		groups = append(groups, group)
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Hash < groups[j].Hash
+	})
+
+	return groups, nil
+}
+
+// CurrentPath is where the file can be read from right now.
+func (df DupeFile) CurrentPath() string {
+	if df.ReviewPath != "" {
+		return df.ReviewPath
+	}
+	return df.Path
+}
+
+// Keeper returns the file that will be kept when the group is processed.
+func (dg DupeGroup) Keeper() (DupeFile, bool) {
+	for _, f := range dg.Files {
+		if f.Keeper {
+			return f, true
+		}
+	}
+	return DupeFile{}, false
+}
+
+/*
+KeeperName returns the file name the keeper will have after the images
+have been updated. Cached keepers have already been renamed.
+*/
+func (ip *ImageProcessor) KeeperName(dg DupeGroup) string {
+	keeper, ok := dg.Keeper()
+	if !ok {
+		return ""
+	}
+	if keeper.Cached {
+		return filepath.Base(keeper.Path)
+	}
+	return ip.HashName(dg.Hash, keeper.Path)
+}
+
+// HashName is the name the image at path is renamed to.
+func (ip *ImageProcessor) HashName(hash, path string) string {
+	// Uppercase extensions are ugly and inconsistent
+	ext := strings.ToLower(filepath.Ext(path))
+	return ip.hashPrefix + hash + ext
+}
+
+/*
+NewImages returns every image without dupes found by the last call to
+ProcessImages, by hash. These are the images that will be renamed, so
+cached images are left out.
+*/
+func (ip *ImageProcessor) NewImages() (map[string]DupeFile, error) {
+	if ip.processedImages == nil {
+		return nil, ErrNotProcessed
+	}
+
+	images := make(map[string]DupeFile, len(ip.processedImages.NewImagesByHash))
+	for hash, hi := range ip.processedImages.NewImagesByHash {
+		// Novel dupes of a review are listed with their group
+		if hi.cached || hi.reviewPath != "" {
+			continue
+		}
+		info, err := ip.fs.Stat(hi.path)
+		if err != nil {
+			return nil, err
+		}
+		images[hash] = DupeFile{Path: hi.path, Size: info.Size(), ModTime: info.ModTime()}
+	}
+	return images, nil
+}
@@ -8,4 +8,23 @@ var (
 	ErrHashPrefixTooShort = errors.New("hash prefix must be at least 3 characters")
 	ErrHashInfoNil        = errors.New("hash info is nil; it must be initialized")
 	ErrHashLengthTooShort = errors.New("hash length must be at least 10 characters")
+	ErrHashLengthTooLong  = errors.New("hash length is longer than the hash algorithm produces")
+
+	ErrUnknownAlgorithm    = errors.New("unknown hash algorithm")
+	ErrUnknownKeeperPolicy = errors.New("unknown keeper policy")
+	ErrUnknownDisposalMode = errors.New("unknown disposal mode")
+	ErrNoDisposalFolder    = errors.New("a disposal folder is required to move dupes")
+	ErrInvalidFolder       = errors.New("folders must be the name of a single folder in the directory")
+	ErrInvalidExtension    = errors.New("extensions must start with a dot")
+	ErrInvalidFilter       = errors.New("invalid file filter")
+	ErrUnknownLogFormat    = errors.New("unknown log format")
+
+	ErrNotProcessed = errors.New("images have not been processed")
+	ErrUnknownDupe  = errors.New("not a known duplicate")
+
+	ErrInvalidArchive = errors.New("invalid archive")
+
+	ErrNoReviewManifest = errors.New("no interrupted review found")
+	ErrReviewIncomplete = errors.New("review cannot be completed")
+	ErrInvalidManifest  = errors.New("invalid review manifest")
 )
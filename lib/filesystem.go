@@ -0,0 +1,92 @@
+package lib
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/jaeiya/hashimg/lib/utils"
+)
+
+/*
+FS is the filesystem that images are found, read, renamed and disposed
+of in. Paths are OS paths, like the ones built with filepath, so the
+working directory and every image path can be used as-is.
+*/
+type FS interface {
+	Open(name string) (fs.File, error)
+	ReadDir(name string) ([]fs.DirEntry, error)
+	Stat(name string) (fs.FileInfo, error)
+	// Rename never replaces newPath, instead the error wraps fs.ErrExist
+	Rename(oldPath, newPath string) error
+	Remove(name string) error
+	RemoveAll(name string) error
+	MkdirAll(name string, perm fs.FileMode) error
+	// WriteFile replaces the file in a single step, so it's never half written
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+}
+
+// OSFS is the filesystem of the operating system.
+type OSFS struct{}
+
+func (OSFS) Open(name string) (fs.File, error) {
+	return os.Open(name)
+}
+
+func (OSFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+func (OSFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (OSFS) Rename(oldPath, newPath string) error {
+	return utils.RenameNoReplace(oldPath, newPath)
+}
+
+func (OSFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (OSFS) RemoveAll(name string) error {
+	return os.RemoveAll(name)
+}
+
+func (OSFS) MkdirAll(name string, perm fs.FileMode) error {
+	return os.MkdirAll(name, perm)
+}
+
+// WriteFile writes to a temporary file first, so a crash can't leave a partial file.
+func (OSFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	file, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Chmod(perm); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), name)
+}
+
+// orOS defaults to the OS filesystem
+func orOS(fsys FS) FS {
+	if fsys == nil {
+		return OSFS{}
+	}
+	return fsys
+}
@@ -0,0 +1,139 @@
+package lib
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+FileFilter skips files by size and modification time. Zero values are
+not applied.
+*/
+type FileFilter struct {
+	MinSize        ByteSize
+	MaxSize        ByteSize
+	ModifiedAfter  FilterTime
+	ModifiedBefore FilterTime
+}
+
+func (ff FileFilter) IsZero() bool {
+	return ff == FileFilter{}
+}
+
+func (ff FileFilter) Validate() error {
+	if ff.MinSize < 0 || ff.MaxSize < 0 {
+		return fmt.Errorf("%w: sizes can't be negative", ErrInvalidFilter)
+	}
+	if ff.MaxSize > 0 && ff.MinSize > ff.MaxSize {
+		return fmt.Errorf("%w: min size is larger than max size", ErrInvalidFilter)
+	}
+	after, before := ff.ModifiedAfter.Time, ff.ModifiedBefore.Time
+	if !after.IsZero() && !before.IsZero() && !after.Before(before) {
+		return fmt.Errorf("%w: modified after must be before modified before", ErrInvalidFilter)
+	}
+	return nil
+}
+
+// Allows reports whether the file passes every filter.
+func (ff FileFilter) Allows(info os.FileInfo) bool {
+	if ff.MinSize > 0 && info.Size() < int64(ff.MinSize) {
+		return false
+	}
+	if ff.MaxSize > 0 && info.Size() > int64(ff.MaxSize) {
+		return false
+	}
+	if !ff.ModifiedAfter.IsZero() && !info.ModTime().After(ff.ModifiedAfter.Time) {
+		return false
+	}
+	if !ff.ModifiedBefore.IsZero() && !info.ModTime().Before(ff.ModifiedBefore.Time) {
+		return false
+	}
+	return true
+}
+
+// ByteSize is a size in bytes, written like 500, 12KB or 1.5GiB.
+type ByteSize int64
+
+var byteSizeUnits = map[string]float64{
+	"":    1,
+	"B":   1,
+	"KB":  1000,
+	"MB":  1000 * 1000,
+	"GB":  1000 * 1000 * 1000,
+	"KIB": 1024,
+	"MIB": 1024 * 1024,
+	"GIB": 1024 * 1024 * 1024,
+}
+
+func (b *ByteSize) UnmarshalText(text []byte) error {
+	s := strings.TrimSpace(string(text))
+	split := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if split == -1 {
+		split = len(s)
+	}
+
+	value, err := strconv.ParseFloat(s[:split], 64)
+	if err != nil {
+		return fmt.Errorf("%w: invalid size %q", ErrInvalidFilter, s)
+	}
+	unit, ok := byteSizeUnits[strings.ToUpper(strings.TrimSpace(s[split:]))]
+	if !ok {
+		return fmt.Errorf("%w: unknown size unit in %q", ErrInvalidFilter, s)
+	}
+
+	*b = ByteSize(value * unit)
+	return nil
+}
+
+// String is the size in the largest binary unit it fills, like 1.5 GiB.
+func (b ByteSize) String() string {
+	units := []string{"KiB", "MiB", "GiB", "TiB"}
+	if b < 1024 && b > -1024 {
+		return fmt.Sprintf("%d B", int64(b))
+	}
+	size := float64(b) / 1024
+	unit := units[0]
+	for _, next := range units[1:] {
+		if size < 1024 && size > -1024 {
+			break
+		}
+		size /= 1024
+		unit = next
+	}
+	return strconv.FormatFloat(size, 'f', 1, 64) + " " + unit
+}
+
+func (b ByteSize) MarshalText() ([]byte, error) {
+	if b == 0 {
+		return []byte{}, nil
+	}
+	return []byte(strconv.FormatInt(int64(b), 10)), nil
+}
+
+// FilterTime is a time written as a date (2006-01-02) or in RFC 3339.
+type FilterTime struct {
+	time.Time
+}
+
+func (ft *FilterTime) UnmarshalText(text []byte) error {
+	s := strings.TrimSpace(string(text))
+	for _, layout := range []string{time.DateOnly, time.RFC3339} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			ft.Time = t
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: invalid time %q", ErrInvalidFilter, s)
+}
+
+func (ft FilterTime) MarshalText() ([]byte, error) {
+	if ft.IsZero() {
+		return []byte{}, nil
+	}
+	return []byte(ft.Format(time.RFC3339)), nil
+}
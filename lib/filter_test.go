@@ -0,0 +1,111 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestByteSize(t *testing.T) {
+	md := []struct {
+		text string
+		size ByteSize
+	}{
+		{"500", 500},
+		{"12KB", 12_000},
+		{"1.5 GiB", 1.5 * 1024 * 1024 * 1024},
+		{"2mib", 2 * 1024 * 1024},
+	}
+
+	for _, d := range md {
+		t.Run("should parse "+d.text, func(t *testing.T) {
+			t.Parallel()
+			var size ByteSize
+			require.NoError(t, size.UnmarshalText([]byte(d.text)))
+			assert.Equal(t, d.size, size)
+		})
+	}
+
+	t.Run("should error on unknown units", func(t *testing.T) {
+		t.Parallel()
+		var size ByteSize
+		assert.ErrorIs(t, size.UnmarshalText([]byte("12 parsecs")), ErrInvalidFilter)
+	})
+
+	t.Run("should format in the largest unit it fills", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		a.Equal("0 B", ByteSize(0).String())
+		a.Equal("1023 B", ByteSize(1023).String())
+		a.Equal("1.5 KiB", ByteSize(1536).String())
+		a.Equal("1.5 GiB", ByteSize(1.5*1024*1024*1024).String())
+		a.Equal("2048.0 TiB", ByteSize(2*1024*1024*1024*1024*1024).String())
+	})
+}
+
+func TestFilterTime(t *testing.T) {
+	t.Run("should parse dates in the local time zone", func(t *testing.T) {
+		t.Parallel()
+		var ft FilterTime
+		require.NoError(t, ft.UnmarshalText([]byte(" 2021-03-04 ")))
+		assert.Equal(t, time.Date(2021, 3, 4, 0, 0, 0, 0, time.Local), ft.Time)
+	})
+
+	t.Run("should parse RFC 3339 times", func(t *testing.T) {
+		t.Parallel()
+		var ft FilterTime
+		require.NoError(t, ft.UnmarshalText([]byte("2021-03-04T05:06:07Z")))
+		assert.True(t, time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC).Equal(ft.Time))
+	})
+
+	t.Run("should error on invalid times", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		var ft FilterTime
+		a.ErrorIs(ft.UnmarshalText([]byte("yesterday")), ErrInvalidFilter)
+		a.ErrorIs(ft.UnmarshalText([]byte("2021-13-01")), ErrInvalidFilter)
+		a.True(ft.IsZero())
+	})
+
+	t.Run("should marshal to what it parses", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		text, err := FilterTime{}.MarshalText()
+		require.NoError(t, err)
+		a.Empty(text)
+
+		ft := FilterTime{Time: time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)}
+		text, err = ft.MarshalText()
+		require.NoError(t, err)
+		a.Equal("2021-03-04T05:06:07Z", string(text))
+
+		var parsed FilterTime
+		require.NoError(t, parsed.UnmarshalText(text))
+		a.True(ft.Equal(parsed.Time))
+	})
+
+	t.Run("should only allow files modified inside the range", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		fsys := NewMemFS()
+		require.NoError(t, fsys.WriteFile("/a.jpg", []byte("1"), 0o600))
+		info, err := fsys.Stat("/a.jpg")
+		require.NoError(t, err)
+		mtime := info.ModTime()
+
+		hour := time.Hour
+		a.True(FileFilter{ModifiedAfter: FilterTime{mtime.Add(-hour)}}.Allows(info))
+		a.False(FileFilter{ModifiedAfter: FilterTime{mtime}}.Allows(info))
+		a.True(FileFilter{ModifiedBefore: FilterTime{mtime.Add(hour)}}.Allows(info))
+		a.False(FileFilter{ModifiedBefore: FilterTime{mtime}}.Allows(info))
+	})
+
+	t.Run("should reject ranges that end before they start", func(t *testing.T) {
+		t.Parallel()
+		now := time.Now()
+		ff := FileFilter{ModifiedAfter: FilterTime{now}, ModifiedBefore: FilterTime{now.Add(-time.Hour)}}
+		assert.ErrorIs(t, ff.Validate(), ErrInvalidFilter)
+	})
+}
@@ -0,0 +1,61 @@
+package lib
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+)
+
+type HashAlgorithm string
+
+const (
+	SHA256 HashAlgorithm = "sha256"
+	SHA512 HashAlgorithm = "sha512"
+	SHA1   HashAlgorithm = "sha1"
+	MD5    HashAlgorithm = "md5"
+)
+
+var hashAlgorithms = map[HashAlgorithm]func() hash.Hash{
+	SHA256: sha256.New,
+	SHA512: sha512.New,
+	SHA1:   sha1.New,
+	MD5:    md5.New,
+}
+
+// newHash creates a hash of the algorithm, which defaults to SHA256.
+func (a HashAlgorithm) newHash() (hash.Hash, error) {
+	a = a.orDefault()
+	newHash, ok := hashAlgorithms[a]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownAlgorithm, a)
+	}
+	return newHash(), nil
+}
+
+func (a HashAlgorithm) orDefault() HashAlgorithm {
+	if a == "" {
+		return SHA256
+	}
+	return a
+}
+
+/*
+validateLength makes sure hashes of the algorithm can be cut to length,
+which is counted in hex characters.
+*/
+func (a HashAlgorithm) validateLength(length int) error {
+	if length < 10 {
+		return ErrHashLengthTooShort
+	}
+	h, err := a.newHash()
+	if err != nil {
+		return err
+	}
+	if length > h.Size()*2 {
+		return fmt.Errorf("%w: %s has %d characters", ErrHashLengthTooLong, a, h.Size()*2)
+	}
+	return nil
+}
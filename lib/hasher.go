@@ -1,11 +1,8 @@
 package lib
 
 import (
-	"bufio"
-	"crypto/sha256"
 	"fmt"
 	"io"
-	"os"
 	fPath "path/filepath"
 	"strings"
 	"sync"
@@ -15,7 +12,9 @@ import (
 
 type HashResult struct {
 	newHashesInfo []HashInfo
-	oldHashesInfo map[string]HashInfo
+	// Cached files can share a hash, like after a manual rename
+	// changed their extension
+	oldHashesInfo map[string][]HashInfo
 }
 
 type HashInfo struct {
@@ -28,28 +27,61 @@ type HashInfo struct {
 	path    string
 	cached  bool
 	err     error
+	// Where the image was moved to while it's being reviewed
+	reviewPath string
 }
 
 type HasherConfig struct {
 	// The smaller this is, the higher chance of collisions
 	Length int
-	// How many goroutines should be in pool
+	// Defaults to SHA256
+	Algorithm HashAlgorithm
+	// How many goroutines should be in the hashing pool. It always has
+	// at least one for every reader, since each read streams to its own.
 	Threads int
+	// How many files are read at the same time. Spinning disks are
+	// fastest with one or two readers, flash storage with many.
+	Readers int
+	// Size of each read in bytes
+	ChunkSize int
 	// Queue channel minimum size
 	QueueSize int
 	// Container for hash results
 	HashResult *HashResult
 	// Should be a unique string
-	Prefix     string
-	BufferSize int64
+	Prefix string
+	// Called after every read with the amount of bytes read, from
+	// multiple goroutines
+	OnRead func(n int)
+	// Defaults to the OS filesystem
+	FS FS
 }
 
+/*
+Hasher reads files and hashes them in two separate stages, so that slow
+disks are never read by more goroutines than they can handle, while
+hashing still uses every CPU.
+*/
 type Hasher struct {
 	mux        sync.Mutex
 	threadPool *utils.ThreadPool
 	cfg        HasherConfig
+	reads      chan hashJob
+	readers    sync.WaitGroup
+	chunkPool  sync.Pool
+}
+
+type hashJob struct {
+	filePath string
+	callBack func(cs CacheStatus)
 }
 
+const (
+	defaultChunkSize = 256 * 1024
+	// How many chunks a reader can get ahead of its hasher
+	chunkQueueSize = 4
+)
+
 /*
 NewHasher creates a Hasher which takes a HasherConfig.
 
@@ -66,73 +98,148 @@ func NewHasher(cfg HasherConfig) (*Hasher, error) {
 	}
 
 	if cfg.HashResult.oldHashesInfo == nil {
-		cfg.HashResult.oldHashesInfo = make(map[string]HashInfo)
+		cfg.HashResult.oldHashesInfo = make(map[string][]HashInfo)
 	}
 
-	if cfg.Length < 10 {
-		return nil, ErrHashLengthTooShort
+	if err := cfg.Algorithm.validateLength(cfg.Length); err != nil {
+		return nil, err
 	}
 
+	cfg.Readers = max(cfg.Readers, 1)
+	// A reader stalls once it's ahead of its hasher, so a smaller pool
+	// would quietly limit how many files are read at the same time
+	cfg.Threads = max(cfg.Threads, cfg.Readers)
+
 	tp, err := utils.NewThreadPool(cfg.Threads, cfg.QueueSize, false)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Hasher{
+	if cfg.ChunkSize <= 0 {
+		cfg.ChunkSize = defaultChunkSize
+	}
+	cfg.FS = orOS(cfg.FS)
+
+	h := &Hasher{
 		threadPool: tp,
 		cfg:        cfg,
-	}, nil
+		reads:      make(chan hashJob, cfg.QueueSize),
+	}
+	h.chunkPool.New = func() any {
+		buf := make([]byte, cfg.ChunkSize)
+		return &buf
+	}
+
+	h.readers.Add(cfg.Readers)
+	for range cfg.Readers {
+		go h.reader()
+	}
+
+	return h, nil
 }
 
+/*
+Hash queues the file to be hashed. Files are read in the order they're
+queued, so callers can sort them to reduce seeking on spinning disks.
+Cached files already have their hash in their name, so they're never
+read.
+*/
 func (h *Hasher) Hash(
 	fileName string,
 	cs CacheStatus,
 	filePath string,
 	callBack func(cs CacheStatus),
 ) {
-	h.threadPool.Queue(func() {
-		hi := HashInfo{path: filePath}
-
-		if cs == Cached {
-			ext := fPath.Ext(fileName)
-			hi.hash = strings.TrimPrefix(fileName[0:len(fileName)-len(ext)], h.cfg.Prefix)
-			hi.cached = true
-		} else {
-			hi.hash, hi.err = h.computeHash(filePath)
+	if cs == Cached {
+		ext := fPath.Ext(fileName)
+		hi := HashInfo{
+			path:   filePath,
+			hash:   strings.TrimPrefix(fileName[0:len(fileName)-len(ext)], h.cfg.Prefix),
+			cached: true,
 		}
-
 		h.mux.Lock()
-		if cs == Cached {
-			h.cfg.HashResult.oldHashesInfo[hi.hash] = hi
-		} else {
-			h.cfg.HashResult.newHashesInfo = append(h.cfg.HashResult.newHashesInfo, hi)
-		}
+		h.cfg.HashResult.oldHashesInfo[hi.hash] = append(h.cfg.HashResult.oldHashesInfo[hi.hash], hi)
 		h.mux.Unlock()
 		callBack(cs)
-	})
+		return
+	}
+
+	h.reads <- hashJob{filePath: filePath, callBack: callBack}
 }
 
 func (h *Hasher) Wait() {
+	close(h.reads)
+	h.readers.Wait()
 	h.threadPool.Wait()
 }
 
-func (h *Hasher) computeHash(filePath string) (string, error) {
-	file, err := os.Open(filePath)
+func (h *Hasher) reader() {
+	defer h.readers.Done()
+	for job := range h.reads {
+		h.readFile(job)
+	}
+}
+
+/*
+readFile streams the file in chunks to a goroutine in the hashing pool.
+The reader blocks when it gets too far ahead, so memory use is limited
+to a few chunks per reader.
+*/
+func (h *Hasher) readFile(job hashJob) {
+	chunks := make(chan *[]byte, chunkQueueSize)
+	var readErr error
+
+	h.threadPool.Queue(func() {
+		// The algorithm was validated by NewHasher
+		sum, _ := h.cfg.Algorithm.newHash()
+		for chunk := range chunks {
+			sum.Write(*chunk)
+			*chunk = (*chunk)[:cap(*chunk)]
+			h.chunkPool.Put(chunk)
+		}
+
+		hi := HashInfo{path: job.filePath}
+		// The reader is done once chunks is closed
+		if readErr != nil {
+			hi.err = readErr
+		} else {
+			hi.hash = fmt.Sprintf("%x", sum.Sum(nil))[0:h.cfg.Length]
+		}
+
+		h.mux.Lock()
+		h.cfg.HashResult.newHashesInfo = append(h.cfg.HashResult.newHashesInfo, hi)
+		h.mux.Unlock()
+		job.callBack(NotCached)
+	})
+
+	defer close(chunks)
+
+	file, err := h.cfg.FS.Open(job.filePath)
 	if err != nil {
-		return "", err
+		readErr = err
+		return
 	}
 	defer file.Close()
 
-	var buf *bufio.Reader
-	if h.cfg.BufferSize > 0 {
-		buf = bufio.NewReaderSize(file, int(h.cfg.BufferSize))
-	} else {
-		buf = bufio.NewReader(file)
-	}
+	for {
+		chunk := h.chunkPool.Get().(*[]byte)
+		n, err := io.ReadFull(file, *chunk)
+		if n > 0 {
+			*chunk = (*chunk)[:n]
+			if h.cfg.OnRead != nil {
+				h.cfg.OnRead(n)
+			}
+			chunks <- chunk
+		} else {
+			h.chunkPool.Put(chunk)
+		}
 
-	sha := sha256.New()
-	if _, err := io.Copy(sha, buf); err != nil {
-		return "", err
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return
+		}
+		if err != nil {
+			readErr = err
+			return
+		}
 	}
-	return fmt.Sprintf("%x", sha.Sum(nil))[0:h.cfg.Length], nil
 }
@@ -1,9 +1,14 @@
 package lib
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestHasher(t *testing.T) {
@@ -44,4 +49,133 @@ func TestHasher(t *testing.T) {
 		})
 		a.ErrorIs(err, ErrHashLengthTooShort)
 	})
+
+	t.Run("should have a hashing thread for every reader", func(t *testing.T) {
+		t.Parallel()
+		h, err := NewHasher(HasherConfig{
+			Length:     32,
+			Threads:    2,
+			Readers:    8,
+			QueueSize:  100,
+			HashResult: &HashResult{},
+			Prefix:     "tst",
+		})
+		require.NoError(t, err)
+		a.Equal(8, h.cfg.Threads)
+		h.Wait()
+	})
+}
+
+func TestHasherReaders(t *testing.T) {
+	dir := t.TempDir()
+	fileNames := writeBenchFiles(t, dir, 20, 600*1024)
+
+	for _, readers := range []int{1, 4} {
+		t.Run(fmt.Sprintf("should hash every file with %d readers", readers), func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+			hr := hashFiles(t, dir, fileNames, readers)
+			a.Len(hr.newHashesInfo, len(fileNames))
+			for _, hi := range hr.newHashesInfo {
+				a.NoError(hi.err)
+				data, err := os.ReadFile(hi.path)
+				require.NoError(t, err)
+				a.Equal(calcSha256(string(data)), hi.hash)
+			}
+		})
+	}
+
+	t.Run("should record read errors", func(t *testing.T) {
+		t.Parallel()
+		hr := hashFiles(t, dir, []string{"missing.jpg"}, 1)
+		require.Len(t, hr.newHashesInfo, 1)
+		assert.Error(t, hr.newHashesInfo[0].err)
+	})
+}
+
+/*
+BenchmarkHasher compares read strategies. The differences only show on
+real drives with a cold page cache, so run these against a directory on
+the drive being tested. The files are only written on the first run, so
+drop the cache before the next one, e.g. on Linux:
+
+	HASHIMG_BENCH_DIR=/mnt/hdd/bench go test -bench Hasher -benchtime 1x ./lib
+	sync; echo 3 | sudo tee /proc/sys/vm/drop_caches
+	HASHIMG_BENCH_DIR=/mnt/hdd/bench go test -bench Hasher -benchtime 1x ./lib
+*/
+func BenchmarkHasher(b *testing.B) {
+	dir := os.Getenv("HASHIMG_BENCH_DIR")
+	if dir == "" {
+		dir = b.TempDir()
+	}
+	fileNames := writeBenchFiles(b, dir, 200, 512*1024)
+
+	ordered := orderFiles(b, dir, fileNames)
+
+	strategies := []struct {
+		name      string
+		readers   int
+		fileNames []string
+	}{
+		{"1 reader ordered", 1, ordered},
+		{"1 reader", 1, fileNames},
+		{"4 readers", 4, fileNames},
+		{"NumCPUx2 readers", runtime.NumCPU() * 2, fileNames},
+	}
+
+	for _, s := range strategies {
+		b.Run(s.name, func(b *testing.B) {
+			b.SetBytes(int64(len(fileNames) * 512 * 1024))
+			for range b.N {
+				hashFiles(b, dir, s.fileNames, s.readers)
+			}
+		})
+	}
+}
+
+func hashFiles(tb testing.TB, dir string, fileNames []string, readers int) *HashResult {
+	hr := &HashResult{}
+	hasher, err := NewHasher(HasherConfig{
+		Length:     hashLength,
+		Threads:    poolSize(),
+		Readers:    readers,
+		QueueSize:  max(len(fileNames), 10),
+		HashResult: hr,
+		Prefix:     "tst",
+	})
+	require.NoError(tb, err)
+
+	for _, fileName := range fileNames {
+		hasher.Hash(fileName, NotCached, filepath.Join(dir, fileName), func(CacheStatus) {})
+	}
+	hasher.Wait()
+	return hr
+}
+
+func writeBenchFiles(tb testing.TB, dir string, count, size int) []string {
+	fileNames := make([]string, count)
+	for i := range count {
+		fileNames[i] = fmt.Sprintf("bench%d.jpg", i)
+		path := filepath.Join(dir, fileNames[i])
+		// Reusing files lets the page cache be dropped between runs
+		if info, err := os.Stat(path); err == nil && info.Size() == int64(size) {
+			continue
+		}
+		data := make([]byte, size)
+		for j := range data {
+			data[j] = byte(i + j)
+		}
+		require.NoError(tb, os.WriteFile(path, data, 0o644))
+	}
+	return fileNames
+}
+
+func orderFiles(tb testing.TB, dir string, fileNames []string) []string {
+	ip := NewImageProcessor(ImageProcessorConfig{WorkingDir: dir, ImageMap: ImageMap{}})
+	for _, fileName := range fileNames {
+		ip.imageMap[fileName] = NotCached
+	}
+	ordered, err := ip.planReads(true)
+	require.NoError(tb, err)
+	return ordered
 }
@@ -0,0 +1,279 @@
+package lib
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// HistoryFileName is the name of the run history inside the state directory
+const HistoryFileName = "history.jsonl"
+
+// How many runs are listed as the largest space savings
+const largestSavingsCount = 5
+
+/*
+RunRecord summarizes a run, so that runs can be compared over time. Only
+counts are kept, never the names of the images.
+*/
+type RunRecord struct {
+	Dir          string     `json:"dir"`
+	StartedAt    time.Time  `json:"started_at"`
+	Options      RunOptions `json:"options"`
+	Images       int32      `json:"images"`
+	Videos       int32      `json:"videos"`
+	Dupes        int32      `json:"dupes"`
+	KeptDupes    int32      `json:"kept_dupes"`
+	Cached       int32      `json:"cached"`
+	New          int32      `json:"new"`
+	Conflicts    int32      `json:"conflicts"`
+	Archived     int32      `json:"archived"`
+	ArchiveDupes int32      `json:"archive_dupes"`
+	HashedBytes  int64      `json:"hashed_bytes"`
+	// Bytes of the dupes that were disposed of
+	ReclaimedBytes int64         `json:"reclaimed_bytes"`
+	HashingTook    time.Duration `json:"hashing_took_ns"`
+	UpdatingTook   time.Duration `json:"updating_took_ns"`
+	TotalTime      time.Duration `json:"total_time_ns"`
+}
+
+// RunOptions are the options that change what a run does.
+type RunOptions struct {
+	Algorithm    HashAlgorithm `json:"algorithm"`
+	HashLength   int           `json:"hash_length"`
+	Prefix       string        `json:"prefix"`
+	KeeperPolicy KeeperPolicy  `json:"keeper_policy"`
+	Disposal     DisposalMode  `json:"disposal"`
+	Videos       bool          `json:"videos"`
+	Archives     bool          `json:"archives"`
+	Review       bool          `json:"review"`
+	Migrate      bool          `json:"migrate"`
+}
+
+// HistoryStats are the totals of every run, along with their trends.
+type HistoryStats struct {
+	Runs     int       `json:"runs"`
+	FirstRun time.Time `json:"first_run"`
+	LastRun  time.Time `json:"last_run"`
+	// Images are counted once per run, so the same image can be
+	// counted many times
+	Images         int64         `json:"images"`
+	Dupes          int64         `json:"dupes"`
+	New            int64         `json:"new"`
+	HashedBytes    int64         `json:"hashed_bytes"`
+	ReclaimedBytes int64         `json:"reclaimed_bytes"`
+	TotalTime      time.Duration `json:"total_time_ns"`
+	// Sorted by the most reclaimed bytes first
+	Dirs []DirHistory `json:"dirs"`
+	// The runs that reclaimed the most bytes, most first
+	LargestSavings []RunRecord `json:"largest_savings"`
+}
+
+type DirHistory struct {
+	Dir            string    `json:"dir"`
+	Runs           int       `json:"runs"`
+	Dupes          int64     `json:"dupes"`
+	ReclaimedBytes int64     `json:"reclaimed_bytes"`
+	FirstRun       time.Time `json:"first_run"`
+	LastRun        time.Time `json:"last_run"`
+	// One point per run, oldest first
+	Trend []TrendPoint `json:"trend"`
+}
+
+type TrendPoint struct {
+	StartedAt      time.Time `json:"started_at"`
+	Images         int32     `json:"images"`
+	Dupes          int32     `json:"dupes"`
+	ReclaimedBytes int64     `json:"reclaimed_bytes"`
+}
+
+// NewRunRecord summarizes the run of ip, which was set up with cfg.
+func NewRunRecord(ip *ImageProcessor, cfg Config, startedAt time.Time) RunRecord {
+	status := ip.Status
+	return RunRecord{
+		Dir:       ip.WorkingDir,
+		StartedAt: startedAt,
+		Options: RunOptions{
+			Algorithm:    cfg.Algorithm,
+			HashLength:   cfg.Length,
+			Prefix:       cfg.Prefix,
+			KeeperPolicy: cfg.KeeperPolicy,
+			Disposal:     cfg.Disposal,
+			Videos:       cfg.Videos,
+			Archives:     cfg.Archives,
+			Review:       ip.IsReview(),
+		},
+		Images:         status.TotalImageCount,
+		Videos:         status.TotalVideoCount,
+		Dupes:          status.DupeImageCount,
+		KeptDupes:      status.KeptDupeCount,
+		Cached:         status.CachedImageCount,
+		New:            status.NewImageCount,
+		Conflicts:      status.ConflictCount,
+		Archived:       status.ArchivedImageCount,
+		ArchiveDupes:   status.ArchiveDupeCount,
+		HashedBytes:    status.HashedBytes,
+		ReclaimedBytes: status.ReclaimedBytes,
+		HashingTook:    status.HashingTook,
+		UpdatingTook:   status.UpdatingTook,
+		TotalTime:      ip.ProcessTime,
+	}
+}
+
+// HistoryPath is the path of the run history inside StateDir.
+func HistoryPath() (string, error) {
+	dir, err := StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, HistoryFileName), nil
+}
+
+/*
+AppendHistory adds the record to the end of the history at path, one
+JSON object per line. Each record is a single write, so runs in other
+directories can append at the same time.
+*/
+func AppendHistory(path string, rec RunRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+/*
+ReadHistory reads the records of the history at path, oldest first. A
+missing history has no records, and lines that can't be decoded, like
+one cut short by a crash, are skipped.
+*/
+func ReadHistory(path string) ([]RunRecord, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []RunRecord{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	records := []RunRecord{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		rec := RunRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue
+		}
+		records = append(records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	slices.SortStableFunc(records, func(a, b RunRecord) int {
+		return a.StartedAt.Compare(b.StartedAt)
+	})
+	return records, nil
+}
+
+/*
+CachedAlgorithm is the algorithm the cached images in dir were named
+with, according to the records, or "" when no run in dir was recorded.
+Runs with another algorithm only name new images, so the cached ones
+keep the algorithm they had until a run migrates them.
+*/
+func CachedAlgorithm(records []RunRecord, dir string) HashAlgorithm {
+	var algorithm HashAlgorithm
+	for _, rec := range records {
+		if rec.Dir != dir {
+			continue
+		}
+		recAlgorithm := rec.Options.Algorithm.orDefault()
+		if algorithm == "" || rec.Options.Migrate {
+			algorithm = recAlgorithm
+		}
+	}
+	return algorithm
+}
+
+// SummarizeHistory totals the records, which are sorted oldest first.
+func SummarizeHistory(records []RunRecord) HistoryStats {
+	hs := HistoryStats{
+		Runs:           len(records),
+		Dirs:           []DirHistory{},
+		LargestSavings: []RunRecord{},
+	}
+	if len(records) == 0 {
+		return hs
+	}
+	hs.FirstRun = records[0].StartedAt
+	hs.LastRun = records[len(records)-1].StartedAt
+
+	dirs := map[string]*DirHistory{}
+	for _, rec := range records {
+		hs.Images += int64(rec.Images)
+		hs.Dupes += int64(rec.Dupes)
+		hs.New += int64(rec.New)
+		hs.HashedBytes += rec.HashedBytes
+		hs.ReclaimedBytes += rec.ReclaimedBytes
+		hs.TotalTime += rec.TotalTime
+
+		dh, ok := dirs[rec.Dir]
+		if !ok {
+			dh = &DirHistory{Dir: rec.Dir, FirstRun: rec.StartedAt, Trend: []TrendPoint{}}
+			dirs[rec.Dir] = dh
+		}
+		dh.Runs += 1
+		dh.Dupes += int64(rec.Dupes)
+		dh.ReclaimedBytes += rec.ReclaimedBytes
+		dh.LastRun = rec.StartedAt
+		dh.Trend = append(dh.Trend, TrendPoint{
+			StartedAt:      rec.StartedAt,
+			Images:         rec.Images,
+			Dupes:          rec.Dupes,
+			ReclaimedBytes: rec.ReclaimedBytes,
+		})
+
+		if rec.ReclaimedBytes > 0 {
+			hs.LargestSavings = append(hs.LargestSavings, rec)
+		}
+	}
+
+	for _, dh := range dirs {
+		hs.Dirs = append(hs.Dirs, *dh)
+	}
+	slices.SortFunc(hs.Dirs, func(a, b DirHistory) int {
+		if a.ReclaimedBytes != b.ReclaimedBytes {
+			return cmp.Compare(b.ReclaimedBytes, a.ReclaimedBytes)
+		}
+		return strings.Compare(a.Dir, b.Dir)
+	})
+
+	// Stable, so that equal savings stay in the order they happened
+	slices.SortStableFunc(hs.LargestSavings, func(a, b RunRecord) int {
+		return cmp.Compare(b.ReclaimedBytes, a.ReclaimedBytes)
+	})
+	if len(hs.LargestSavings) > largestSavingsCount {
+		hs.LargestSavings = hs.LargestSavings[:largestSavingsCount]
+	}
+	return hs
+}
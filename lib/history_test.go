@@ -0,0 +1,157 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistory(t *testing.T) {
+	start := time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)
+	record := func(dir string, hours, images, dupes int32, reclaimed int64) RunRecord {
+		return RunRecord{
+			Dir:            dir,
+			StartedAt:      start.Add(time.Duration(hours) * time.Hour),
+			Images:         images,
+			Dupes:          dupes,
+			ReclaimedBytes: reclaimed,
+			TotalTime:      time.Second,
+		}
+	}
+
+	t.Run("should append and read runs in order", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		path := filepath.Join(t.TempDir(), "state", HistoryFileName)
+
+		records, err := ReadHistory(path)
+		require.NoError(t, err)
+		a.Empty(records, "should have no runs without a history")
+
+		later, earlier := record("/a", 2, 10, 1, 100), record("/b", 1, 5, 0, 0)
+		require.NoError(t, AppendHistory(path, later))
+		require.NoError(t, AppendHistory(path, earlier))
+		// Like a run that crashed while writing
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+		require.NoError(t, err)
+		_, err = file.WriteString(`{"dir":"/c","imag`)
+		require.NoError(t, err)
+		require.NoError(t, file.Close())
+
+		records, err = ReadHistory(path)
+		require.NoError(t, err)
+		a.Equal([]RunRecord{earlier, later}, records)
+	})
+
+	t.Run("should find the algorithm of cached images", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		run := func(dir string, hours int32, algorithm HashAlgorithm, migrate bool) RunRecord {
+			rec := record(dir, hours, 1, 0, 0)
+			rec.Options.Algorithm = algorithm
+			rec.Options.Migrate = migrate
+			return rec
+		}
+
+		a.Empty(CachedAlgorithm(nil, "/a"))
+
+		records := []RunRecord{
+			run("/a", 0, "", false),
+			run("/b", 1, MD5, false),
+			run("/a", 2, SHA1, false),
+		}
+		a.Equal(SHA256, CachedAlgorithm(records, "/a"), "should keep the first algorithm")
+		a.Equal(MD5, CachedAlgorithm(records, "/b"))
+
+		records = append(records, run("/a", 3, SHA1, true))
+		a.Equal(SHA1, CachedAlgorithm(records, "/a"), "should use the migrated algorithm")
+	})
+
+	t.Run("should summarize runs", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		records := []RunRecord{
+			record("/a", 0, 10, 4, 400),
+			record("/b", 1, 3, 0, 0),
+			record("/a", 2, 12, 2, 200),
+			record("/b", 3, 8, 5, 900),
+		}
+
+		hs := SummarizeHistory(records)
+		a.Equal(4, hs.Runs)
+		a.Equal(start, hs.FirstRun)
+		a.Equal(start.Add(3*time.Hour), hs.LastRun)
+		a.EqualValues(33, hs.Images)
+		a.EqualValues(11, hs.Dupes)
+		a.EqualValues(1500, hs.ReclaimedBytes)
+		a.Equal(4*time.Second, hs.TotalTime)
+
+		require.Len(t, hs.Dirs, 2)
+		a.Equal("/b", hs.Dirs[0].Dir, "should sort by the most reclaimed bytes")
+		a.Equal(DirHistory{
+			Dir:            "/a",
+			Runs:           2,
+			Dupes:          6,
+			ReclaimedBytes: 600,
+			FirstRun:       start,
+			LastRun:        start.Add(2 * time.Hour),
+			Trend: []TrendPoint{
+				{StartedAt: start, Images: 10, Dupes: 4, ReclaimedBytes: 400},
+				{StartedAt: start.Add(2 * time.Hour), Images: 12, Dupes: 2, ReclaimedBytes: 200},
+			},
+		}, hs.Dirs[1])
+
+		a.Equal([]RunRecord{records[3], records[0], records[2]}, hs.LargestSavings)
+	})
+
+	t.Run("should record the bytes reclaimed by a run", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		const hashPrefix = "0x@"
+		dir := filepath.Join(string(filepath.Separator), "images")
+		fsys := NewMemFS()
+		require.NoError(t, fsys.MkdirAll(dir, 0o755))
+		require.NoError(t, writeFiles(fsys, dir, []string{"a.png", "b.png", "c.png"}, []string{"dupe", "dupe", "dupe"}))
+		iMap, err := MapImagesWithConfig(MapperConfig{Dir: dir, Prefix: hashPrefix, FS: fsys})
+		require.NoError(t, err)
+
+		cfg := DefaultConfig()
+		ipCfg := cfg.ProcessorConfig(dir)
+		ipCfg.ImageMap = iMap
+		ipCfg.FS = fsys
+		ip := NewImageProcessor(ipCfg)
+		require.NoError(t, ip.ProcessImages(false))
+		require.NoError(t, ip.UpdateImages())
+
+		rec := NewRunRecord(ip, cfg, start)
+		a.Equal(dir, rec.Dir)
+		a.EqualValues(3, rec.Images)
+		a.EqualValues(2, rec.Dupes)
+		a.EqualValues(len("dupe")*2, rec.ReclaimedBytes)
+		a.Equal(RunOptions{
+			Algorithm:    cfg.Algorithm,
+			HashLength:   cfg.Length,
+			Prefix:       cfg.Prefix,
+			KeeperPolicy: cfg.KeeperPolicy,
+			Disposal:     cfg.Disposal,
+		}, rec.Options)
+	})
+}
+
+func TestStateDir(t *testing.T) {
+	t.Run("should use XDG_STATE_HOME", func(t *testing.T) {
+		state := filepath.Join(t.TempDir(), "state")
+		t.Setenv("XDG_STATE_HOME", state)
+		dir, err := StateDir()
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(state, "hashimg"), dir)
+
+		path, err := HistoryPath()
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(state, "hashimg", HistoryFileName), path)
+	})
+}
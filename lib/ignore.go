@@ -0,0 +1,195 @@
+package lib
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// IgnoreFileName is the name of the ignore file inside a target directory
+const IgnoreFileName = ".hashimgignore"
+
+/*
+IgnoreMatcher matches paths against gitignore-style patterns:
+
+  - Blank lines and lines starting with # are skipped
+  - A leading ! re-includes paths excluded by an earlier pattern
+  - A trailing / only matches directories
+  - Patterns containing a / are matched against the whole relative
+    path, other patterns against the name at any depth
+  - * and ? never match a /, while ** matches any number of folders
+
+The last pattern that matches a path decides whether it's ignored, and
+everything inside an ignored directory is ignored.
+*/
+type IgnoreMatcher struct {
+	rules []ignoreRule
+}
+
+type ignoreRule struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+func NewIgnoreMatcher(patterns []string) (*IgnoreMatcher, error) {
+	im := &IgnoreMatcher{}
+	for _, pattern := range patterns {
+		rule, ok, err := compileIgnorePattern(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", err, pattern)
+		}
+		if ok {
+			im.rules = append(im.rules, rule)
+		}
+	}
+	return im, nil
+}
+
+/*
+ReadIgnoreFile reads the patterns of the .hashimgignore file inside dir.
+A missing file has no patterns.
+*/
+func ReadIgnoreFile(dir string) ([]string, error) {
+	return readIgnoreFile(OSFS{}, dir)
+}
+
+func readIgnoreFile(fsys FS, dir string) ([]string, error) {
+	file, err := fsys.Open(filepath.Join(dir, IgnoreFileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	patterns := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		patterns = append(patterns, scanner.Text())
+	}
+	return patterns, scanner.Err()
+}
+
+// Match reports whether the path, relative to the target directory, is ignored.
+func (im *IgnoreMatcher) Match(relPath string, isDir bool) bool {
+	if im == nil || len(im.rules) == 0 {
+		return false
+	}
+
+	relPath = filepath.ToSlash(relPath)
+	parts := strings.Split(relPath, "/")
+	// Nothing inside an ignored directory can be included again
+	for i := 1; i < len(parts); i++ {
+		if im.match(strings.Join(parts[:i], "/"), true) {
+			return true
+		}
+	}
+	return im.match(relPath, isDir)
+}
+
+func (im *IgnoreMatcher) match(path string, isDir bool) bool {
+	ignored := false
+	for _, rule := range im.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.re.MatchString(path) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+func compileIgnorePattern(pattern string) (ignoreRule, bool, error) {
+	rule := ignoreRule{}
+	pattern = strings.TrimRight(pattern, " \t\r")
+	if pattern == "" || strings.HasPrefix(pattern, "#") {
+		return rule, false, nil
+	}
+
+	if strings.HasPrefix(pattern, "!") {
+		rule.negate = true
+		pattern = pattern[1:]
+	} else if strings.HasPrefix(pattern, `\!`) || strings.HasPrefix(pattern, `\#`) {
+		pattern = pattern[1:]
+	}
+
+	if strings.HasSuffix(pattern, "/") {
+		rule.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+
+	isAnchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+	if pattern == "" {
+		return rule, false, nil
+	}
+
+	body, err := globToRegexp(pattern)
+	if err != nil {
+		return rule, false, err
+	}
+	if !isAnchored {
+		body = "(?:.*/)?" + body
+	}
+
+	rule.re, err = regexp.Compile("^" + body + "$")
+	if err != nil {
+		return rule, false, filepath.ErrBadPattern
+	}
+	return rule, true, nil
+}
+
+func globToRegexp(pattern string) (string, error) {
+	sb := strings.Builder{}
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '*':
+			if !strings.HasPrefix(pattern[i:], "**") {
+				sb.WriteString("[^/]*")
+				continue
+			}
+			i++
+			if strings.HasPrefix(pattern[i+1:], "/") {
+				// Matches zero or more folders
+				sb.WriteString("(?:.*/)?")
+				i++
+				continue
+			}
+			sb.WriteString(".*")
+
+		case '?':
+			sb.WriteString("[^/]")
+
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end == -1 {
+				return "", filepath.ErrBadPattern
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				c = pattern[i]
+			}
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,96 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIgnoreMatcher(t *testing.T) {
+	md := []struct {
+		should   string
+		patterns []string
+		path     string
+		isDir    bool
+		ignored  bool
+	}{
+		{"match names at any depth", []string{"*_thumb.jpg"}, "a/b/cat_thumb.jpg", false, true},
+		{"not match other names", []string{"*_thumb.jpg"}, "a/b/cat.jpg", false, false},
+		{"skip comments", []string{"# *.jpg"}, "cat.jpg", false, false},
+		{"anchor patterns with a slash", []string{"/cat.jpg"}, "a/cat.jpg", false, false},
+		{"match anchored patterns at the root", []string{"/cat.jpg"}, "cat.jpg", false, true},
+		{"not let * match folders", []string{"a/*.jpg"}, "a/b/cat.jpg", false, false},
+		{"let ** match folders", []string{"a/**/*.jpg"}, "a/b/c/cat.jpg", false, true},
+		{"let ** match no folders", []string{"**/cat.jpg"}, "cat.jpg", false, true},
+		{"only match directories with a slash", []string{"wip/"}, "wip", false, false},
+		{"ignore everything in ignored directories", []string{"wip/"}, "wip/cat.jpg", false, true},
+		{"match character classes", []string{"cat[0-9].jpg"}, "cat7.jpg", false, true},
+		{"match negated character classes", []string{"cat[!0-9].jpg"}, "cat7.jpg", false, false},
+		{"re-include with !", []string{"*.jpg", "!keep.jpg"}, "keep.jpg", false, false},
+		{"let the last pattern win", []string{"!keep.jpg", "*.jpg"}, "keep.jpg", false, true},
+		{"not re-include inside ignored directories", []string{"wip/", "!wip/keep.jpg"}, "wip/keep.jpg", false, true},
+		{"match escaped characters", []string{`\!important.jpg`}, "!important.jpg", false, true},
+	}
+
+	for _, d := range md {
+		t.Run("should "+d.should, func(t *testing.T) {
+			t.Parallel()
+			im, err := NewIgnoreMatcher(d.patterns)
+			require.NoError(t, err)
+			assert.Equal(t, d.ignored, im.Match(d.path, d.isDir))
+		})
+	}
+
+	t.Run("should error on unclosed character classes", func(t *testing.T) {
+		t.Parallel()
+		_, err := NewIgnoreMatcher([]string{"cat[0-9.jpg"})
+		assert.ErrorIs(t, err, filepath.ErrBadPattern)
+	})
+}
+
+func TestMapperFilters(t *testing.T) {
+	const hashPrefix = "0x@"
+
+	t.Run("should skip files in the ignore file and excludes", func(t *testing.T) {
+		t.Parallel()
+		dir := writeTempFiles(
+			t,
+			[]string{"t1.jpg", "t1_thumb.jpg", "t2.png", "t3.png", IgnoreFileName},
+			[]string{"1", "2", "3", "4", "# thumbnails\n*_thumb.jpg\n"},
+		)
+		iMap, err := MapImagesWithConfig(MapperConfig{
+			Dir:    dir,
+			Prefix: hashPrefix,
+			Ignore: []string{"t3.*"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, ImageMap{"t1.jpg": NotCached, "t2.png": NotCached}, iMap)
+	})
+
+	t.Run("should skip files outside of the size and time ranges", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		dir := writeTempFiles(
+			t,
+			[]string{"small.jpg", "medium.jpg", "large.jpg", "old.jpg"},
+			[]string{"1", "12345", "1234567890", "12345"},
+		)
+		old, err := time.Parse(time.DateOnly, "2020-06-01")
+		require.NoError(t, err)
+		require.NoError(t, os.Chtimes(filepath.Join(dir, "old.jpg"), old, old))
+
+		after := FilterTime{}
+		require.NoError(t, after.UnmarshalText([]byte("2021-01-01")))
+		iMap, err := MapImagesWithConfig(MapperConfig{
+			Dir:    dir,
+			Prefix: hashPrefix,
+			Filter: FileFilter{MinSize: 2, MaxSize: 9, ModifiedAfter: after},
+		})
+		require.NoError(t, err)
+		a.Equal(ImageMap{"medium.jpg": NotCached}, iMap)
+	})
+}
@@ -0,0 +1,59 @@
+package lib
+
+import (
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+/*
+LoadImage decodes the image at path and shrinks it to fit within maxWidth
+and maxHeight. Only formats supported by the standard library can be
+decoded (PNG, JPEG and GIF).
+*/
+func LoadImage(path string, maxWidth, maxHeight int) (image.Image, error) {
+	return loadImage(OSFS{}, path, maxWidth, maxHeight)
+}
+
+func loadImage(fsys FS, path string, maxWidth, maxHeight int) (image.Image, error) {
+	file, err := fsys.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, err
+	}
+	return ScaleImage(img, maxWidth, maxHeight), nil
+}
+
+/*
+ScaleImage shrinks the image so that it fits within maxWidth and
+maxHeight while keeping its aspect ratio, using nearest-neighbor
+sampling. Images that already fit are returned as-is.
+*/
+func ScaleImage(img image.Image, maxWidth, maxHeight int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= maxWidth && h <= maxHeight {
+		return img
+	}
+
+	newW, newH := maxWidth, h*maxWidth/w
+	if newH > maxHeight {
+		newW, newH = w*maxHeight/h, maxHeight
+	}
+	newW, newH = max(newW, 1), max(newH, 1)
+
+	dst := image.NewRGBA(image.Rect(0, 0, newW, newH))
+	for y := 0; y < newH; y++ {
+		srcY := b.Min.Y + y*h/newH
+		for x := 0; x < newW; x++ {
+			dst.Set(x, y, img.At(b.Min.X+x*w/newW, srcY))
+		}
+	}
+	return dst
+}
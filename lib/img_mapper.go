@@ -1,7 +1,6 @@
 package lib
 
 import (
-	"os"
 	fPath "path/filepath"
 	"strings"
 )
@@ -27,17 +26,100 @@ var imageExtensions = ImageExtMap{
 	".gif":  ExtEnabled,
 	".heic": ExtEnabled,
 	".heif": ExtEnabled,
+	".ico":  ExtEnabled,
+	".jfif": ExtEnabled,
 	".jpg":  ExtEnabled,
 	".jpeg": ExtEnabled,
+	".jxl":  ExtEnabled,
 	".png":  ExtEnabled,
+	".psd":  ExtEnabled,
 	".svg":  ExtEnabled,
 	".tif":  ExtEnabled,
 	".tiff": ExtEnabled,
 	".webp": ExtEnabled,
+	// Camera RAW formats
+	".arw": ExtEnabled,
+	".cr2": ExtEnabled,
+	".cr3": ExtEnabled,
+	".dng": ExtEnabled,
+	".nef": ExtEnabled,
+	".orf": ExtEnabled,
+	".raf": ExtEnabled,
+	".rw2": ExtEnabled,
+}
+
+/*
+Videos are only mapped when asked for, since they're usually far bigger
+than images. They're treated exactly like images otherwise.
+*/
+var videoExtensions = ImageExtMap{
+	".avi":  ExtEnabled,
+	".gifv": ExtEnabled,
+	".m4v":  ExtEnabled,
+	".mkv":  ExtEnabled,
+	".mov":  ExtEnabled,
+	".mp4":  ExtEnabled,
+	".webm": ExtEnabled,
+}
+
+// IsVideo reports whether the file has one of the video extensions.
+func IsVideo(fileName string) bool {
+	return videoExtensions[strings.ToLower(fPath.Ext(fileName))] == ExtEnabled
+}
+
+// ImageExtensions returns a copy of the built-in image extensions.
+func ImageExtensions() ImageExtMap {
+	extMap := make(ImageExtMap, len(imageExtensions))
+	for ext, state := range imageExtensions {
+		extMap[ext] = state
+	}
+	return extMap
+}
+
+type MapperConfig struct {
+	Dir    string
+	Prefix string
+	// Defaults to the built-in image extensions
+	Extensions ImageExtMap
+	// Gitignore-style patterns of files to skip, which are added to
+	// the patterns in the .hashimgignore file inside Dir
+	Ignore []string
+	// Skips files by size and modification time
+	Filter FileFilter
+	// Maps videos along with images, unless their extension is
+	// disabled in Extensions
+	IncludeVideos bool
+	// Maps the images inside zip and tar archives too, which are
+	// hashed, but never changed
+	IncludeArchives bool
+	// Called with every archive that can't be read. It's skipped, so a
+	// single corrupt archive doesn't stop the rest from being mapped.
+	OnSkip func(path string, err error)
+	// Defaults to the OS filesystem
+	FS FS
 }
 
 func MapImages(dir, hashPrefix string) (ImageMap, error) {
-	dirEntries, err := os.ReadDir(dir)
+	return MapImagesWithConfig(MapperConfig{Dir: dir, Prefix: hashPrefix})
+}
+
+func MapImagesWithConfig(cfg MapperConfig) (ImageMap, error) {
+	extensions := cfg.Extensions
+	if extensions == nil {
+		extensions = imageExtensions
+	}
+
+	fsys := orOS(cfg.FS)
+	filePatterns, err := readIgnoreFile(fsys, cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	ignore, err := NewIgnoreMatcher(append(filePatterns, cfg.Ignore...))
+	if err != nil {
+		return nil, err
+	}
+
+	dirEntries, err := fsys.ReadDir(cfg.Dir)
 	if err != nil {
 		return nil, err
 	}
@@ -46,16 +128,46 @@ func MapImages(dir, hashPrefix string) (ImageMap, error) {
 		return nil, ErrNoImages
 	}
 
+	isMedia := func(fileName string) bool {
+		// Some extensions might be uppercase
+		imgExt := strings.ToLower(fPath.Ext(fileName))
+		state, isKnown := extensions[imgExt]
+		return state == ExtEnabled ||
+			(cfg.IncludeVideos && !isKnown && videoExtensions[imgExt] == ExtEnabled)
+	}
+
 	iMap := ImageMap{}
 	for _, entry := range dirEntries {
 		fileName := entry.Name()
-		// Some extensions might be uppercase
-		imgExt := strings.ToLower(fPath.Ext(fileName))
-		if entry.IsDir() || imageExtensions[imgExt] == ExtDisabled {
+		if cfg.IncludeArchives && !entry.IsDir() && IsArchive(fileName) {
+			if ignore.Match(fileName, true) {
+				continue
+			}
+			if err := mapArchive(fsys, cfg, fileName, isMedia, ignore, iMap); err != nil && cfg.OnSkip != nil {
+				cfg.OnSkip(fPath.Join(cfg.Dir, fileName), err)
+			}
 			continue
 		}
 
-		if strings.HasPrefix(fileName, hashPrefix) {
+		if entry.IsDir() || !isMedia(fileName) {
+			continue
+		}
+
+		if ignore.Match(fileName, false) {
+			continue
+		}
+
+		if !cfg.Filter.IsZero() {
+			info, err := entry.Info()
+			if err != nil {
+				return nil, err
+			}
+			if !cfg.Filter.Allows(info) {
+				continue
+			}
+		}
+
+		if strings.HasPrefix(fileName, cfg.Prefix) {
 			iMap[fileName] = Cached
 		} else {
 			iMap[fileName] = NotCached
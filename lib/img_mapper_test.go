@@ -1,10 +1,14 @@
 package lib
 
 import (
+	"fmt"
+	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 type MockMapperTest struct {
@@ -35,7 +39,7 @@ func TestImageMapper(t *testing.T) {
 		t.Parallel()
 		a := assert.New(t)
 		dir := t.TempDir()
-		err := writeFiles(dir, []string{"test1.txt", "test2.mp3"}, []string{"test1", "test2"})
+		err := writeFiles(OSFS{}, dir, []string{"test1.txt", "test2.mp3"}, []string{"test1", "test2"})
 		a.NoError(err)
 		_, err = MapImages(filepath.Join(dir), hashPrefix)
 		a.ErrorIs(err, ErrNoImages)
@@ -82,6 +86,23 @@ func TestImageMapper(t *testing.T) {
 				"test4.jpg":                       NotCached,
 			},
 		},
+		{
+			should: "map camera RAW images",
+			files: []string{
+				"DSC0001.ARW",
+				"IMG_0002.CR3",
+				"P1000003.RW2",
+				"photo.jfif",
+				"sidecar.xmp",
+			},
+			fileContent: []string{"test1", "test2", "test3", "test4", "test5"},
+			expectMap: ImageMap{
+				"DSC0001.ARW":  NotCached,
+				"IMG_0002.CR3": NotCached,
+				"P1000003.RW2": NotCached,
+				"photo.jfif":   NotCached,
+			},
+		},
 	}
 
 	for _, test := range mockTable {
@@ -89,7 +110,7 @@ func TestImageMapper(t *testing.T) {
 			t.Parallel()
 			a := assert.New(t)
 			dir := t.TempDir()
-			err := writeFiles(dir, test.files, test.fileContent)
+			err := writeFiles(OSFS{}, dir, test.files, test.fileContent)
 			a.NoError(err)
 			iMap, err := MapImages(dir, hashPrefix)
 			a.NoError(err)
@@ -97,3 +118,73 @@ func TestImageMapper(t *testing.T) {
 		})
 	}
 }
+
+func TestVideoMapping(t *testing.T) {
+	const hashPrefix = "0x@"
+	files := []string{"clip.MP4", "clip.webm", "loop.gifv", "t1.png"}
+	content := []string{"1", "1", "2", "1"}
+
+	t.Run("should skip videos by default", func(t *testing.T) {
+		t.Parallel()
+		dir := writeTempFiles(t, files, content)
+		iMap, err := MapImages(dir, hashPrefix)
+		require.NoError(t, err)
+		assert.Equal(t, ImageMap{"t1.png": NotCached}, iMap)
+	})
+
+	t.Run("should map videos unless disabled", func(t *testing.T) {
+		t.Parallel()
+		dir := writeTempFiles(t, files, content)
+		extensions := ImageExtensions()
+		extensions[".webm"] = ExtDisabled
+		iMap, err := MapImagesWithConfig(MapperConfig{
+			Dir:           dir,
+			Prefix:        hashPrefix,
+			Extensions:    extensions,
+			IncludeVideos: true,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, ImageMap{
+			"clip.MP4":  NotCached,
+			"loop.gifv": NotCached,
+			"t1.png":    NotCached,
+		}, iMap)
+	})
+
+	t.Run("should count videos separately", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		dir := writeTempFiles(t, files, content)
+		// The image is the oldest, so both videos are its dupes
+		old := time.Now().Add(-time.Hour)
+		require.NoError(t, os.Chtimes(filepath.Join(dir, "t1.png"), old, old))
+		iMap, err := MapImagesWithConfig(MapperConfig{
+			Dir:           dir,
+			Prefix:        hashPrefix,
+			IncludeVideos: true,
+		})
+		require.NoError(t, err)
+		imgProcessor := NewImageProcessor(ImageProcessorConfig{
+			WorkingDir:   dir,
+			Prefix:       hashPrefix,
+			ImageMap:     iMap,
+			HashLength:   hashLength,
+			KeeperPolicy: KeepOldest,
+		})
+		require.NoError(t, imgProcessor.ProcessImages(false))
+		require.NoError(t, imgProcessor.UpdateImages())
+
+		status := imgProcessor.Status
+		a.Equal(int32(4), status.TotalImageCount)
+		a.Equal(int32(3), status.TotalVideoCount)
+		a.Equal(int32(2), status.DupeImageCount)
+		a.Equal(int32(2), status.DupeVideoCount)
+
+		fileNames, err := readDir(OSFS{}, dir)
+		require.NoError(t, err)
+		a.ElementsMatch([]string{
+			fmt.Sprintf("0x@%s.png", calcSha256("1")),
+			fmt.Sprintf("0x@%s.gifv", calcSha256("2")),
+		}, fileNames)
+	})
+}
@@ -1,12 +1,18 @@
 package lib
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/jaeiya/hashimg/lib/models"
@@ -15,6 +21,12 @@ import (
 
 var mux = sync.Mutex{}
 
+const (
+	// Spinning disks can only read one place at a time
+	hddReaders = 1
+	chunkSize  = 256 * 1024
+)
+
 type ImageProcessor struct {
 	Status           *models.ProcessStatus
 	WorkingDir       string
@@ -25,18 +37,57 @@ type ImageProcessor struct {
 	NovelDupePaths   []string
 	hashPrefix       string
 	hashLength       int
+	algorithm        HashAlgorithm
+	keeperPolicy     KeeperPolicy
 	imageMap         ImageMap
 	ProcessTime      time.Duration
 	processedImages  *ProcessedImages
+	// Only available when ImageProcessorConfig.CollectReport is set
+	Report           *Report
+	collectReport    bool
+	reportThumbnails bool
+	// Dupes and groups the user chose to keep during review
+	keptDupes  map[string]bool
+	keptGroups map[string]bool
+	readers    int
+	// Dupes are moved here instead of being deleted, when set
+	disposalFolder string
+	// Images that weren't renamed, because their new name was taken
+	Conflicts []RenameConflict
+	// Images inside archives, sorted by path
+	Archived []ArchivedImage
+	fs       FS
+	log      *slog.Logger
+}
+
+type RenameConflict struct {
+	Path string
+	// The hash name that already belongs to another file
+	Target string
 }
 
 type ImageProcessorConfig struct {
 	Prefix           string
 	HashLength       int
+	Algorithm        HashAlgorithm
+	KeeperPolicy     KeeperPolicy
 	WorkingDir       string
 	ImageMap         ImageMap
 	DupeReviewFolder string
 	OpenReviewFolder bool
+	// Builds a report of all duplicates before they are updated
+	CollectReport bool
+	// Embeds thumbnails into the report, which is slower
+	ReportThumbnails bool
+	// Overrides how many files are read at the same time, which
+	// otherwise depends on the drive type
+	Readers int
+	// Moves dupes into this folder instead of deleting them
+	DisposalFolder string
+	// Defaults to the OS filesystem
+	FS FS
+	// Logs every file operation, when set
+	Logger *slog.Logger
 }
 
 type ProcessedImages struct {
@@ -48,26 +99,42 @@ func NewImageProcessor(cfg ImageProcessorConfig) *ImageProcessor {
 	if cfg.DupeReviewFolder == "" {
 		cfg.DupeReviewFolder = "__dupes"
 	}
+	disposalFolder := ""
+	if cfg.DisposalFolder != "" {
+		disposalFolder = filepath.Join(cfg.WorkingDir, cfg.DisposalFolder)
+	}
 	return &ImageProcessor{
 		Status:           &models.ProcessStatus{},
 		WorkingDir:       cfg.WorkingDir,
 		dupeReviewFolder: filepath.Join(cfg.WorkingDir, cfg.DupeReviewFolder),
 		hashPrefix:       cfg.Prefix,
 		hashLength:       cfg.HashLength,
+		algorithm:        cfg.Algorithm,
+		keeperPolicy:     cfg.KeeperPolicy,
+		disposalFolder:   disposalFolder,
 		imageMap:         cfg.ImageMap,
 		NovelDupePaths:   []string{},
 		OpenReviewFolder: cfg.OpenReviewFolder,
+		collectReport:    cfg.CollectReport,
+		reportThumbnails: cfg.ReportThumbnails,
+		keptDupes:        map[string]bool{},
+		keptGroups:       map[string]bool{},
+		readers:          cfg.Readers,
+		fs:               orOS(cfg.FS),
+		log:              orDiscard(cfg.Logger),
 	}
 }
 
 /*
 ProcessImages calculates the hash of all images in the image map and
-separates out the duplicates from the new images.
+separates out the duplicates from the new images. Images on spinning
+disks (isHDD) are read sequentially, to avoid seeking.
 */
-func (ip *ImageProcessor) ProcessImages(useBuffer bool) error {
+func (ip *ImageProcessor) ProcessImages(isHDD bool) error {
 	timeStart := time.Now()
 	defer func() {
 		ip.ProcessTime = time.Since(timeStart)
+		ip.Status.TotalTime = ip.ProcessTime
 		ip.Status.ProcessingComplete = true
 	}()
 
@@ -75,29 +142,69 @@ func (ip *ImageProcessor) ProcessImages(useBuffer bool) error {
 		return ErrNoImages
 	}
 
-	ip.Status.TotalImageCount = int32(len(ip.imageMap))
-	ip.Status.MaxHashProgress = ip.Status.TotalImageCount
+	for fileName := range ip.imageMap {
+		if _, _, isArchived := splitArchived(fileName); isArchived {
+			ip.Status.ArchivedImageCount += 1
+			continue
+		}
+		ip.Status.TotalImageCount += 1
+		if IsVideo(fileName) {
+			ip.Status.TotalVideoCount += 1
+		}
+	}
+	atomic.StoreInt32(&ip.Status.MaxHashProgress, int32(len(ip.imageMap)))
+
+	readers, ordered := ip.readStrategy(isHDD)
+	ip.Status.Readers = int32(readers)
+	ip.Status.ChunkSize = chunkSize
+
+	ip.log.Info(
+		"scan started",
+		"dir", ip.WorkingDir,
+		"images", ip.Status.TotalImageCount,
+		"archived", ip.Status.ArchivedImageCount,
+		"algorithm", ip.algorithm,
+		"readers", readers,
+	)
 
-	bufferSize, err := ip.calcBufferSize(useBuffer)
+	fileNames, err := ip.planReads(ordered)
 	if err != nil {
-		ip.Status.HashErr = err
+		ip.failScan(err)
 		return err
 	}
 
-	ip.Status.BufferSize = bufferSize
-
-	hashResult, err := ip.calcImageHashes(bufferSize)
+	hashResult, err := ip.calcImageHashes(fileNames, readers)
 	if err != nil {
-		ip.Status.HashErr = err
+		ip.failScan(err)
 		return err
 	}
 
+	archived := []ArchivedImage{}
+	if ip.Status.ArchivedImageCount > 0 {
+		if archived, err = ip.hashArchives(readers); err != nil {
+			ip.failScan(err)
+			return err
+		}
+	}
+
 	start := time.Now()
 	defer func() { ip.Status.FilterTook = time.Since(start) }()
 
 	newImagesByHash := map[string]HashInfo{}
 	dupeImagesByHash := map[string][]HashInfo{}
 
+	// Cached images sharing a hash are dupes of each other
+	for hash, oldInfos := range hashResult.oldHashesInfo {
+		if len(oldInfos) < 2 {
+			continue
+		}
+		slices.SortFunc(oldInfos, func(a, b HashInfo) int {
+			return strings.Compare(a.path, b.path)
+		})
+		oldInfos[0].isNovel = true
+		dupeImagesByHash[hash] = oldInfos
+	}
+
 	for _, hashInfo := range hashResult.newHashesInfo {
 		if _, isDupe := dupeImagesByHash[hashInfo.hash]; isDupe {
 			dupeImagesByHash[hashInfo.hash] = append(dupeImagesByHash[hashInfo.hash], hashInfo)
@@ -105,7 +212,8 @@ func (ip *ImageProcessor) ProcessImages(useBuffer bool) error {
 			continue
 		}
 
-		if oldInfo, isDupe := hashResult.oldHashesInfo[hashInfo.hash]; isDupe {
+		if oldInfos, isDupe := hashResult.oldHashesInfo[hashInfo.hash]; isDupe {
+			oldInfo := oldInfos[0]
 			oldInfo.isNovel = true
 			dupeImagesByHash[oldInfo.hash] = []HashInfo{oldInfo, hashInfo}
 			delete(newImagesByHash, hashInfo.hash)
@@ -125,16 +233,45 @@ func (ip *ImageProcessor) ProcessImages(useBuffer bool) error {
 	ip.HasDupes = len(dupeImagesByHash) > 0
 
 	ip.processedImages = &ProcessedImages{newImagesByHash, dupeImagesByHash}
+
+	if err := ip.applyKeeperPolicy(); err != nil {
+		ip.failScan(err)
+		return err
+	}
+
+	ip.matchArchived(archived, hashResult.oldHashesInfo)
+
+	if ip.collectReport {
+		ip.Report, err = ip.BuildReport(ip.reportThumbnails)
+		if err != nil {
+			ip.failScan(err)
+			return err
+		}
+	}
+
+	ip.log.Info(
+		"scan finished",
+		"dir", ip.WorkingDir,
+		"new", len(newImagesByHash),
+		"dupe_groups", len(dupeImagesByHash),
+		"cached", ip.Status.CachedImageCount,
+		"took", time.Since(timeStart),
+	)
 	return nil
 }
 
+func (ip *ImageProcessor) failScan(err error) {
+	ip.Status.HashErr = err
+	ip.log.Error("scan failed", "dir", ip.WorkingDir, "err", err)
+}
+
 /*
 ProcessImagesForReview processes the images, but also moves duplicate
 images to a temporary "dupe review folder," for the user to review.
 The folder is automatically opened after the dupes are moved.
 */
-func (ip *ImageProcessor) ProcessImagesForReview(useBuffer bool) error {
-	err := ip.ProcessImages(useBuffer)
+func (ip *ImageProcessor) ProcessImagesForReview(isHDD bool) error {
+	err := ip.ProcessImages(isHDD)
 	if err != nil {
 		return err
 	}
@@ -146,37 +283,46 @@ func (ip *ImageProcessor) ProcessImagesForReview(useBuffer bool) error {
 	ip.isReviewProcess = true
 	pi := ip.processedImages
 
-	err = os.MkdirAll(ip.dupeReviewFolder, 0o755)
+	err = ip.fs.MkdirAll(ip.dupeReviewFolder, 0o755)
 	if err != nil {
 		return err
 	}
 
-	cachedImageCount := 0
 	for _, dupes := range pi.DupeImagesByHash {
 		for i, dupe := range dupes {
 			ext := filepath.Ext(dupe.path)
 			reviewFileName := fmt.Sprintf("%s_%d%s", dupe.hash, i+1, ext)
-			err = os.Rename(
-				dupe.path,
-				filepath.Join(ip.dupeReviewFolder, reviewFileName),
-			)
+			dupes[i].reviewPath = filepath.Join(ip.dupeReviewFolder, reviewFileName)
+		}
+	}
+
+	// The manifest must exist before anything is moved, otherwise
+	// a crash would lose the original file names.
+	if err := ip.saveReviewManifest(); err != nil {
+		return err
+	}
+
+	cachedImageCount := 0
+	for _, dupes := range pi.DupeImagesByHash {
+		for _, dupe := range dupes {
+			reviewFileName := filepath.Base(dupe.reviewPath)
+			err = ip.fs.Rename(dupe.path, dupe.reviewPath)
 			if err != nil {
+				ip.log.Error("move to review failed", "path", dupe.path, "hash", dupe.hash, "err", err)
 				return err
 			}
+			ip.log.Info("moved to review", "path", dupe.path, "target", dupe.reviewPath, "hash", dupe.hash)
 			// cached images are not "new"
 			if dupe.cached {
 				cachedImageCount += 1
 			}
 			if dupe.isNovel {
-				ip.NovelDupePaths = append(
-					ip.NovelDupePaths,
-					filepath.Join(ip.dupeReviewFolder, reviewFileName),
-				)
+				ip.NovelDupePaths = append(ip.NovelDupePaths, dupe.reviewPath)
 				dupe.path = filepath.Join(ip.WorkingDir, reviewFileName)
 				pi.NewImagesByHash[dupe.hash] = dupe
 				continue
 			}
-			ip.Status.DupeImageCount += 1
+			ip.countDupe(dupe)
 		}
 	}
 
@@ -190,22 +336,77 @@ func (ip *ImageProcessor) ProcessImagesForReview(useBuffer bool) error {
 	return nil
 }
 
+/*
+UndoReview moves every image in the dupe review folder back to where it
+was found, then deletes the folder. Nothing is renamed or deleted.
+*/
+func (ip *ImageProcessor) UndoReview() error {
+	if !ip.isReviewProcess {
+		return nil
+	}
+	return rollbackReview(ip.fs, ip.log, ip.dupeReviewFolder)
+}
+
 /*
 RestoreFromReview restores all novel dupes back to the working
-directory, then deletes the dupe review folder and all its
-contents (the remaining dupes).
+directory, along with any dupes the user decided to keep, then
+deletes the dupe review folder and all its contents (the
+remaining dupes).
 */
 func (ip *ImageProcessor) RestoreFromReview() error {
-	for _, path := range ip.NovelDupePaths {
-		err := os.Rename(
-			path,
-			filepath.Join(ip.WorkingDir, filepath.Base(path)),
-		)
-		if err != nil {
-			return err
+	pi := ip.processedImages
+	if pi == nil {
+		return ErrNotProcessed
+	}
+
+	ip.Status.DupeImageCount = 0
+	ip.Status.DupeVideoCount = 0
+	ip.Status.KeptDupeCount = 0
+
+	for _, dupes := range pi.DupeImagesByHash {
+		for _, dupe := range dupes {
+			switch {
+			case dupe.isNovel:
+				target := filepath.Join(ip.WorkingDir, filepath.Base(dupe.reviewPath))
+				if err := ip.restore(dupe, target); err != nil {
+					return err
+				}
+
+			case ip.isKept(dupe):
+				// Kept dupes are left exactly as they were found
+				if err := ip.restore(dupe, dupe.path); err != nil {
+					return err
+				}
+				ip.Status.KeptDupeCount += 1
+
+			default:
+				ip.countDupe(dupe)
+				if ip.disposalFolder != "" {
+					if err := ip.disposeDupe(dupe, dupe.reviewPath); err != nil {
+						return err
+					}
+					continue
+				}
+				// Deleted along with the folder
+				if info, err := ip.fs.Stat(dupe.reviewPath); err == nil {
+					ip.Status.AddReclaimedBytes(info.Size())
+				}
+				ip.log.Info("deleted", "path", dupe.reviewPath, "hash", dupe.hash)
+			}
 		}
 	}
-	return os.RemoveAll(ip.dupeReviewFolder)
+
+	return ip.fs.RemoveAll(ip.dupeReviewFolder)
+}
+
+// restore moves a dupe out of the review folder
+func (ip *ImageProcessor) restore(dupe HashInfo, target string) error {
+	if err := ip.fs.Rename(dupe.reviewPath, target); err != nil {
+		ip.log.Error("restore failed", "path", dupe.reviewPath, "hash", dupe.hash, "err", err)
+		return err
+	}
+	ip.log.Info("restored", "path", dupe.reviewPath, "target", target, "hash", dupe.hash)
+	return nil
 }
 
 /*
@@ -217,69 +418,100 @@ func (ip *ImageProcessor) UpdateImages() error {
 	timeStart := time.Now()
 	defer func() {
 		ip.ProcessTime = ip.ProcessTime + time.Since(timeStart)
+		ip.Status.TotalTime = ip.ProcessTime
 		ip.Status.UpdatingComplete = true
 	}()
 
 	if ip.isReviewProcess {
 		if err := ip.renameOnly(); err != nil {
-			ip.Status.UpdateErr = err
+			ip.failUpdate(err)
 			return err
 		}
 		return nil
 	}
 
 	if err := ip.deleteAndRename(); err != nil {
-		ip.Status.UpdateErr = err
+		ip.failUpdate(err)
 		return err
 	}
 
 	return nil
 }
 
-func (ip *ImageProcessor) calcBufferSize(useBuffer bool) (int64, error) {
-	if !useBuffer {
-		return 0, nil
+func (ip *ImageProcessor) failUpdate(err error) {
+	ip.Status.UpdateErr = err
+	ip.log.Error("update failed", "dir", ip.WorkingDir, "err", err)
+}
+
+/*
+readStrategy decides how many files are read at the same time. Spinning
+disks lose most of their throughput to seeking, so they're read by a
+single reader in inode order, which roughly follows where the files are
+on the disk. Flash storage is fastest with many reads in flight.
+*/
+func (ip *ImageProcessor) readStrategy(isHDD bool) (readers int, ordered bool) {
+	if ip.readers > 0 {
+		return ip.readers, isHDD
+	}
+	if isHDD {
+		return hddReaders, true
 	}
+	return max(runtime.NumCPU()*2, 4), false
+}
 
+/*
+planReads returns the file names in the order they should be hashed,
+and counts the bytes that need to be read. Cached files come first,
+since they're never read. When ordered, the rest are sorted by inode.
+*/
+func (ip *ImageProcessor) planReads(ordered bool) ([]string, error) {
 	start := time.Now()
 	defer func() { ip.Status.AnalyzeTook = time.Since(start) }()
 
-	files, err := os.ReadDir(ip.WorkingDir)
-	if err != nil {
-		return 0, err
-	}
-
-	var totalSize int64
-	var fileCount int64
-	for _, entry := range files {
-		if entry.IsDir() {
+	cached := []string{}
+	uncached := []string{}
+	for fileName, cs := range ip.imageMap {
+		// Archived images are hashed while streaming through their archive
+		if _, _, isArchived := splitArchived(fileName); isArchived {
 			continue
 		}
-		ext := filepath.Ext(entry.Name())
-		if _, isImg := imageExtensions[ext]; !isImg {
+		if cs == Cached {
+			cached = append(cached, fileName)
 			continue
 		}
-		// We only need the size of images actually being hashed
-		if ip.imageMap[entry.Name()] == Cached {
-			continue
-		}
-		info, err := entry.Info()
+		uncached = append(uncached, fileName)
+	}
+
+	keys := make(map[string]uint64, len(uncached))
+	hasKeys := true
+	for _, fileName := range uncached {
+		info, err := ip.fs.Stat(filepath.Join(ip.WorkingDir, fileName))
 		if err != nil {
-			return 0, err
+			return nil, err
+		}
+		atomic.AddInt64(&ip.Status.TotalHashBytes, info.Size())
+		if !ordered {
+			continue
 		}
-		fileCount += 1
-		totalSize += info.Size()
+		key, ok := utils.ReadOrderKey(info)
+		// Without inodes the directory order is as good as any
+		hasKeys = hasKeys && ok
+		keys[fileName] = key
 	}
 
-	if fileCount == 0 {
-		return fileCount, nil
+	if ordered && hasKeys {
+		sort.Slice(uncached, func(i, j int) bool {
+			return keys[uncached[i]] < keys[uncached[j]]
+		})
 	}
 
-	// We want to round up here
-	return (totalSize + fileCount - 1) / fileCount, nil
+	return append(cached, uncached...), nil
 }
 
-func (ip *ImageProcessor) calcImageHashes(bufferSize int64) (HashResult, error) {
+func (ip *ImageProcessor) calcImageHashes(
+	fileNames []string,
+	readers int,
+) (HashResult, error) {
 	start := time.Now()
 	defer func() { ip.Status.HashingTook = time.Since(start) }()
 
@@ -287,20 +519,24 @@ func (ip *ImageProcessor) calcImageHashes(bufferSize int64) (HashResult, error)
 
 	hasher, err := NewHasher(HasherConfig{
 		Length:     ip.hashLength,
-		Threads:    runtime.NumCPU(),
-		QueueSize:  max(len(ip.imageMap), 10),
+		Algorithm:  ip.algorithm,
+		Threads:    poolSize(),
+		Readers:    readers,
+		ChunkSize:  chunkSize,
+		QueueSize:  max(len(fileNames), 10),
 		HashResult: &hr,
 		Prefix:     ip.hashPrefix,
-		BufferSize: bufferSize,
+		OnRead:     func(n int) { ip.Status.AddHashedBytes(int64(n)) },
+		FS:         ip.fs,
 	})
 	if err != nil {
 		return hr, err
 	}
 
-	for fileName, cacheStatus := range ip.imageMap {
+	for _, fileName := range fileNames {
 		hasher.Hash(
 			fileName,
-			cacheStatus,
+			ip.imageMap[fileName],
 			filepath.Join(ip.WorkingDir, fileName),
 			func(cs CacheStatus) {
 				if cs == Cached {
@@ -317,11 +553,14 @@ func (ip *ImageProcessor) calcImageHashes(bufferSize int64) (HashResult, error)
 		if r.err != nil {
 			return HashResult{}, r.err
 		}
+		ip.log.Info("hashed", "path", r.path, "hash", r.hash)
 	}
 
-	for _, r := range hr.oldHashesInfo {
-		if r.err != nil {
-			return HashResult{}, r.err
+	for _, olds := range hr.oldHashesInfo {
+		for _, r := range olds {
+			if r.err != nil {
+				return HashResult{}, r.err
+			}
 		}
 	}
 
@@ -350,25 +589,30 @@ func (ip *ImageProcessor) deleteAndRename() error {
 	}
 
 	for _, dupes := range dupeImages {
-		for i, dupe := range dupes {
-			if dupe.cached {
+		for _, dupe := range dupes {
+			if dupe.isNovel {
+				// Cached keepers already have their hash name
+				if !dupe.cached {
+					newImages[dupe.hash] = dupe
+				}
 				continue
 			}
-			if dupe.isNovel {
-				// All novel images are at index 0
-				dupeImages[dupe.hash] = dupes[i+1:]
-				newImages[dupe.hash] = dupe
+			if ip.isKept(dupe) {
+				ip.Status.KeptDupeCount += 1
 				continue
 			}
-			ip.Status.DupeImageCount += 1
+			ip.countDupe(dupe)
 		}
 	}
 
 	ip.Status.NewImageCount = int32(len(newImages))
-	ip.Status.MaxUpdateProgress = ip.Status.DupeImageCount + int32(len(newImages))
+	atomic.StoreInt32(
+		&ip.Status.MaxUpdateProgress,
+		ip.Status.DupeImageCount+int32(len(newImages)),
+	)
 
 	tp, err := utils.NewThreadPool(
-		runtime.NumCPU(),
+		poolSize(),
 		max(len(dupeImages)+len(newImages), 10),
 		false,
 	)
@@ -380,11 +624,11 @@ func (ip *ImageProcessor) deleteAndRename() error {
 
 	for _, dupes := range dupeImages {
 		for _, dupe := range dupes {
-			if dupe.cached {
+			if dupe.isNovel || ip.isKept(dupe) {
 				continue
 			}
 			tp.Queue(func() {
-				err := os.Remove(dupe.path)
+				err := ip.disposeDupe(dupe, dupe.path)
 				if err != nil {
 					mux.Lock()
 					errors = append(errors, err)
@@ -395,6 +639,19 @@ func (ip *ImageProcessor) deleteAndRename() error {
 		}
 	}
 
+	// Dupes must be gone before renaming, because a cached dupe can
+	// have the same name that a new keeper is renamed to.
+	tp.Wait()
+
+	tp, err = utils.NewThreadPool(
+		poolSize(),
+		max(len(newImages), 10),
+		false,
+	)
+	if err != nil {
+		return err
+	}
+
 	for newImgHash, hashInfo := range newImages {
 		tp.Queue(func() {
 			err := ip.renameImages(hashInfo, newImgHash)
@@ -419,7 +676,7 @@ func (ip *ImageProcessor) renameOnly() error {
 	pi := ip.processedImages
 
 	tp, err := utils.NewThreadPool(
-		runtime.NumCPU(),
+		poolSize(),
 		max(len(pi.DupeImagesByHash)+len(pi.NewImagesByHash), 10),
 		false,
 	)
@@ -428,7 +685,7 @@ func (ip *ImageProcessor) renameOnly() error {
 	}
 
 	errors := []error{}
-	ip.Status.MaxUpdateProgress = int32(len(pi.NewImagesByHash))
+	atomic.StoreInt32(&ip.Status.MaxUpdateProgress, int32(len(pi.NewImagesByHash)))
 
 	for newImgHash, hashInfo := range pi.NewImagesByHash {
 		tp.Queue(func() {
@@ -451,21 +708,99 @@ func (ip *ImageProcessor) renameOnly() error {
 	return nil
 }
 
-func (ip *ImageProcessor) renameImages(hi HashInfo, newImgHash string) error {
-	dir := filepath.Dir(hi.path)
-	// Uppercase extensions are ugly and inconsistent
-	ext := strings.ToLower(filepath.Ext(hi.path))
-	newFileName := filepath.Join(dir, ip.hashPrefix+newImgHash+ext)
-	err := os.Rename(hi.path, newFileName)
+/*
+disposeDupe disposes of the dupe at path, counting the bytes it took.
+Only deleted dupes free space, so moved dupes are counted separately.
+*/
+func (ip *ImageProcessor) disposeDupe(dupe HashInfo, path string) error {
+	info, err := ip.fs.Stat(path)
 	if err != nil {
 		return err
 	}
+	log := ip.log.With("hash", dupe.hash)
+	if err := disposeFile(ip.fs, log, path, filepath.Base(dupe.path), ip.disposalFolder); err != nil {
+		return err
+	}
+	if ip.MovesDupes() {
+		ip.Status.AddMovedBytes(info.Size())
+		return nil
+	}
+	ip.Status.AddReclaimedBytes(info.Size())
+	return nil
+}
+
+// MovesDupes reports whether dupes are moved to the disposal folder, instead of deleted
+func (ip *ImageProcessor) MovesDupes() bool {
+	return ip.disposalFolder != ""
+}
+
+// IsReview reports whether the dupes were moved to the review folder
+func (ip *ImageProcessor) IsReview() bool {
+	return ip.isReviewProcess
+}
+
+// countDupe counts a dupe that will be disposed of
+func (ip *ImageProcessor) countDupe(hi HashInfo) {
+	ip.Status.DupeImageCount += 1
+	if IsVideo(hi.path) {
+		ip.Status.DupeVideoCount += 1
+	}
+}
+
+/*
+renameImages renames the image to its hash, but never replaces another
+file. When the name is taken, the image keeps its name and the conflict
+is recorded instead.
+*/
+func (ip *ImageProcessor) renameImages(hi HashInfo, newImgHash string) error {
+	newFileName := filepath.Join(filepath.Dir(hi.path), ip.HashName(newImgHash, hi.path))
+	log := ip.log.With("path", hi.path, "target", newFileName, "hash", newImgHash)
+	err := ip.fs.Rename(hi.path, newFileName)
+	if !errors.Is(err, fs.ErrExist) {
+		logResult(log, "renamed", "rename failed", err)
+		return err
+	}
+
+	// Case insensitive filesystems see a.PNG and a.png as one file, so
+	// it's renamed in two steps to change the case only
+	if isSameFile(ip.fs, hi.path, newFileName) {
+		tmpName := newFileName + ".hashimg-rename"
+		err := ip.fs.Rename(hi.path, tmpName)
+		if err == nil {
+			err = ip.fs.Rename(tmpName, newFileName)
+		}
+		logResult(log, "renamed", "rename failed", err)
+		return err
+	}
+
+	log.Warn("rename conflict")
+	mux.Lock()
+	ip.Conflicts = append(ip.Conflicts, RenameConflict{Path: hi.path, Target: newFileName})
+	mux.Unlock()
+	atomic.AddInt32(&ip.Status.ConflictCount, 1)
 	return nil
 }
 
+func isSameFile(fsys FS, a, b string) bool {
+	aInfo, err := fsys.Stat(a)
+	if err != nil {
+		return false
+	}
+	bInfo, err := fsys.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(aInfo, bInfo)
+}
+
 func max(a, b int) int {
 	if a > b {
 		return a
 	}
 	return b
 }
+
+// poolSize is the thread count for CPU bound pools, which need at least 2
+func poolSize() int {
+	return max(runtime.NumCPU(), 2)
+}
@@ -5,9 +5,10 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"strings"
+	"slices"
 	"testing"
 
+	"github.com/jaeiya/hashimg/lib/utils"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -113,6 +114,35 @@ func TestImageProcessor(t *testing.T) {
 				"0x@a4e624d686e03ed2767c0abd85c14426.bmp",
 			},
 		},
+		{
+			should: "delete cached images sharing a hash",
+			files: []string{
+				"0x@1b4f0e9851971998e732078544c96b36.png",
+				"0x@1b4f0e9851971998e732078544c96b36.webp",
+				"test2.png",
+			},
+			fileContent:          []string{"test1", "test1", "test2"},
+			expectUpdateProgress: 2,
+			expectDupeCount:      1,
+			expectFiles: []string{
+				"0x@1b4f0e9851971998e732078544c96b36.png",
+				"0x@60303ae22b998861bce3b28f33eec1be.png",
+			},
+		},
+		{
+			should: "delete new images sharing a hash with cached images",
+			files: []string{
+				"0x@1b4f0e9851971998e732078544c96b36.webp",
+				"0x@1b4f0e9851971998e732078544c96b36.png",
+				"test1.jpg",
+			},
+			fileContent:          []string{"test1", "test1", "test1"},
+			expectUpdateProgress: 2,
+			expectDupeCount:      2,
+			expectFiles: []string{
+				"0x@1b4f0e9851971998e732078544c96b36.png",
+			},
+		},
 		{
 			should: "handle large load of images",
 			files: []string{
@@ -179,24 +209,27 @@ func TestImageProcessor(t *testing.T) {
 		t.Run("should "+d.should, func(t *testing.T) {
 			t.Parallel()
 			a := assert.New(t)
-			dir := t.TempDir()
-			err := writeFiles(dir, d.files, d.fileContent)
+			fsys := NewMemFS()
+			dir := filepath.Join(string(filepath.Separator), "images")
+			require.NoError(t, fsys.MkdirAll(dir, 0o755))
+			err := writeFiles(fsys, dir, d.files, d.fileContent)
 			a.NoError(err)
 
-			fileNames, err := readDir(dir)
+			fileNames, err := readDir(fsys, dir)
 			a.NoError(err)
 			a.Equal(
 				len(d.fileContent),
 				len(fileNames),
 				"should always have the same number of files as file content",
 			)
-			iMap, err := MapImages(dir, hashPrefix)
+			iMap, err := MapImagesWithConfig(MapperConfig{Dir: dir, Prefix: hashPrefix, FS: fsys})
 			a.NoError(err)
 			imgProcessor := NewImageProcessor(ImageProcessorConfig{
 				WorkingDir: dir,
 				Prefix:     hashPrefix,
 				ImageMap:   iMap,
 				HashLength: hashLength,
+				FS:         fsys,
 			})
 			err = imgProcessor.ProcessImages(false)
 			require.NoError(t, err)
@@ -226,7 +259,7 @@ func TestImageProcessor(t *testing.T) {
 				"total update progress should always equal max",
 			)
 
-			fileNames, err = readDir(dir)
+			fileNames, err = readDir(fsys, dir)
 			a.NoError(err)
 			for _, fn := range fileNames {
 				a.Contains(d.expectFiles, fn, "expected files should contain actual file")
@@ -437,12 +470,12 @@ func TestReviewProcess(t *testing.T) {
 			hasFiles := len(d.files) > 0
 
 			if hasFiles {
-				err := writeFiles(dir, d.files, d.fileContent)
+				err := writeFiles(OSFS{}, dir, d.files, d.fileContent)
 				require.NoError(t, err)
 			}
 
 			if hasDupes {
-				err := writeFiles(dir, d.dupeFiles, d.dupeContent)
+				err := writeFiles(OSFS{}, dir, d.dupeFiles, d.dupeContent)
 				require.NoError(t, err)
 			}
 
@@ -470,8 +503,12 @@ func TestReviewProcess(t *testing.T) {
 			if hasDupes {
 				_, err = os.Stat(filepath.Join(dir, "__dupes"))
 				require.NoError(t, err, "dupes folder should exist")
-				fileNames, err := readDir(filepath.Join(dir, "__dupes"))
+				fileNames, err := readDir(OSFS{}, filepath.Join(dir, "__dupes"))
 				require.NoError(t, err, "read directory without error")
+				require.Contains(t, fileNames, reviewManifestName, "manifest should exist")
+				fileNames = slices.DeleteFunc(fileNames, func(fn string) bool {
+					return fn == reviewManifestName
+				})
 				for _, fn := range fileNames {
 					a.Contains(
 						d.expectDupeFiles,
@@ -493,7 +530,7 @@ func TestReviewProcess(t *testing.T) {
 			}
 
 			if len(d.files) > 0 {
-				fileNames, err := readDir(dir)
+				fileNames, err := readDir(OSFS{}, dir)
 				require.NoError(t, err, "read directory without error")
 
 				// If dupes exist then we don't count __dupes dir
@@ -523,7 +560,7 @@ func TestReviewProcess(t *testing.T) {
 			)
 
 			if len(d.files) == 0 {
-				files, err := readDir(dir)
+				files, err := readDir(OSFS{}, dir)
 				require.NoError(t, err)
 				a.Len(files, 1, "all files should be moved to dupes folder")
 			}
@@ -539,6 +576,7 @@ func TestReviewRestoration(t *testing.T) {
 		a := assert.New(t)
 		dir := t.TempDir()
 		err := writeFiles(
+			OSFS{},
 			dir,
 			[]string{
 				"t1.jpg",
@@ -568,7 +606,7 @@ func TestReviewRestoration(t *testing.T) {
 		err = imgProcessor.RestoreFromReview()
 		require.NoError(t, err)
 
-		fileNames, err := readDir(dir)
+		fileNames, err := readDir(OSFS{}, dir)
 		require.NoError(t, err)
 		a.Len(fileNames, 3, "there should only be 3 preserved files")
 		a.NotContains(fileNames, "__dupes", "temp dupe folder should not exist")
@@ -578,167 +616,147 @@ func TestReviewRestoration(t *testing.T) {
 	})
 }
 
-func TestCalcBuffer(t *testing.T) {
-	hashPrefix := "0x@"
+func TestRenameConflicts(t *testing.T) {
+	t.Run("should keep both files when the hash name is taken", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		dir := t.TempDir()
+		// The taken name isn't part of the image map, like an ignored file
+		taken := fmt.Sprintf("0x@%s.jpg", calcSha256("1"))
+		require.NoError(t, writeFiles(OSFS{}, dir, []string{"t1.jpg", taken}, []string{"1", "2"}))
 
-	md := []MockImgProcData{
-		{
-			should: "calculate average buffer if files are the same size",
-			files: []string{
-				fmt.Sprintf("0x@%s.jpg", calcSha256("10")),
-				fmt.Sprintf("0x@%s.jpg", calcSha256("11")),
-				fmt.Sprintf("0x@%s.jpg", calcSha256("12")),
-				fmt.Sprintf("0x@%s.jpg", calcSha256("13")),
-				"t1.jpg",
-				"t2.jpg",
-				"t3.jpg",
-				"t4.jpg",
-				"t6.jpg",
-				"t7.jpg",
-			},
-			fileContent: []string{
-				"0",
-				"1",
-				"2",
-				"3",
-				"this is some longer data 1",
-				"this is some longer data 2",
-				"this is some longer data 3",
-				"this is some longer data 4",
-				"this is some longer data 5",
-				"this is some longer data 6",
-			},
-		},
-		{
-			should: "ignore cached files",
-			files: []string{
-				fmt.Sprintf("0x@%s.jpg", calcSha256("10")),
-				fmt.Sprintf("0x@%s.jpg", calcSha256("10")),
-				fmt.Sprintf("0x@%s.jpg", calcSha256("10")),
-				fmt.Sprintf("0x@%s.jpg", calcSha256("10")),
-				fmt.Sprintf("0x@%s.jpg", calcSha256("10")),
-			},
-			fileContent: []string{
-				"1", "2", "3", "4", "5",
-			},
-		},
-		{
-			should: "produce average from all non-cached random size files",
-			files: []string{
-				fmt.Sprintf("0x@%s.jpg", calcSha256("10")),
-				fmt.Sprintf("0x@%s.jpg", calcSha256("10")),
-				fmt.Sprintf("0x@%s.jpg", calcSha256("10")),
-				fmt.Sprintf("0x@%s.jpg", calcSha256("10")),
-				fmt.Sprintf("0x@%s.jpg", calcSha256("10")),
-				"t1.jpg",
-				"t2.jpg",
-				"t3.jpg",
-				"t4.jpg",
-				"t5.jpg",
-				"t6.jpg",
-				"t7.jpg",
-				"t8.jpg",
-				"t9.jpg",
-				"t10.jpg",
-			},
-			fileContent: []string{
-				"1", "2", "3", "4", "5",
-				"some random size",
-				"more random sized content",
-				"whatever I want",
-				"test data",
-				"3821",
-				"38812348218482384828348823848238482348234",
-				"9192348971239487283482384",
-				"51",
-				"1282992382",
-				"and last but not least",
-			},
-		},
-		{
-			should: "calculate average buffer without including invalid extensions",
-			files: []string{
-				"t1.jpg",
-				"t2.jpg",
-				"t3.jpg",
-				"t4.jpg",
-				"t5.jpg",
-				"t6.bad",
-				"t7.bad",
-				"t8.bad",
-				"t9.bad",
-				"t10.bad",
-			},
-			fileContent: []string{
-				"0",
-				"0",
-				"0",
-				"0",
-				"0",
-				"38812348218482384828348823848238482348234",
-				"38812348218482384828348823848238482348234",
-				"38812348218482384828348823848238482348234",
-				"38812348218482384828348823848238482348234",
-				"38812348218482384828348823848238482348234",
-			},
-		},
-	}
+		imgProcessor := NewImageProcessor(ImageProcessorConfig{
+			WorkingDir: dir,
+			Prefix:     "0x@",
+			ImageMap:   ImageMap{"t1.jpg": NotCached},
+			HashLength: hashLength,
+		})
+		require.NoError(t, imgProcessor.ProcessImages(false))
+		require.NoError(t, imgProcessor.UpdateImages())
 
-	for _, d := range md {
-		t.Run("should "+d.should, func(t *testing.T) {
-			t.Parallel()
-			a := assert.New(t)
-			dir := t.TempDir()
+		a.Equal(int32(1), imgProcessor.Status.ConflictCount)
+		a.Equal([]RenameConflict{{
+			Path:   filepath.Join(dir, "t1.jpg"),
+			Target: filepath.Join(dir, taken),
+		}}, imgProcessor.Conflicts)
 
-			err := writeFiles(dir, d.files, d.fileContent)
+		for fileName, content := range map[string]string{"t1.jpg": "1", taken: "2"} {
+			data, err := os.ReadFile(filepath.Join(dir, fileName))
 			require.NoError(t, err)
+			a.Equal(content, string(data))
+		}
+	})
+}
 
-			files, err := os.ReadDir(dir)
-			require.NoError(t, err)
-			var fileCount int64
-			var totalSize int64
-			for _, f := range files {
-				if f.IsDir() {
-					continue
-				}
-				if strings.Contains(f.Name(), "0x@") || strings.Contains(f.Name(), ".bad") {
-					continue
-				}
-				info, err := f.Info()
-				require.NoError(t, err)
-				totalSize += info.Size()
-				fileCount += 1
-			}
+func TestReadScheduling(t *testing.T) {
+	const hashPrefix = "0x@"
 
-			iMap, err := MapImages(dir, hashPrefix)
-			a.NoError(err)
-			imgProcessor := NewImageProcessor(ImageProcessorConfig{
-				WorkingDir: dir,
-				Prefix:     hashPrefix,
-				ImageMap:   iMap,
-				HashLength: hashLength,
-			})
+	files := []string{
+		fmt.Sprintf("0x@%s.jpg", calcSha256("10")),
+		fmt.Sprintf("0x@%s.jpg", calcSha256("11")),
+		"t1.jpg",
+		"t2.jpg",
+		"t3.jpg",
+		"t4.jpg",
+		"t5.png",
+		"t6.png",
+	}
+	content := []string{"10", "11", "1", "2", "3", "1", "10", "4"}
 
-			avgCount, err := imgProcessor.calcBufferSize(true)
-			require.NoError(t, err)
+	newProcessor := func(t *testing.T, readers int) (string, *ImageProcessor) {
+		dir := t.TempDir()
+		require.NoError(t, writeFiles(OSFS{}, dir, files, content))
+		iMap, err := MapImages(dir, hashPrefix)
+		require.NoError(t, err)
+		return dir, NewImageProcessor(ImageProcessorConfig{
+			WorkingDir: dir,
+			Prefix:     hashPrefix,
+			ImageMap:   iMap,
+			HashLength: hashLength,
+			Readers:    readers,
+		})
+	}
+
+	t.Run("should read sequentially from spinning disks", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		_, imgProcessor := newProcessor(t, 0)
+
+		readers, ordered := imgProcessor.readStrategy(true)
+		a.Equal(hddReaders, readers)
+		a.True(ordered)
+
+		readers, ordered = imgProcessor.readStrategy(false)
+		a.Greater(readers, hddReaders)
+		a.False(ordered)
+	})
+
+	t.Run("should prefer configured readers", func(t *testing.T) {
+		t.Parallel()
+		_, imgProcessor := newProcessor(t, 3)
+		readers, _ := imgProcessor.readStrategy(false)
+		assert.Equal(t, 3, readers)
+	})
+
+	t.Run("should plan cached images first and order the rest", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		dir, imgProcessor := newProcessor(t, 0)
 
-			if fileCount == 0 {
-				a.Equal(fileCount, avgCount)
-				return
+		fileNames, err := imgProcessor.planReads(true)
+		require.NoError(t, err)
+		require.Len(t, fileNames, len(files))
+		a.ElementsMatch(files[:2], fileNames[:2])
+
+		var lastKey uint64
+		for _, fileName := range fileNames[2:] {
+			info, err := os.Stat(filepath.Join(dir, fileName))
+			require.NoError(t, err)
+			key, ok := utils.ReadOrderKey(info)
+			if !ok {
+				t.Skip("read order is not supported on this platform")
 			}
+			a.GreaterOrEqual(key, lastKey)
+			lastKey = key
+		}
+	})
 
-			// Should round up
-			a.Equal((totalSize+fileCount-1)/fileCount, avgCount)
-		})
+	t.Run("should find the same dupes with every strategy", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		var results []ProcessedImages
+		for _, isHDD := range []bool{true, false} {
+			_, imgProcessor := newProcessor(t, 0)
+			require.NoError(t, imgProcessor.ProcessImages(isHDD))
+			a.Equal(int32(len(files)), imgProcessor.Status.HashProgress)
+			a.Equal(int32(2), imgProcessor.Status.CachedImageCount)
+			a.Equal(int64(7), imgProcessor.Status.TotalHashBytes, "cached files aren't read")
+			a.Equal(imgProcessor.Status.TotalHashBytes, imgProcessor.Status.HashedBytes)
+			results = append(results, *imgProcessor.processedImages)
+		}
+		for i := range results {
+			a.Len(results[i].DupeImagesByHash, 2)
+			a.Len(results[i].NewImagesByHash, 3)
+		}
+		a.ElementsMatch(mapKeys(results[0].NewImagesByHash), mapKeys(results[1].NewImagesByHash))
+		a.ElementsMatch(mapKeys(results[0].DupeImagesByHash), mapKeys(results[1].DupeImagesByHash))
+	})
+}
+
+func mapKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
 	}
+	return keys
 }
 
-func writeFiles(dir string, files []string, fileContent []string) error {
+func writeFiles(fsys FS, dir string, files []string, fileContent []string) error {
 	if len(files) != len(fileContent) {
 		return fmt.Errorf("files length does not match file content length")
 	}
 	for i, file := range files {
-		err := os.WriteFile(dir+"/"+file, []byte(fileContent[i]), 0o644)
+		err := fsys.WriteFile(filepath.Join(dir, file), []byte(fileContent[i]), 0o644)
 		if err != nil {
 			return err
 		}
@@ -752,8 +770,8 @@ func calcSha256(v string) string {
 	return fmt.Sprintf("%x", s.Sum(nil))[:hashLength]
 }
 
-func readDir(dir string) ([]string, error) {
-	dirEntries, err := os.ReadDir(dir)
+func readDir(fsys FS, dir string) ([]string, error) {
+	dirEntries, err := fsys.ReadDir(dir)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,36 @@
+package lib
+
+import "io/fs"
+
+/*
+applyKeeperPolicy picks the keeper of every dupe group with the keeper
+policy. The "first" policy keeps the keepers chosen while filtering.
+*/
+func (ip *ImageProcessor) applyKeeperPolicy() error {
+	if ip.keeperPolicy != KeepOldest && ip.keeperPolicy != KeepNewest {
+		return nil
+	}
+
+	for hash, dupes := range ip.processedImages.DupeImagesByHash {
+		keeper := ""
+		var keeperInfo fs.FileInfo
+		for _, dupe := range dupes {
+			info, err := ip.fs.Stat(dupe.path)
+			if err != nil {
+				return err
+			}
+			isBetter := keeperInfo == nil ||
+				(ip.keeperPolicy == KeepOldest && info.ModTime().Before(keeperInfo.ModTime())) ||
+				(ip.keeperPolicy == KeepNewest && info.ModTime().After(keeperInfo.ModTime()))
+			if isBetter {
+				keeper, keeperInfo = dupe.path, info
+			}
+		}
+
+		if err := ip.setKeeper(hash, keeper); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
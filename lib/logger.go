@@ -0,0 +1,102 @@
+package lib
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+type LogFormat string
+
+const (
+	LogText LogFormat = "text"
+	LogJSON LogFormat = "json"
+)
+
+// LogFileName is the name of the log file inside the log directory
+const LogFileName = "hashimg.log"
+
+const (
+	logFileMaxSize = 10 * 1024 * 1024
+	logFileBackups = 3
+)
+
+// Nothing is logged unless the processor is given a logger
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func (lf LogFormat) Validate() error {
+	switch lf {
+	case LogText, LogJSON:
+		return nil
+	}
+	return ErrUnknownLogFormat
+}
+
+// NewLogger logs records at level or above to every writer.
+func NewLogger(format LogFormat, level slog.Leveler, writers ...io.Writer) (*slog.Logger, error) {
+	if err := format.Validate(); err != nil {
+		return nil, err
+	}
+	w := io.MultiWriter(writers...)
+	opts := &slog.HandlerOptions{Level: level}
+	if format == LogJSON {
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return slog.New(slog.NewTextHandler(w, opts)), nil
+}
+
+/*
+LogDir is the state directory, unless the OS has a place for the logs
+of every app, like ~/Library/Logs on macOS.
+*/
+func LogDir() (string, error) {
+	if _, ok := xdgStateHome(); ok {
+		return StateDir()
+	}
+
+	switch runtime.GOOS {
+	case "darwin":
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(home, "Library", "Logs", "hashimg"), nil
+	case "windows":
+		dir, err := StateDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, "logs"), nil
+	}
+	return StateDir()
+}
+
+// OpenLogFile opens the log file inside LogDir, creating the directory.
+func OpenLogFile() (*RotatingFile, error) {
+	dir, err := LogDir()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return OpenRotatingFile(filepath.Join(dir, LogFileName), logFileMaxSize, logFileBackups)
+}
+
+func orDiscard(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return discardLogger
+	}
+	return logger
+}
+
+// logResult logs that an operation was done, or why it failed
+func logResult(log *slog.Logger, done, failed string, err error) {
+	if err != nil {
+		log.Error(failed, "err", err)
+		return
+	}
+	log.Info(done)
+}
@@ -0,0 +1,111 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogger(t *testing.T) {
+	t.Run("should reject unknown formats", func(t *testing.T) {
+		t.Parallel()
+		_, err := NewLogger("xml", slog.LevelInfo, &bytes.Buffer{})
+		assert.ErrorIs(t, err, ErrUnknownLogFormat)
+	})
+
+	t.Run("should log every file operation", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		const hashPrefix = "0x@"
+		dir := filepath.Join(string(filepath.Separator), "images")
+		fsys := NewMemFS()
+		require.NoError(t, fsys.MkdirAll(dir, 0o755))
+		require.NoError(t, writeFiles(fsys, dir, []string{"a.png", "b.png", "c.png"}, []string{"1", "1", "2"}))
+		iMap, err := MapImagesWithConfig(MapperConfig{Dir: dir, Prefix: hashPrefix, FS: fsys})
+		require.NoError(t, err)
+
+		buf := &bytes.Buffer{}
+		logger, err := NewLogger(LogJSON, slog.LevelInfo, buf)
+		require.NoError(t, err)
+		ip := NewImageProcessor(ImageProcessorConfig{
+			WorkingDir:   dir,
+			Prefix:       hashPrefix,
+			ImageMap:     iMap,
+			HashLength:   hashLength,
+			KeeperPolicy: KeepFirst,
+			FS:           fsys,
+			Logger:       logger,
+		})
+		require.NoError(t, ip.ProcessImages(false))
+		require.NoError(t, ip.UpdateImages())
+
+		counts := map[string]int{}
+		hashes := map[string]bool{}
+		dec := json.NewDecoder(buf)
+		for dec.More() {
+			record := map[string]any{}
+			require.NoError(t, dec.Decode(&record))
+			msg, _ := record["msg"].(string)
+			counts[msg]++
+			if hash, ok := record["hash"].(string); ok {
+				hashes[hash] = true
+			}
+		}
+
+		a.Equal(map[string]int{
+			"scan started":  1,
+			"hashed":        3,
+			"scan finished": 1,
+			"deleted":       1,
+			"renamed":       2,
+		}, counts)
+		a.Equal(map[string]bool{calcSha256("1"): true, calcSha256("2"): true}, hashes)
+	})
+}
+
+func TestRotatingFile(t *testing.T) {
+	t.Run("should rotate when the file grows too large", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		path := filepath.Join(t.TempDir(), "test.log")
+		require.NoError(t, os.WriteFile(path, []byte("old\n"), 0o600))
+
+		rf, err := OpenRotatingFile(path, 8, 2)
+		require.NoError(t, err)
+		for _, line := range []string{"one\n", "two\n", "three\n", "four\n"} {
+			_, err := rf.Write([]byte(line))
+			require.NoError(t, err)
+		}
+		require.NoError(t, rf.Close())
+
+		for name, expected := range map[string]string{
+			"test.log":   "four\n",
+			"test.log.1": "three\n",
+			"test.log.2": "two\n",
+		} {
+			data, err := os.ReadFile(filepath.Join(filepath.Dir(path), name))
+			require.NoError(t, err)
+			a.Equal(expected, string(data), name)
+		}
+		a.NoFileExists(path+".3", "should only keep 2 backups")
+
+		_, err = rf.Write([]byte("closed"))
+		a.ErrorIs(err, os.ErrClosed)
+	})
+}
+
+func TestLogDir(t *testing.T) {
+	t.Run("should use XDG_STATE_HOME", func(t *testing.T) {
+		state := filepath.Join(t.TempDir(), "state")
+		t.Setenv("XDG_STATE_HOME", state)
+		dir, err := LogDir()
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(state, "hashimg"), dir)
+	})
+}
@@ -0,0 +1,272 @@
+package lib
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errNotDir = errors.New("not a directory")
+
+/*
+MemFS is a filesystem that only exists in memory, which is mostly useful
+for tests. Like on Linux, names are case sensitive. Root directories,
+like / or C:\, always exist.
+*/
+type MemFS struct {
+	mux   sync.Mutex
+	nodes map[string]*memNode
+}
+
+type memNode struct {
+	data    []byte
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func NewMemFS() *MemFS {
+	return &MemFS{nodes: map[string]*memNode{}}
+}
+
+func (m *MemFS) Open(name string) (fs.File, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	name = filepath.Clean(name)
+	node, err := m.node("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return &memFile{Reader: bytes.NewReader(node.data), info: node.info(name)}, nil
+}
+
+func (m *MemFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	name = filepath.Clean(name)
+	if err := m.checkDir("readdirent", name); err != nil {
+		return nil, err
+	}
+
+	entries := []fs.DirEntry{}
+	for path, node := range m.nodes {
+		if filepath.Dir(path) == name && path != name {
+			entries = append(entries, fs.FileInfoToDirEntry(node.info(path)))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+func (m *MemFS) Stat(name string) (fs.FileInfo, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	name = filepath.Clean(name)
+	if isRoot(name) {
+		return memDir().info(name), nil
+	}
+	node, err := m.node("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return node.info(name), nil
+}
+
+func (m *MemFS) Rename(oldPath, newPath string) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	oldPath, newPath = filepath.Clean(oldPath), filepath.Clean(newPath)
+	linkErr := func(err error) error {
+		return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: err}
+	}
+	if _, ok := m.nodes[oldPath]; !ok {
+		return linkErr(fs.ErrNotExist)
+	}
+	if _, ok := m.nodes[newPath]; ok || isRoot(newPath) {
+		return linkErr(fs.ErrExist)
+	}
+	if err := m.checkDir("rename", filepath.Dir(newPath)); err != nil {
+		return linkErr(err.(*fs.PathError).Err)
+	}
+
+	// Directories are moved with everything inside them
+	for path, node := range m.nodes {
+		if rel, ok := within(oldPath, path); ok {
+			delete(m.nodes, path)
+			m.nodes[filepath.Join(newPath, rel)] = node
+		}
+	}
+	return nil
+}
+
+func (m *MemFS) Remove(name string) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	name = filepath.Clean(name)
+	if _, err := m.node("remove", name); err != nil {
+		return err
+	}
+	for path := range m.nodes {
+		if filepath.Dir(path) == name {
+			return &fs.PathError{Op: "remove", Path: name, Err: errors.New("directory not empty")}
+		}
+	}
+	delete(m.nodes, name)
+	return nil
+}
+
+func (m *MemFS) RemoveAll(name string) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	name = filepath.Clean(name)
+	for path := range m.nodes {
+		if _, ok := within(name, path); ok {
+			delete(m.nodes, path)
+		}
+	}
+	return nil
+}
+
+func (m *MemFS) MkdirAll(name string, perm fs.FileMode) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	name = filepath.Clean(name)
+	if isRoot(name) {
+		return nil
+	}
+	if node, ok := m.nodes[name]; ok {
+		if !node.mode.IsDir() {
+			return &fs.PathError{Op: "mkdir", Path: name, Err: errNotDir}
+		}
+		return nil
+	}
+
+	m.mux.Unlock()
+	err := m.MkdirAll(filepath.Dir(name), perm)
+	m.mux.Lock()
+	if err != nil {
+		return err
+	}
+	m.nodes[name] = &memNode{mode: fs.ModeDir | perm, modTime: time.Now()}
+	return nil
+}
+
+func (m *MemFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	name = filepath.Clean(name)
+	if err := m.checkDir("open", filepath.Dir(name)); err != nil {
+		return err
+	}
+	if node, ok := m.nodes[name]; ok && node.mode.IsDir() {
+		return &fs.PathError{Op: "open", Path: name, Err: errors.New("is a directory")}
+	}
+	m.nodes[name] = &memNode{data: bytes.Clone(data), mode: perm, modTime: time.Now()}
+	return nil
+}
+
+// Chtimes changes the modification time, like os.Chtimes.
+func (m *MemFS) Chtimes(name string, modTime time.Time) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	node, err := m.node("chtimes", filepath.Clean(name))
+	if err != nil {
+		return err
+	}
+	node.modTime = modTime
+	return nil
+}
+
+func (m *MemFS) node(op, name string) (*memNode, error) {
+	node, ok := m.nodes[name]
+	if !ok {
+		if isRoot(name) {
+			return memDir(), nil
+		}
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return node, nil
+}
+
+func (m *MemFS) checkDir(op, name string) error {
+	node, err := m.node(op, name)
+	if err != nil {
+		return err
+	}
+	if !node.mode.IsDir() {
+		return &fs.PathError{Op: op, Path: name, Err: errNotDir}
+	}
+	return nil
+}
+
+func memDir() *memNode {
+	return &memNode{mode: fs.ModeDir | 0o755}
+}
+
+func isRoot(name string) bool {
+	return filepath.Dir(name) == name
+}
+
+// within reports whether path is dir, or inside it
+func within(dir, path string) (string, bool) {
+	if path == dir {
+		return "", true
+	}
+	rel, ok := strings.CutPrefix(path, dir)
+	if !ok || !strings.HasPrefix(rel, string(filepath.Separator)) {
+		return "", false
+	}
+	return rel[1:], true
+}
+
+func (n *memNode) info(name string) fs.FileInfo {
+	return memFileInfo{name: filepath.Base(name), node: *n}
+}
+
+type memFileInfo struct {
+	name string
+	node memNode
+}
+
+func (fi memFileInfo) Name() string       { return fi.name }
+func (fi memFileInfo) Size() int64        { return int64(len(fi.node.data)) }
+func (fi memFileInfo) Mode() fs.FileMode  { return fi.node.mode }
+func (fi memFileInfo) ModTime() time.Time { return fi.node.modTime }
+func (fi memFileInfo) IsDir() bool        { return fi.node.mode.IsDir() }
+func (fi memFileInfo) Sys() any           { return nil }
+
+type memFile struct {
+	*bytes.Reader
+	info fs.FileInfo
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	if f.info.IsDir() {
+		return 0, &fs.PathError{Op: "read", Path: f.info.Name(), Err: errors.New("is a directory")}
+	}
+	return f.Reader.Read(p)
+}
+
+func (f *memFile) Close() error {
+	return nil
+}
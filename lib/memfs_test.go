@@ -0,0 +1,80 @@
+package lib
+
+import (
+	"io/fs"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemFS(t *testing.T) {
+	root := string(filepath.Separator)
+
+	t.Run("should read back written files", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		fsys := NewMemFS()
+		dir := filepath.Join(root, "images")
+		require.NoError(t, fsys.MkdirAll(dir, 0o755))
+		require.NoError(t, writeFiles(fsys, dir, []string{"b.png", "a.png"}, []string{"b", "aa"}))
+
+		fileNames, err := readDir(fsys, dir)
+		require.NoError(t, err)
+		a.Equal([]string{"a.png", "b.png"}, fileNames, "should be sorted by name")
+
+		data, err := fs.ReadFile(fsys, filepath.Join(dir, "a.png"))
+		require.NoError(t, err)
+		a.Equal("aa", string(data))
+
+		info, err := fsys.Stat(filepath.Join(dir, "a.png"))
+		require.NoError(t, err)
+		a.EqualValues(2, info.Size())
+		a.False(info.IsDir())
+	})
+
+	t.Run("should need the parent directory", func(t *testing.T) {
+		t.Parallel()
+		fsys := NewMemFS()
+		err := fsys.WriteFile(filepath.Join(root, "missing", "a.png"), []byte("a"), 0o644)
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+	})
+
+	t.Run("should never replace on rename", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		fsys := NewMemFS()
+		src, dst := filepath.Join(root, "a.png"), filepath.Join(root, "b.png")
+		require.NoError(t, fsys.WriteFile(src, []byte("a"), 0o644))
+		require.NoError(t, fsys.WriteFile(dst, []byte("b"), 0o644))
+
+		a.ErrorIs(fsys.Rename(src, dst), fs.ErrExist)
+		data, err := fs.ReadFile(fsys, dst)
+		require.NoError(t, err)
+		a.Equal("b", string(data))
+	})
+
+	t.Run("should move and remove directories with their files", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		fsys := NewMemFS()
+		dir := filepath.Join(root, "dupes")
+		require.NoError(t, fsys.MkdirAll(dir, 0o755))
+		require.NoError(t, writeFiles(fsys, dir, []string{"a.png"}, []string{"a"}))
+
+		a.Error(fsys.Remove(dir), "should only remove empty directories")
+
+		moved := filepath.Join(root, "moved")
+		require.NoError(t, fsys.Rename(dir, moved))
+		_, err := fsys.Stat(filepath.Join(moved, "a.png"))
+		a.NoError(err)
+		_, err = fsys.Stat(dir)
+		a.ErrorIs(err, fs.ErrNotExist)
+
+		require.NoError(t, fsys.RemoveAll(moved))
+		fileNames, err := readDir(fsys, root)
+		require.NoError(t, err)
+		a.Empty(fileNames)
+	})
+}
@@ -0,0 +1,58 @@
+package lib
+
+import (
+	fPath "path/filepath"
+	"strings"
+)
+
+/*
+StaleCacheNames returns the cached images whose hash doesn't fit the
+current hash length, like images renamed before the length or the
+prefix was changed. Their hashes never match new hashes, so their
+dupes slip through until they're migrated.
+
+Algorithms can't be told apart by name, so when algorithmChanged is
+set, every cached image is stale. See CachedAlgorithm.
+*/
+func (im ImageMap) StaleCacheNames(prefix string, length int, algorithmChanged bool) []string {
+	stale := []string{}
+	for fileName, cs := range im {
+		if cs != Cached {
+			continue
+		}
+		if algorithmChanged || !isHashName(cachedHash(fileName, prefix), length) {
+			stale = append(stale, fileName)
+		}
+	}
+	return stale
+}
+
+/*
+Migrate marks the stale cached images as not cached, so they're hashed
+and renamed to the current scheme like new images. It returns how many
+images were marked.
+*/
+func (im ImageMap) Migrate(prefix string, length int, algorithmChanged bool) int {
+	stale := im.StaleCacheNames(prefix, length, algorithmChanged)
+	for _, fileName := range stale {
+		im[fileName] = NotCached
+	}
+	return len(stale)
+}
+
+func isHashName(hash string, length int) bool {
+	if len(hash) != length {
+		return false
+	}
+	for _, c := range hash {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// cachedHash is the hash in the name of a cached image
+func cachedHash(fileName, prefix string) string {
+	return strings.TrimPrefix(strings.TrimSuffix(fileName, fPath.Ext(fileName)), prefix)
+}
@@ -0,0 +1,76 @@
+package lib
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrate(t *testing.T) {
+	const hashPrefix = "0x@"
+
+	t.Run("should find cached images with another hash length", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		current := fmt.Sprintf("0x@%s.png", calcSha256("1"))
+		short := fmt.Sprintf("0x@%s.png", calcSha256("2")[:10])
+		notHex := "0x@@" + calcSha256("3")[1:] + ".png"
+		iMap := ImageMap{
+			current:  Cached,
+			short:    Cached,
+			notHex:   Cached,
+			"t1.png": NotCached,
+		}
+
+		a.ElementsMatch([]string{short, notHex}, iMap.StaleCacheNames(hashPrefix, hashLength, false))
+		a.Equal(2, iMap.Migrate(hashPrefix, hashLength, false))
+		a.Equal(ImageMap{
+			current:  Cached,
+			short:    NotCached,
+			notHex:   NotCached,
+			"t1.png": NotCached,
+		}, iMap)
+	})
+
+	t.Run("should find every cached image when the algorithm changed", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		current := fmt.Sprintf("0x@%s.png", calcSha256("1"))
+		iMap := ImageMap{
+			current:  Cached,
+			"t1.png": NotCached,
+		}
+
+		a.Equal([]string{current}, iMap.StaleCacheNames(hashPrefix, hashLength, true))
+		a.Equal(1, iMap.Migrate(hashPrefix, hashLength, true))
+		a.Equal(NotCached, iMap[current])
+	})
+
+	t.Run("should find dupes of migrated images", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		dir := t.TempDir()
+		short := fmt.Sprintf("0x@%s.png", calcSha256("1")[:10])
+		require.NoError(t, writeFiles(OSFS{}, dir, []string{short, "t1.png"}, []string{"1", "1"}))
+
+		iMap, err := MapImages(dir, hashPrefix)
+		require.NoError(t, err)
+		a.Equal(1, iMap.Migrate(hashPrefix, hashLength, false))
+
+		imgProcessor := NewImageProcessor(ImageProcessorConfig{
+			WorkingDir: dir,
+			Prefix:     hashPrefix,
+			ImageMap:   iMap,
+			HashLength: hashLength,
+		})
+		require.NoError(t, imgProcessor.ProcessImages(false))
+		require.NoError(t, imgProcessor.UpdateImages())
+		a.Equal(int32(1), imgProcessor.Status.DupeImageCount)
+
+		fileNames, err := readDir(OSFS{}, dir)
+		require.NoError(t, err)
+		a.Equal([]string{fmt.Sprintf("0x@%s.png", calcSha256("1"))}, fileNames)
+	})
+}
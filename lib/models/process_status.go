@@ -1,33 +1,58 @@
 package models
 
 import (
+	"encoding/json"
 	"sync/atomic"
 	"time"
 )
 
 type ProcessStatus struct {
-	TotalImageCount  int32
-	DupeImageCount   int32
-	CachedImageCount int32
-	NewImageCount    int32
-	BufferSize       int64
+	TotalImageCount int32 `json:"total_images"`
+	DupeImageCount  int32 `json:"dupes"`
+	// Dupes that were kept by the user during review
+	KeptDupeCount    int32 `json:"kept_dupes"`
+	CachedImageCount int32 `json:"cached"`
+	NewImageCount    int32 `json:"new"`
+	// Videos are included in the image counts above
+	TotalVideoCount int32 `json:"total_videos"`
+	DupeVideoCount  int32 `json:"video_dupes"`
+	// Images that kept their name, because it was taken
+	ConflictCount int32 `json:"conflicts"`
+	// Images inside archives, which aren't in the image counts above
+	ArchivedImageCount int32 `json:"archived_images"`
+	ArchiveDupeCount   int32 `json:"archive_dupes"`
+	// Bytes of the dupes that were deleted
+	ReclaimedBytes int64 `json:"reclaimed_bytes"`
+	// Bytes of the dupes moved to the disposal folder, which still
+	// take up the same space
+	MovedBytes int64 `json:"moved_bytes"`
+	// Size of each read while hashing
+	ChunkSize int64 `json:"chunk_size"`
+	// How many files were read at the same time
+	Readers int32 `json:"readers"`
+	// Bytes read while hashing so far
+	HashedBytes int64 `json:"hashed_bytes"`
+	// Bytes of all images that need to be read to be hashed
+	TotalHashBytes int64 `json:"total_hash_bytes"`
 	// Progress of calculating file hashes
-	HashProgress int32
+	HashProgress int32 `json:"hash_progress"`
 	// Progress of renaming and/or removing files
-	UpdateProgress int32
+	UpdateProgress int32 `json:"update_progress"`
 	// The total amount of image hashes that are to be processed
-	MaxHashProgress int32
+	MaxHashProgress int32 `json:"max_hash_progress"`
 	// The total amount of renames and removals to be processed
-	MaxUpdateProgress  int32
-	ProcessingComplete bool
-	UpdatingComplete   bool
-	HashingTook        time.Duration
-	UpdatingTook       time.Duration
-	FilterTook         time.Duration
-	AnalyzeTook        time.Duration
-	TotalTime          time.Duration
-	HashErr            error
-	UpdateErr          error
+	MaxUpdateProgress  int32         `json:"max_update_progress"`
+	ProcessingComplete bool          `json:"processing_complete"`
+	UpdatingComplete   bool          `json:"updating_complete"`
+	HashingTook        time.Duration `json:"hashing_took_ns"`
+	UpdatingTook       time.Duration `json:"updating_took_ns"`
+	FilterTook         time.Duration `json:"filter_took_ns"`
+	AnalyzeTook        time.Duration `json:"analyze_took_ns"`
+	TotalTime          time.Duration `json:"total_time_ns"`
+	HashErr            error         `json:"-"`
+	UpdateErr          error         `json:"-"`
+	hashRate           rate
+	updateRate         rate
 }
 
 // IncProgress atomically increments HashProgress. This makes it
@@ -42,6 +67,102 @@ func (ps *ProcessStatus) IncUpdateProgress() {
 	atomic.AddInt32(&ps.UpdateProgress, 1)
 }
 
+// Progress is the part of the status that changes while working.
+type Progress struct {
+	HashProgress      int32 `json:"hash_progress"`
+	MaxHashProgress   int32 `json:"max_hash_progress"`
+	HashedBytes       int64 `json:"hashed_bytes"`
+	TotalHashBytes    int64 `json:"total_hash_bytes"`
+	UpdateProgress    int32 `json:"update_progress"`
+	MaxUpdateProgress int32 `json:"max_update_progress"`
+}
+
+/*
+Progress atomically reads the progress, so unlike the rest of the
+status, it can be read from another goroutine while working.
+*/
+func (ps *ProcessStatus) Progress() Progress {
+	return Progress{
+		HashProgress:      atomic.LoadInt32(&ps.HashProgress),
+		MaxHashProgress:   atomic.LoadInt32(&ps.MaxHashProgress),
+		HashedBytes:       atomic.LoadInt64(&ps.HashedBytes),
+		TotalHashBytes:    atomic.LoadInt64(&ps.TotalHashBytes),
+		UpdateProgress:    atomic.LoadInt32(&ps.UpdateProgress),
+		MaxUpdateProgress: atomic.LoadInt32(&ps.MaxUpdateProgress),
+	}
+}
+
+// AddReclaimedBytes atomically adds to ReclaimedBytes. This makes it
+// thread-safe.
+func (ps *ProcessStatus) AddReclaimedBytes(n int64) {
+	atomic.AddInt64(&ps.ReclaimedBytes, n)
+}
+
+// AddMovedBytes atomically adds to MovedBytes. This makes it
+// thread-safe.
+func (ps *ProcessStatus) AddMovedBytes(n int64) {
+	atomic.AddInt64(&ps.MovedBytes, n)
+}
+
+// AddHashedBytes atomically adds to HashedBytes. This makes it
+// thread-safe.
+func (ps *ProcessStatus) AddHashedBytes(n int64) {
+	atomic.AddInt64(&ps.HashedBytes, n)
+}
+
+/*
+HashThroughput is how many bytes have been hashed and how fast. Each
+call is a sample of the rate, so it should be called periodically.
+After hashing, the rate is the average.
+*/
+func (ps *ProcessStatus) HashThroughput() Throughput {
+	done := atomic.LoadInt64(&ps.HashedBytes)
+	perSecond := ps.hashRate.sample(done, time.Now())
+	// Once finished, the average is more useful
+	if ps.HashingTook > 0 {
+		perSecond = float64(done) / ps.HashingTook.Seconds()
+	}
+	return newThroughput(done, ps.TotalHashBytes, perSecond)
+}
+
+/*
+UpdateThroughput is how many files have been renamed or removed and how
+fast. Each call is a sample of the rate, so it should be called
+periodically. After updating, the rate is the average.
+*/
+func (ps *ProcessStatus) UpdateThroughput() Throughput {
+	done := int64(atomic.LoadInt32(&ps.UpdateProgress))
+	perSecond := ps.updateRate.sample(done, time.Now())
+	if ps.UpdatingTook > 0 {
+		perSecond = float64(done) / ps.UpdatingTook.Seconds()
+	}
+	return newThroughput(done, int64(ps.MaxUpdateProgress), perSecond)
+}
+
+// MarshalJSON includes the throughput and turns errors into strings.
+func (ps *ProcessStatus) MarshalJSON() ([]byte, error) {
+	// Prevents infinite recursion
+	type status ProcessStatus
+	out := struct {
+		*status
+		HashErr   string     `json:"hash_error,omitempty"`
+		UpdateErr string     `json:"update_error,omitempty"`
+		Hashing   Throughput `json:"hashing"`
+		Updating  Throughput `json:"updating"`
+	}{
+		status:   (*status)(ps),
+		Hashing:  ps.HashThroughput(),
+		Updating: ps.UpdateThroughput(),
+	}
+	if ps.HashErr != nil {
+		out.HashErr = ps.HashErr.Error()
+	}
+	if ps.UpdateErr != nil {
+		out.UpdateErr = ps.UpdateErr.Error()
+	}
+	return json.Marshal(out)
+}
+
 // IncCachedImages atomically increments CachedImages. This makes it
 // thread-safe.
 func (ps *ProcessStatus) IncCachedImages() {
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// How much a new sample affects the rate, between 0 and 1
+	rateSmoothing = 0.3
+	// Samples closer together than this are too noisy to use
+	minRateInterval = 250 * time.Millisecond
+)
+
+// Throughput is a snapshot of how fast work is being done.
+type Throughput struct {
+	Done  int64 `json:"done"`
+	Total int64 `json:"total"`
+	// Units of work per second, smoothed over recent samples
+	PerSecond float64 `json:"per_second"`
+	// Estimated time until Done reaches Total, which is 0 when
+	// the rate is still unknown
+	Remaining time.Duration `json:"remaining_ns"`
+}
+
+/*
+rate estimates how fast a counter grows. Each sample is compared with
+the last, and the result is smoothed so the estimate doesn't jump
+around with every file.
+*/
+type rate struct {
+	mux       sync.Mutex
+	lastTime  time.Time
+	lastValue int64
+	perSecond float64
+}
+
+func (r *rate) sample(value int64, now time.Time) float64 {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if r.lastTime.IsZero() {
+		r.lastTime = now
+		r.lastValue = value
+		return 0
+	}
+
+	elapsed := now.Sub(r.lastTime)
+	if elapsed < minRateInterval {
+		return r.perSecond
+	}
+
+	instant := float64(value-r.lastValue) / elapsed.Seconds()
+	if r.perSecond == 0 {
+		r.perSecond = instant
+	} else {
+		r.perSecond = rateSmoothing*instant + (1-rateSmoothing)*r.perSecond
+	}
+
+	r.lastTime = now
+	r.lastValue = value
+	return r.perSecond
+}
+
+func newThroughput(done, total int64, perSecond float64) Throughput {
+	t := Throughput{Done: done, Total: total, PerSecond: perSecond}
+	if perSecond > 0 && total > done {
+		t.Remaining = time.Duration(float64(total-done) / perSecond * float64(time.Second))
+	}
+	return t
+}
@@ -0,0 +1,313 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"html/template"
+	"image"
+	"image/jpeg"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	thumbnailSize = 160
+	// Images smaller than this are embedded as-is when they can't be decoded
+	maxRawThumbnailSize = 256 * 1024
+)
+
+// Formats that browsers can display, but the standard library can't decode
+var rawThumbnailTypes = map[string]string{
+	".apng": "image/apng",
+	".avif": "image/avif",
+	".bmp":  "image/bmp",
+	".ico":  "image/x-icon",
+	".jxl":  "image/jxl",
+	".svg":  "image/svg+xml",
+	".webp": "image/webp",
+}
+
+type Report struct {
+	Dir       string
+	CreatedAt time.Time
+	Groups    []ReportGroup
+}
+
+type ReportGroup struct {
+	Hash string
+	// The name the keeper will have once the images are updated
+	KeeperName string
+	Files      []ReportFile
+}
+
+type ReportFile struct {
+	DupeFile
+	// A dupe the user chose to keep along with the keeper
+	Kept bool
+	// Zero when the image format can't be decoded
+	Width  int
+	Height int
+	// Data URI of the thumbnail, empty when no thumbnail is available
+	Thumbnail template.URL
+}
+
+var reportHeader = []string{
+	"group",
+	"hash",
+	"keeper",
+	"kept",
+	"path",
+	"size",
+	"modified",
+	"width",
+	"height",
+	"cached",
+	"keeper_name",
+}
+
+/*
+BuildReport collects the details of every duplicate group, so that they
+can be written to a report after the images have been updated. Thumbnails
+are only generated when withThumbnails is true, because they require
+every duplicate to be read from disk.
+*/
+func (ip *ImageProcessor) BuildReport(withThumbnails bool) (*Report, error) {
+	groups, err := ip.DupeGroups()
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Report{
+		Dir:       ip.WorkingDir,
+		CreatedAt: time.Now(),
+		Groups:    make([]ReportGroup, 0, len(groups)),
+	}
+
+	for _, g := range groups {
+		rg := ReportGroup{
+			Hash:       g.Hash,
+			KeeperName: ip.KeeperName(g),
+		}
+		for _, f := range g.Files {
+			rf := ReportFile{DupeFile: f, Kept: ip.isKeptDupe(g.Hash, f)}
+			rf.Width, rf.Height = imageDimensions(ip.fs, f.CurrentPath())
+			if withThumbnails {
+				rf.Thumbnail = thumbnailURI(ip.fs, f)
+			}
+			rg.Files = append(rg.Files, rf)
+		}
+		r.Groups = append(r.Groups, rg)
+	}
+
+	return r, nil
+}
+
+/*
+refreshReport updates the keepers and kept files of the report after
+review decisions have been applied. The dimensions and thumbnails of
+the files are reused, so nothing has to be read from disk again.
+*/
+func (ip *ImageProcessor) refreshReport() error {
+	if ip.Report == nil {
+		return nil
+	}
+
+	groups, err := ip.DupeGroups()
+	if err != nil {
+		return err
+	}
+
+	files := map[string]ReportFile{}
+	for _, g := range ip.Report.Groups {
+		for _, f := range g.Files {
+			files[f.Path] = f
+		}
+	}
+
+	ip.Report.Groups = make([]ReportGroup, 0, len(groups))
+	for _, g := range groups {
+		rg := ReportGroup{
+			Hash:       g.Hash,
+			KeeperName: ip.KeeperName(g),
+		}
+		for _, f := range g.Files {
+			rf := files[f.Path]
+			rf.DupeFile = f
+			rf.Kept = ip.isKeptDupe(g.Hash, f)
+			rg.Files = append(rg.Files, rf)
+		}
+		ip.Report.Groups = append(ip.Report.Groups, rg)
+	}
+	return nil
+}
+
+func (ip *ImageProcessor) isKeptDupe(hash string, f DupeFile) bool {
+	return !f.Keeper && ip.isKept(HashInfo{hash: hash, path: f.Path})
+}
+
+// WriteCSV writes one row for every file in every duplicate group.
+func (r *Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(reportHeader); err != nil {
+		return err
+	}
+
+	for i, g := range r.Groups {
+		for _, f := range g.Files {
+			err := cw.Write([]string{
+				strconv.Itoa(i + 1),
+				g.Hash,
+				strconv.FormatBool(f.Keeper),
+				strconv.FormatBool(f.Kept),
+				f.Path,
+				strconv.FormatInt(f.Size, 10),
+				f.ModTime.Format(time.RFC3339),
+				strconv.Itoa(f.Width),
+				strconv.Itoa(f.Height),
+				strconv.FormatBool(f.Cached),
+				g.KeeperName,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteHTML writes a single static page with every thumbnail embedded.
+func (r *Report) WriteHTML(w io.Writer) error {
+	return reportTemplate.Execute(w, r)
+}
+
+// DupeCount is the number of files that will not be kept.
+func (r *Report) DupeCount() int {
+	count := 0
+	for _, g := range r.Groups {
+		for _, f := range g.Files {
+			if !f.Keeper && !f.Kept {
+				count += 1
+			}
+		}
+	}
+	return count
+}
+
+func imageDimensions(fsys FS, path string) (int, int) {
+	file, err := fsys.Open(path)
+	if err != nil {
+		return 0, 0
+	}
+	defer file.Close()
+
+	cfg, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return 0, 0
+	}
+	return cfg.Width, cfg.Height
+}
+
+func thumbnailURI(fsys FS, f DupeFile) template.URL {
+	mime, data, ok := Thumbnail(fsys, f)
+	if !ok {
+		return ""
+	}
+	return dataURI(mime, data)
+}
+
+/*
+Thumbnail returns a small JPEG of the file, or the file itself when
+browsers can display it, but the standard library can't decode it.
+The file is read from fsys, or the OS filesystem when it's nil.
+*/
+func Thumbnail(fsys FS, f DupeFile) (mime string, data []byte, ok bool) {
+	fsys = orOS(fsys)
+	ext := strings.ToLower(filepath.Ext(f.Path))
+	if mime, ok := rawThumbnailTypes[ext]; ok {
+		if f.Size > maxRawThumbnailSize {
+			return "", nil, false
+		}
+		data, err := fs.ReadFile(fsys, f.CurrentPath())
+		if err != nil {
+			return "", nil, false
+		}
+		return mime, data, true
+	}
+
+	img, err := loadImage(fsys, f.CurrentPath(), thumbnailSize, thumbnailSize)
+	if err != nil {
+		return "", nil, false
+	}
+
+	buf := bytes.Buffer{}
+	err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 80})
+	if err != nil {
+		return "", nil, false
+	}
+	return "image/jpeg", buf.Bytes(), true
+}
+
+func dataURI(mime string, data []byte) template.URL {
+	// The data is always base64 encoded, so it's safe to trust
+	return template.URL("data:" + mime + ";base64," + base64.StdEncoding.EncodeToString(data))
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"inc":  func(i int) int { return i + 1 },
+	"base": filepath.Base,
+	"time": func(t time.Time) string { return t.Format("2006-01-02 15:04:05") },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Hashimg Duplicate Report</title>
+<style>
+body { font-family: sans-serif; background: #131313; color: #DBEFFF; margin: 2em; }
+h1 { color: #34C8FF; }
+.summary { color: #818C95; margin-bottom: 2em; }
+.group { background: #1E1E1E; border-radius: 6px; padding: 1em; margin-bottom: 1.5em; }
+.group h2 { font-size: 1em; color: #A8FF00; margin-top: 0; }
+.group h2 span { color: #818C95; font-weight: normal; font-family: monospace; }
+.files { display: flex; flex-wrap: wrap; gap: 1em; }
+.file { width: 180px; padding: 0.5em; border: 2px solid #333; border-radius: 4px; }
+.file.keeper { border-color: #A8FF00; }
+.file.kept { border-color: #34C8FF; }
+.thumb { width: 160px; height: 160px; display: flex; align-items: center; justify-content: center; background: #000; }
+.thumb img { max-width: 160px; max-height: 160px; }
+.thumb em { color: #626262; font-size: 0.8em; }
+.name { font-weight: bold; word-break: break-all; margin-top: 0.5em; }
+.meta { color: #818C95; font-size: 0.8em; }
+.tag { color: #FFD200; font-size: 0.8em; }
+.keeper .tag { color: #A8FF00; }
+.kept .tag { color: #34C8FF; }
+</style>
+</head>
+<body>
+<h1>Hashimg Duplicate Report</h1>
+<div class="summary">{{.Dir}} &middot; {{len .Groups}} groups &middot; {{.DupeCount}} duplicates &middot; {{time .CreatedAt}}</div>
+{{range $i, $g := .Groups}}
+<div class="group">
+<h2>Group {{inc $i}} <span>{{$g.Hash}}</span></h2>
+<div class="files">
+{{range $g.Files}}
+<div class="file{{if .Keeper}} keeper{{else if .Kept}} kept{{end}}">
+<div class="thumb">{{if .Thumbnail}}<img src="{{.Thumbnail}}" alt="{{base .Path}}">{{else}}<em>no preview</em>{{end}}</div>
+<div class="name" title="{{.Path}}">{{base .Path}}</div>
+<div class="tag">{{if .Keeper}}keep as {{$g.KeeperName}}{{else if .Kept}}kept{{else}}duplicate{{end}}{{if .Cached}} (cached){{end}}</div>
+<div class="meta">{{.Size}} bytes{{if .Width}} &middot; {{.Width}}&times;{{.Height}}{{end}}</div>
+<div class="meta">{{time .ModTime}}</div>
+</div>
+{{end}}
+</div>
+</div>
+{{end}}
+</body>
+</html>
+`))
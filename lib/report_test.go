@@ -0,0 +1,140 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReport(t *testing.T) {
+	const hashPrefix = "0x@"
+
+	t.Run("should error if images have not been processed", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		imgProcessor := NewImageProcessor(ImageProcessorConfig{
+			WorkingDir: t.TempDir(),
+			Prefix:     hashPrefix,
+			HashLength: hashLength,
+		})
+		_, err := imgProcessor.BuildReport(false)
+		a.ErrorIs(err, ErrNotProcessed)
+	})
+
+	t.Run("should write every duplicate to csv", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		dir := t.TempDir()
+		err := writeFiles(
+			OSFS{},
+			dir,
+			[]string{"t1.jpg", "t2.JPG", "t3.jpg", "t4.jpg", "t5.jpg"},
+			[]string{"0", "0", "1", "1", "2"},
+		)
+		require.NoError(t, err)
+
+		imgProcessor := processForReport(t, dir, false)
+		r := imgProcessor.Report
+		require.NotNil(t, r)
+		a.Len(r.Groups, 2)
+		a.Equal(2, r.DupeCount())
+
+		buf := bytes.Buffer{}
+		require.NoError(t, r.WriteCSV(&buf))
+
+		rows, err := csv.NewReader(&buf).ReadAll()
+		require.NoError(t, err)
+		require.Len(t, rows, 5, "header and one row per duplicate")
+		a.Equal(reportHeader, rows[0])
+
+		keepers := 0
+		for _, row := range rows[1:] {
+			a.Equal("1", row[5], "size should be recorded")
+			a.Equal(fmt.Sprintf("0x@%s.jpg", row[1]), row[10])
+			if row[2] == "true" {
+				keepers++
+			}
+		}
+		a.Equal(2, keepers, "every group should have one keeper")
+	})
+
+	t.Run("should embed thumbnails and dimensions in html", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		dir := t.TempDir()
+		require.NoError(t, writePNG(filepath.Join(dir, "t1.png"), 400, 200))
+		require.NoError(t, writePNG(filepath.Join(dir, "t2.png"), 400, 200))
+
+		imgProcessor := processForReport(t, dir, true)
+		r := imgProcessor.Report
+		require.NotNil(t, r)
+		require.Len(t, r.Groups, 1)
+
+		for _, f := range r.Groups[0].Files {
+			a.Equal(400, f.Width)
+			a.Equal(200, f.Height)
+			a.True(strings.HasPrefix(string(f.Thumbnail), "data:image/jpeg;base64,"))
+		}
+
+		buf := bytes.Buffer{}
+		require.NoError(t, r.WriteHTML(&buf))
+		html := buf.String()
+		a.Contains(html, "t1.png")
+		a.Contains(html, "t2.png")
+		a.Contains(html, "400&times;200")
+		a.Equal(2, strings.Count(html, `src="data:image/jpeg;base64,`))
+	})
+
+	t.Run("should scale thumbnails to fit", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		img := ScaleImage(image.NewRGBA(image.Rect(0, 0, 400, 200)), thumbnailSize, thumbnailSize)
+		a.Equal(thumbnailSize, img.Bounds().Dx())
+		a.Equal(thumbnailSize/2, img.Bounds().Dy())
+
+		img = ScaleImage(image.NewRGBA(image.Rect(0, 0, 400, 200)), 400, 50)
+		a.Equal(100, img.Bounds().Dx(), "height should limit the width")
+		a.Equal(50, img.Bounds().Dy())
+
+		img = ScaleImage(image.NewRGBA(image.Rect(0, 0, 20, 40)), thumbnailSize, thumbnailSize)
+		a.Equal(20, img.Bounds().Dx(), "small images should not be scaled")
+	})
+}
+
+func processForReport(t *testing.T, dir string, thumbnails bool) *ImageProcessor {
+	iMap, err := MapImages(dir, "0x@")
+	require.NoError(t, err)
+	imgProcessor := NewImageProcessor(ImageProcessorConfig{
+		WorkingDir:       dir,
+		Prefix:           "0x@",
+		ImageMap:         iMap,
+		HashLength:       hashLength,
+		CollectReport:    true,
+		ReportThumbnails: thumbnails,
+	})
+	require.NoError(t, imgProcessor.ProcessImages(false))
+	return imgProcessor
+}
+
+func writePNG(path string, width, height int) error {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := range width {
+		img.Set(x, x%height, color.RGBA{R: 255, A: 255})
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return png.Encode(file, img)
+}
@@ -0,0 +1,182 @@
+package lib
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// ReviewDecisions are the choices a user made while reviewing duplicates.
+type ReviewDecisions struct {
+	// Groups, by hash, where none of the dupes should be deleted
+	KeepGroups map[string]bool
+	// The path of the file that should be kept in a group, by hash
+	Keepers map[string]string
+	// Dupes, by path, that should not be deleted
+	KeepFiles map[string]bool
+}
+
+// ReviewSummary describes what will happen once decisions are applied.
+type ReviewSummary struct {
+	Groups         int
+	KeptGroups     int
+	ChangedKeepers int
+	KeptFiles      int
+	DeletedFiles   int
+	DeletedBytes   int64
+	// Dupes that go to the disposal folder are moved instead of deleted
+	MovedFiles int
+	MovedBytes int64
+}
+
+func NewReviewDecisions() ReviewDecisions {
+	return ReviewDecisions{
+		KeepGroups: map[string]bool{},
+		Keepers:    map[string]string{},
+		KeepFiles:  map[string]bool{},
+	}
+}
+
+/*
+KeeperPath returns the path of the file that will be kept in the group,
+taking any keeper the user picked into account.
+*/
+func (rd ReviewDecisions) KeeperPath(dg DupeGroup) string {
+	if path, ok := rd.Keepers[dg.Hash]; ok {
+		return path
+	}
+	keeper, _ := dg.Keeper()
+	return keeper.Path
+}
+
+// IsKept reports whether the file will survive once decisions are applied.
+func (rd ReviewDecisions) IsKept(dg DupeGroup, df DupeFile) bool {
+	return rd.KeepGroups[dg.Hash] || rd.KeepFiles[df.Path] || rd.KeeperPath(dg) == df.Path
+}
+
+/*
+Summarize tallies what applying the decisions to the groups will do.
+Dupes that aren't kept are counted as moved when moveDupes is set, like
+ImageProcessor.MovesDupes reports, and as deleted otherwise.
+*/
+func (rd ReviewDecisions) Summarize(groups []DupeGroup, moveDupes bool) ReviewSummary {
+	summary := ReviewSummary{Groups: len(groups)}
+	for _, g := range groups {
+		if rd.KeepGroups[g.Hash] {
+			summary.KeptGroups += 1
+		}
+		if keeper, ok := g.Keeper(); ok && rd.KeeperPath(g) != keeper.Path {
+			summary.ChangedKeepers += 1
+		}
+		for _, f := range g.Files {
+			switch {
+			case rd.KeeperPath(g) == f.Path:
+				continue
+			case rd.IsKept(g, f):
+				summary.KeptFiles += 1
+			case moveDupes:
+				summary.MovedFiles += 1
+				summary.MovedBytes += f.Size
+			default:
+				summary.DeletedFiles += 1
+				summary.DeletedBytes += f.Size
+			}
+		}
+	}
+	return summary
+}
+
+/*
+ApplyDecisions changes which files are kept when the images are updated,
+or restored from review. It must be called after processing and before
+updating or restoring the images.
+*/
+func (ip *ImageProcessor) ApplyDecisions(rd ReviewDecisions) error {
+	if ip.processedImages == nil {
+		return ErrNotProcessed
+	}
+
+	for hash, path := range rd.Keepers {
+		if err := ip.setKeeper(hash, path); err != nil {
+			return err
+		}
+	}
+
+	for hash, keep := range rd.KeepGroups {
+		if _, ok := ip.processedImages.DupeImagesByHash[hash]; !ok {
+			return fmt.Errorf("%w: %s", ErrUnknownDupe, hash)
+		}
+		ip.keptGroups[hash] = keep
+	}
+
+	for path, keep := range rd.KeepFiles {
+		ip.keptDupes[path] = keep
+	}
+
+	if err := ip.refreshReport(); err != nil {
+		return err
+	}
+
+	if ip.isReviewProcess {
+		return ip.saveReviewManifest()
+	}
+	return nil
+}
+
+/*
+setKeeper makes the file at path the novel image of its group. The
+keeper is always moved to index 0, so that the rest of the group
+are its dupes.
+*/
+func (ip *ImageProcessor) setKeeper(hash, path string) error {
+	pi := ip.processedImages
+	dupes, ok := pi.DupeImagesByHash[hash]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownDupe, hash)
+	}
+
+	idx := -1
+	for i, dupe := range dupes {
+		if dupe.path == path {
+			idx = i
+			break
+		}
+	}
+	if idx == -1 {
+		return fmt.Errorf("%w: %s", ErrUnknownDupe, path)
+	}
+
+	if dupes[idx].isNovel {
+		return nil
+	}
+
+	dupes[0].isNovel = false
+	dupes[idx].isNovel = true
+	dupes[0], dupes[idx] = dupes[idx], dupes[0]
+
+	if !ip.isReviewProcess {
+		return nil
+	}
+
+	// Review keepers are renamed after they've been restored
+	keeper := dupes[0]
+	keeper.path = filepath.Join(ip.WorkingDir, filepath.Base(keeper.reviewPath))
+	pi.NewImagesByHash[hash] = keeper
+
+	ip.NovelDupePaths = ip.NovelDupePaths[:0]
+	newImageCount := 0
+	for _, hi := range pi.NewImagesByHash {
+		if hi.reviewPath != "" {
+			ip.NovelDupePaths = append(ip.NovelDupePaths, hi.reviewPath)
+		}
+		if !hi.cached {
+			newImageCount += 1
+		}
+	}
+	ip.Status.NewImageCount = int32(newImageCount)
+
+	return nil
+}
+
+func (ip *ImageProcessor) isKept(hi HashInfo) bool {
+	return ip.keptGroups[hi.hash] || ip.keptDupes[hi.path]
+}
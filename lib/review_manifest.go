@@ -0,0 +1,299 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"strings"
+)
+
+// The manifest is hidden, so it doesn't get in the way of reviewing
+const reviewManifestName = ".hashimg-manifest.json"
+
+const (
+	// Restored and renamed to its hash when the review is finished
+	RoleKeeper ReviewRole = "keeper"
+	// Deleted when the review is finished
+	RoleDupe ReviewRole = "dupe"
+	// Restored to its original path when the review is finished
+	RoleKept ReviewRole = "kept"
+)
+
+type ReviewRole string
+
+/*
+ReviewManifest records every file moved into the dupe review folder, so
+that an interrupted review can be finished or rolled back, even after
+the process that started it is gone.
+*/
+type ReviewManifest struct {
+	WorkingDir string `json:"working_dir"`
+	Prefix     string `json:"prefix"`
+	// Dupes are moved here instead of being deleted, when set
+	DisposalFolder string                `json:"disposal_folder,omitempty"`
+	Files          []ReviewManifestEntry `json:"files"`
+}
+
+type ReviewManifestEntry struct {
+	OriginalPath string     `json:"original_path"`
+	ReviewPath   string     `json:"review_path"`
+	Hash         string     `json:"hash"`
+	Role         ReviewRole `json:"role"`
+	Cached       bool       `json:"cached"`
+}
+
+// LoadReviewManifest reads the manifest from a dupe review folder.
+func LoadReviewManifest(reviewFolder string) (*ReviewManifest, error) {
+	return loadReviewManifest(OSFS{}, reviewFolder)
+}
+
+func loadReviewManifest(fsys FS, reviewFolder string) (*ReviewManifest, error) {
+	data, err := fs.ReadFile(fsys, filepath.Join(reviewFolder, reviewManifestName))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrNoReviewManifest
+		}
+		return nil, err
+	}
+
+	rm := &ReviewManifest{}
+	if err := json.Unmarshal(data, rm); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidManifest, err)
+	}
+	if err := rm.validate(reviewFolder); err != nil {
+		return nil, err
+	}
+	return rm, nil
+}
+
+/*
+validate makes sure the manifest can only touch files of the directory
+being reviewed. The review folder is always a child of the working
+directory, so a manifest that points anywhere else was not written by
+a review of that directory and must not be trusted.
+*/
+func (rm *ReviewManifest) validate(reviewFolder string) error {
+	reviewFolder = filepath.Clean(reviewFolder)
+	workingDir := filepath.Dir(reviewFolder)
+	if filepath.Clean(rm.WorkingDir) != workingDir {
+		return fmt.Errorf("%w: working dir %q is not %q", ErrInvalidManifest, rm.WorkingDir, workingDir)
+	}
+	if strings.ContainsAny(rm.Prefix, `/\`) {
+		return fmt.Errorf("%w: prefix %q", ErrInvalidManifest, rm.Prefix)
+	}
+	if rm.DisposalFolder != "" && !inside(workingDir, rm.DisposalFolder) {
+		return fmt.Errorf("%w: disposal folder %q", ErrInvalidManifest, rm.DisposalFolder)
+	}
+
+	for _, entry := range rm.Files {
+		switch {
+		case !inside(workingDir, entry.OriginalPath):
+			return fmt.Errorf("%w: original path %q", ErrInvalidManifest, entry.OriginalPath)
+		case !inside(reviewFolder, entry.ReviewPath):
+			return fmt.Errorf("%w: review path %q", ErrInvalidManifest, entry.ReviewPath)
+		case strings.ContainsAny(entry.Hash, `/\`):
+			return fmt.Errorf("%w: hash %q", ErrInvalidManifest, entry.Hash)
+		}
+	}
+	return nil
+}
+
+// inside reports whether path is inside dir, but not dir itself
+func inside(dir, path string) bool {
+	rel, ok := within(filepath.Clean(dir), filepath.Clean(path))
+	return ok && rel != ""
+}
+
+/*
+ResumeReview finishes an interrupted review: keepers are renamed to
+their hash in the working directory, kept dupes are restored to their
+original paths and the remaining dupes are deleted along with the
+review folder, or moved to the disposal folder. Every file operation is
+logged to logger, when it's set.
+*/
+func ResumeReview(reviewFolder string, logger *slog.Logger) error {
+	return resumeReview(OSFS{}, orDiscard(logger), reviewFolder)
+}
+
+func resumeReview(fsys FS, log *slog.Logger, reviewFolder string) error {
+	rm, err := loadReviewManifest(fsys, reviewFolder)
+	if err != nil {
+		return err
+	}
+
+	keepers := map[string]bool{}
+	keeperPaths := map[string]bool{}
+	for _, entry := range rm.Files {
+		if entry.Role != RoleKeeper {
+			continue
+		}
+		ext := strings.ToLower(filepath.Ext(entry.OriginalPath))
+		target := filepath.Join(rm.WorkingDir, rm.Prefix+entry.Hash+ext)
+		found, err := restoreEntry(fsys, log, entry, target)
+		if err != nil {
+			return err
+		}
+		keepers[entry.Hash] = found
+		keeperPaths[target] = true
+	}
+
+	for _, entry := range rm.Files {
+		switch entry.Role {
+		case RoleKept:
+			if _, err := restoreEntry(fsys, log, entry, entry.OriginalPath); err != nil {
+				return err
+			}
+
+		case RoleDupe:
+			// Never delete a dupe unless its keeper is safe
+			if !keepers[entry.Hash] {
+				return fmt.Errorf(
+					"%w: keeper for %s is missing",
+					ErrReviewIncomplete,
+					entry.Hash,
+				)
+			}
+			if err := disposeEntry(fsys, log, entry, rm.DisposalFolder, keeperPaths); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := fsys.RemoveAll(reviewFolder); err != nil {
+		return err
+	}
+	log.Info("review resumed", "dir", rm.WorkingDir)
+	return nil
+}
+
+/*
+RollbackReview undoes an interrupted review by moving every file back to
+the path it had before the review started, then deletes the review
+folder.
+*/
+func RollbackReview(reviewFolder string, logger *slog.Logger) error {
+	return rollbackReview(OSFS{}, orDiscard(logger), reviewFolder)
+}
+
+func rollbackReview(fsys FS, log *slog.Logger, reviewFolder string) error {
+	rm, err := loadReviewManifest(fsys, reviewFolder)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range rm.Files {
+		if _, err := restoreEntry(fsys, log, entry, entry.OriginalPath); err != nil {
+			return err
+		}
+	}
+
+	if err := fsys.RemoveAll(reviewFolder); err != nil {
+		return err
+	}
+	log.Info("review rolled back", "dir", rm.WorkingDir)
+	return nil
+}
+
+/*
+disposeEntry disposes of a dupe, wherever the review left it. Dupes in
+the review folder are only moved, since they're deleted along with the
+folder anyway.
+*/
+func disposeEntry(
+	fsys FS,
+	log *slog.Logger,
+	entry ReviewManifestEntry,
+	disposalFolder string,
+	keeperPaths map[string]bool,
+) error {
+	log = log.With("hash", entry.Hash)
+	name := filepath.Base(entry.OriginalPath)
+	if disposalFolder != "" {
+		err := disposeFile(fsys, log, entry.ReviewPath, name, disposalFolder)
+		if err == nil || !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	// A cached dupe can share its name with a restored keeper
+	if keeperPaths[entry.OriginalPath] {
+		return nil
+	}
+	// Dupes that never made it into the folder are still disposed of
+	err := disposeFile(fsys, log, entry.OriginalPath, name, disposalFolder)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+/*
+restoreEntry moves the file in the review folder to target. The file is
+considered found if it was already at the target or never left its
+original path.
+*/
+func restoreEntry(fsys FS, log *slog.Logger, entry ReviewManifestEntry, target string) (bool, error) {
+	log = log.With("target", target, "hash", entry.Hash)
+	if _, err := fsys.Stat(entry.ReviewPath); err == nil {
+		err := fsys.Rename(entry.ReviewPath, target)
+		if errors.Is(err, fs.ErrExist) {
+			err = fmt.Errorf("%w: %s already exists", ErrReviewIncomplete, target)
+		}
+		logResult(log.With("path", entry.ReviewPath), "restored", "restore failed", err)
+		return err == nil, err
+	}
+
+	for _, path := range []string{target, entry.OriginalPath} {
+		if _, err := fsys.Stat(path); err == nil {
+			if path != target {
+				err := fsys.Rename(path, target)
+				logResult(log.With("path", path), "restored", "restore failed", err)
+				return true, err
+			}
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+/*
+saveReviewManifest records the current state of the review. It's written
+in a single step, so a crash can't leave a partial manifest.
+*/
+func (ip *ImageProcessor) saveReviewManifest() error {
+	rm := ReviewManifest{
+		WorkingDir:     ip.WorkingDir,
+		Prefix:         ip.hashPrefix,
+		DisposalFolder: ip.disposalFolder,
+	}
+
+	for _, dupes := range ip.processedImages.DupeImagesByHash {
+		for _, dupe := range dupes {
+			entry := ReviewManifestEntry{
+				OriginalPath: dupe.path,
+				ReviewPath:   dupe.reviewPath,
+				Hash:         dupe.hash,
+				Role:         RoleDupe,
+				Cached:       dupe.cached,
+			}
+			if dupe.isNovel {
+				entry.Role = RoleKeeper
+			} else if ip.isKept(dupe) {
+				entry.Role = RoleKept
+			}
+			rm.Files = append(rm.Files, entry)
+		}
+	}
+
+	data, err := json.MarshalIndent(rm, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(ip.dupeReviewFolder, reviewManifestName)
+	return ip.fs.WriteFile(path, data, 0o600)
+}
@@ -0,0 +1,191 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReviewManifest(t *testing.T) {
+	const hashPrefix = "0x@"
+
+	files := []string{
+		"t1.jpg",
+		"t2.jpg",
+		"t3.PNG",
+		"t4.png",
+		fmt.Sprintf("0x@%s.jpg", calcSha256("2")),
+		"t6.jpg",
+		"t7.jpg",
+	}
+	content := []string{"0", "0", "1", "1", "2", "2", "3"}
+
+	startReview := func(t *testing.T) (string, *ImageProcessor) {
+		dir := writeTempFiles(t, files, content)
+		iMap, err := MapImages(dir, hashPrefix)
+		require.NoError(t, err)
+		imgProcessor := NewImageProcessor(ImageProcessorConfig{
+			WorkingDir: dir,
+			Prefix:     hashPrefix,
+			ImageMap:   iMap,
+			HashLength: hashLength,
+		})
+		require.NoError(t, imgProcessor.ProcessImagesForReview(false))
+		return dir, imgProcessor
+	}
+
+	t.Run("should error without a manifest", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		dir := t.TempDir()
+		a.ErrorIs(ResumeReview(dir, nil), ErrNoReviewManifest)
+		a.ErrorIs(RollbackReview(dir, nil), ErrNoReviewManifest)
+	})
+
+	t.Run("should record every moved file", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		dir, _ := startReview(t)
+
+		rm, err := LoadReviewManifest(filepath.Join(dir, "__dupes"))
+		require.NoError(t, err)
+		a.Equal(dir, rm.WorkingDir)
+		a.Equal(hashPrefix, rm.Prefix)
+		a.Len(rm.Files, 6)
+
+		roles := map[ReviewRole]int{}
+		for _, entry := range rm.Files {
+			roles[entry.Role]++
+			a.FileExists(entry.ReviewPath)
+			a.NoFileExists(entry.OriginalPath)
+		}
+		a.Equal(3, roles[RoleKeeper])
+		a.Equal(3, roles[RoleDupe])
+	})
+
+	t.Run("should resume an interrupted review", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		dir, imgProcessor := startReview(t)
+
+		groups := mustDupeGroups(t, imgProcessor)
+		kept := nonKeeper(groups, calcSha256("1"))
+		rd := NewReviewDecisions()
+		rd.KeepFiles[kept] = true
+		require.NoError(t, imgProcessor.ApplyDecisions(rd))
+
+		require.NoError(t, ResumeReview(filepath.Join(dir, "__dupes"), nil))
+
+		fileNames, err := readDir(OSFS{}, dir)
+		require.NoError(t, err)
+		sort.Strings(fileNames)
+		expected := []string{
+			fmt.Sprintf("0x@%s.jpg", calcSha256("0")),
+			fmt.Sprintf("0x@%s.png", calcSha256("1")),
+			fmt.Sprintf("0x@%s.jpg", calcSha256("2")),
+			filepath.Base(kept),
+			"t7.jpg",
+		}
+		sort.Strings(expected)
+		a.Equal(expected, fileNames)
+	})
+
+	t.Run("should roll back an interrupted review", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		dir, _ := startReview(t)
+
+		require.NoError(t, RollbackReview(filepath.Join(dir, "__dupes"), nil))
+
+		fileNames, err := readDir(OSFS{}, dir)
+		require.NoError(t, err)
+		a.ElementsMatch(files, fileNames)
+		for i, file := range files {
+			data, err := os.ReadFile(filepath.Join(dir, file))
+			require.NoError(t, err)
+			a.Equal(content[i], string(data))
+		}
+	})
+
+	t.Run("should finish moves that never happened", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		dir, _ := startReview(t)
+		reviewFolder := filepath.Join(dir, "__dupes")
+
+		// Simulate a crash before the files were moved
+		rm, err := LoadReviewManifest(reviewFolder)
+		require.NoError(t, err)
+		for _, entry := range rm.Files {
+			require.NoError(t, os.Rename(entry.ReviewPath, entry.OriginalPath))
+		}
+
+		require.NoError(t, ResumeReview(reviewFolder, nil))
+
+		fileNames, err := readDir(OSFS{}, dir)
+		require.NoError(t, err)
+		a.Len(fileNames, 4, "3 keepers and 1 unique image")
+		a.NoDirExists(reviewFolder)
+	})
+
+	t.Run("should reject manifests outside the review", func(t *testing.T) {
+		t.Parallel()
+		outside := t.TempDir()
+		victim := filepath.Join(outside, "victim.jpg")
+		require.NoError(t, os.WriteFile(victim, []byte("0"), 0o600))
+
+		tests := map[string]func(t *testing.T, dir string, rm *ReviewManifest){
+			"working dir": func(_ *testing.T, _ string, rm *ReviewManifest) {
+				rm.WorkingDir = outside
+			},
+			"original path": func(_ *testing.T, _ string, rm *ReviewManifest) {
+				rm.Files[0].OriginalPath = victim
+			},
+			"review path": func(_ *testing.T, _ string, rm *ReviewManifest) {
+				rm.Files[0].ReviewPath = victim
+			},
+			"review path in working dir": func(t *testing.T, dir string, rm *ReviewManifest) {
+				rm.Files[0].ReviewPath = filepath.Join(dir, "t7.jpg")
+			},
+			"relative original path": func(t *testing.T, dir string, rm *ReviewManifest) {
+				rel, err := filepath.Rel(dir, victim)
+				require.NoError(t, err)
+				rm.Files[0].OriginalPath = dir + string(filepath.Separator) + rel
+			},
+			"disposal folder": func(_ *testing.T, _ string, rm *ReviewManifest) {
+				rm.DisposalFolder = outside
+			},
+			"hash": func(_ *testing.T, _ string, rm *ReviewManifest) {
+				rm.Files[0].Hash = filepath.Join("..", "..", "victim")
+			},
+		}
+
+		for name, tamper := range tests {
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+				a := assert.New(t)
+				dir, _ := startReview(t)
+				reviewFolder := filepath.Join(dir, "__dupes")
+
+				rm, err := LoadReviewManifest(reviewFolder)
+				require.NoError(t, err)
+				tamper(t, dir, rm)
+				data, err := json.Marshal(rm)
+				require.NoError(t, err)
+				manifestPath := filepath.Join(reviewFolder, reviewManifestName)
+				require.NoError(t, os.WriteFile(manifestPath, data, 0o600))
+
+				a.ErrorIs(ResumeReview(reviewFolder, nil), ErrInvalidManifest)
+				a.ErrorIs(RollbackReview(reviewFolder, nil), ErrInvalidManifest)
+				a.FileExists(victim)
+				a.DirExists(reviewFolder)
+			})
+		}
+	})
+}
@@ -0,0 +1,226 @@
+package lib
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReviewDecisions(t *testing.T) {
+	const hashPrefix = "0x@"
+
+	newProcessor := func(t *testing.T, fsys FS, dir string) *ImageProcessor {
+		iMap, err := MapImagesWithConfig(MapperConfig{Dir: dir, Prefix: hashPrefix, FS: fsys})
+		require.NoError(t, err)
+		return NewImageProcessor(ImageProcessorConfig{
+			WorkingDir: dir,
+			Prefix:     hashPrefix,
+			ImageMap:   iMap,
+			HashLength: hashLength,
+			FS:         fsys,
+		})
+	}
+
+	t.Run("should error if images have not been processed", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		fsys, dir := writeMemFiles(t, []string{"t1.jpg"}, []string{"0"})
+		imgProcessor := newProcessor(t, fsys, dir)
+		a.ErrorIs(imgProcessor.ApplyDecisions(NewReviewDecisions()), ErrNotProcessed)
+	})
+
+	t.Run("should error on unknown keeper", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		fsys, dir := writeMemFiles(t, []string{"t1.jpg", "t2.jpg"}, []string{"0", "0"})
+		imgProcessor := newProcessor(t, fsys, dir)
+		require.NoError(t, imgProcessor.ProcessImages(false))
+
+		rd := NewReviewDecisions()
+		rd.Keepers[calcSha256("0")] = filepath.Join(dir, "missing.jpg")
+		a.ErrorIs(imgProcessor.ApplyDecisions(rd), ErrUnknownDupe)
+
+		rd = NewReviewDecisions()
+		rd.KeepGroups["missing"] = true
+		a.ErrorIs(imgProcessor.ApplyDecisions(rd), ErrUnknownDupe)
+	})
+
+	t.Run("should not delete kept files and groups", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		fsys, dir := writeMemFiles(
+			t,
+			[]string{"t1.jpg", "t2.jpg", "t3.jpg", "t4.jpg", "t5.jpg"},
+			[]string{"0", "0", "0", "1", "1"},
+		)
+		imgProcessor := newProcessor(t, fsys, dir)
+		require.NoError(t, imgProcessor.ProcessImages(false))
+
+		groups, err := imgProcessor.DupeGroups()
+		require.NoError(t, err)
+		require.Len(t, groups, 2)
+
+		rd := NewReviewDecisions()
+		kept := nonKeeper(groups, calcSha256("0"))
+		rd.KeepFiles[kept] = true
+		rd.KeepGroups[calcSha256("1")] = true
+
+		summary := rd.Summarize(groups, false)
+		a.Equal(1, summary.KeptGroups)
+		a.Equal(2, summary.KeptFiles)
+		a.Equal(1, summary.DeletedFiles)
+		a.Equal(int64(1), summary.DeletedBytes)
+		a.Zero(summary.MovedBytes)
+
+		summary = rd.Summarize(groups, true)
+		a.Zero(summary.DeletedFiles)
+		a.Zero(summary.DeletedBytes)
+		a.Equal(1, summary.MovedFiles)
+		a.Equal(int64(1), summary.MovedBytes)
+
+		require.NoError(t, imgProcessor.ApplyDecisions(rd))
+		require.NoError(t, imgProcessor.UpdateImages())
+
+		a.Equal(int32(1), imgProcessor.Status.DupeImageCount)
+		a.Equal(int32(2), imgProcessor.Status.KeptDupeCount)
+
+		fileNames, err := readDir(fsys, dir)
+		require.NoError(t, err)
+		a.Len(fileNames, 4)
+		a.Contains(fileNames, filepath.Base(kept), "kept dupe should be untouched")
+		a.Contains(fileNames, fmt.Sprintf("0x@%s.jpg", calcSha256("0")))
+		a.Contains(fileNames, fmt.Sprintf("0x@%s.jpg", calcSha256("1")))
+	})
+
+	t.Run("should rename the chosen keeper", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		cachedName := fmt.Sprintf("0x@%s.png", calcSha256("0"))
+		fsys, dir := writeMemFiles(t, []string{cachedName, "t2.jpg"}, []string{"0", "0"})
+		imgProcessor := newProcessor(t, fsys, dir)
+		require.NoError(t, imgProcessor.ProcessImages(false))
+
+		rd := NewReviewDecisions()
+		rd.Keepers[calcSha256("0")] = filepath.Join(dir, "t2.jpg")
+		a.Equal(1, rd.Summarize(mustDupeGroups(t, imgProcessor), false).ChangedKeepers)
+
+		require.NoError(t, imgProcessor.ApplyDecisions(rd))
+		require.NoError(t, imgProcessor.UpdateImages())
+
+		fileNames, err := readDir(fsys, dir)
+		require.NoError(t, err)
+		a.Equal([]string{fmt.Sprintf("0x@%s.jpg", calcSha256("0"))}, fileNames)
+		a.Equal(int32(1), imgProcessor.Status.DupeImageCount)
+	})
+
+	t.Run("should apply decisions to the report", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		fsys, dir := writeMemFiles(
+			t,
+			[]string{"t1.jpg", "t2.jpg", "t3.jpg"},
+			[]string{"0", "0", "0"},
+		)
+		iMap, err := MapImagesWithConfig(MapperConfig{Dir: dir, Prefix: hashPrefix, FS: fsys})
+		require.NoError(t, err)
+		imgProcessor := NewImageProcessor(ImageProcessorConfig{
+			WorkingDir:    dir,
+			Prefix:        hashPrefix,
+			ImageMap:      iMap,
+			HashLength:    hashLength,
+			CollectReport: true,
+			FS:            fsys,
+		})
+		require.NoError(t, imgProcessor.ProcessImages(false))
+		require.Equal(t, 2, imgProcessor.Report.DupeCount())
+
+		groups := mustDupeGroups(t, imgProcessor)
+		keeper := nonKeeper(groups, calcSha256("0"))
+		rd := NewReviewDecisions()
+		rd.Keepers[calcSha256("0")] = keeper
+		require.NoError(t, imgProcessor.ApplyDecisions(rd))
+
+		kept := nonKeeper(mustDupeGroups(t, imgProcessor), calcSha256("0"))
+		rd.KeepFiles[kept] = true
+		require.NoError(t, imgProcessor.ApplyDecisions(rd))
+
+		r := imgProcessor.Report
+		require.Len(t, r.Groups, 1)
+		a.Equal(1, r.DupeCount())
+		for _, f := range r.Groups[0].Files {
+			a.Equal(f.Path == keeper, f.Keeper, "report should use the chosen keeper")
+			a.Equal(f.Path == kept, f.Kept, "report should mark kept dupes")
+		}
+	})
+
+	t.Run("should restore kept files from review", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		fsys, dir := writeMemFiles(
+			t,
+			[]string{"t1.jpg", "t2.jpg", "t3.jpg", "t4.jpg", "t5.jpg"},
+			[]string{"0", "0", "0", "1", "1"},
+		)
+		imgProcessor := newProcessor(t, fsys, dir)
+		require.NoError(t, imgProcessor.ProcessImagesForReview(false))
+
+		groups := mustDupeGroups(t, imgProcessor)
+		rd := NewReviewDecisions()
+		kept := nonKeeper(groups, calcSha256("0"))
+		rd.KeepFiles[kept] = true
+		rd.Keepers[calcSha256("1")] = nonKeeper(groups, calcSha256("1"))
+
+		require.NoError(t, imgProcessor.ApplyDecisions(rd))
+		require.NoError(t, imgProcessor.RestoreFromReview())
+		require.NoError(t, imgProcessor.UpdateImages())
+
+		a.Equal(int32(2), imgProcessor.Status.DupeImageCount)
+		a.Equal(int32(1), imgProcessor.Status.KeptDupeCount)
+		a.EqualValues(2, imgProcessor.Status.ReclaimedBytes, "should never count kept dupes")
+
+		fileNames, err := readDir(fsys, dir)
+		require.NoError(t, err)
+		a.Len(fileNames, 3)
+		a.Contains(fileNames, filepath.Base(kept))
+		a.Contains(fileNames, fmt.Sprintf("0x@%s.jpg", calcSha256("0")))
+		a.Contains(fileNames, fmt.Sprintf("0x@%s.jpg", calcSha256("1")))
+	})
+}
+
+func writeTempFiles(t *testing.T, files []string, fileContent []string) string {
+	dir := t.TempDir()
+	require.NoError(t, writeFiles(OSFS{}, dir, files, fileContent))
+	return dir
+}
+
+// writeMemFiles writes the files to a directory in a new MemFS
+func writeMemFiles(t *testing.T, files []string, fileContent []string) (*MemFS, string) {
+	fsys := NewMemFS()
+	dir := filepath.Join(string(filepath.Separator), "images")
+	require.NoError(t, fsys.MkdirAll(dir, 0o755))
+	require.NoError(t, writeFiles(fsys, dir, files, fileContent))
+	return fsys, dir
+}
+
+func mustDupeGroups(t *testing.T, ip *ImageProcessor) []DupeGroup {
+	groups, err := ip.DupeGroups()
+	require.NoError(t, err)
+	return groups
+}
+
+func nonKeeper(groups []DupeGroup, hash string) string {
+	for _, g := range groups {
+		if g.Hash != hash {
+			continue
+		}
+		for _, f := range g.Files {
+			if !f.Keeper {
+				return f.Path
+			}
+		}
+	}
+	return ""
+}
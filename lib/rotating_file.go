@@ -0,0 +1,104 @@
+package lib
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+/*
+RotatingFile appends to the file at path until it grows past maxSize,
+then renames it to path.1, shifting older files up to path.<backups>.
+The oldest file is replaced, so the files never take more than about
+(backups+1) * maxSize.
+*/
+type RotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	backups int
+	file    *os.File
+	size    int64
+}
+
+func OpenRotatingFile(path string, maxSize int64, backups int) (*RotatingFile, error) {
+	rf := &RotatingFile{path: path, maxSize: maxSize, backups: backups}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.file == nil {
+		return 0, os.ErrClosed
+	}
+	// A single write is never split, even when it's larger than maxSize
+	if rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.file == nil {
+		return nil
+	}
+	err := rf.file.Close()
+	rf.file = nil
+	return err
+}
+
+func (rf *RotatingFile) open() error {
+	file, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	rf.file = file
+	rf.size = info.Size()
+	return nil
+}
+
+func (rf *RotatingFile) rotate() error {
+	if err := rf.file.Close(); err != nil {
+		return err
+	}
+	rf.file = nil
+
+	for i := rf.backups; i > 0; i-- {
+		older := rf.backupPath(i - 1)
+		err := os.Rename(older, rf.backupPath(i))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if rf.backups == 0 {
+		if err := os.Remove(rf.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return rf.open()
+}
+
+// backupPath is the path of the nth backup, where 0 is the current file
+func (rf *RotatingFile) backupPath(n int) string {
+	if n == 0 {
+		return rf.path
+	}
+	return fmt.Sprintf("%s.%d", rf.path, n)
+}
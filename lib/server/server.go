@@ -0,0 +1,482 @@
+/*
+Package server runs scans and reviews over HTTP, for machines without a
+terminal. Every API request needs the token, either as a bearer token or
+as a token query parameter, because browsers can't set headers on event
+streams and images.
+*/
+package server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jaeiya/hashimg/lib"
+	"github.com/jaeiya/hashimg/lib/models"
+)
+
+// How often progress is sent to event streams
+const eventInterval = 250 * time.Millisecond
+
+type State string
+
+const (
+	StateIdle     State = "idle"
+	StateScanning State = "scanning"
+	// The dupes are waiting to be reviewed
+	StateScanned  State = "scanned"
+	StateUpdating State = "updating"
+	StateDone     State = "done"
+	StateFailed   State = "failed"
+)
+
+var (
+	ErrBusy       = errors.New("a scan or update is already running")
+	ErrNotScanned = errors.New("there is no scan to review")
+	ErrNoToken    = errors.New("the server has no token configured")
+	ErrClosed     = errors.New("the server is shutting down")
+)
+
+//go:embed web/index.html
+var indexHTML []byte
+
+type Config struct {
+	Mapper lib.MapperConfig
+	// The ImageMap is set for every scan
+	Processor lib.ImageProcessorConfig
+	IsHDD     bool
+	Token     string
+	// Called after every update that succeeded, like to record it
+	OnUpdate func(ip *lib.ImageProcessor, startedAt time.Time)
+}
+
+type Server struct {
+	cfg       Config
+	mux       sync.Mutex
+	state     State
+	err       error
+	processor *lib.ImageProcessor
+	startedAt time.Time
+	groups    []lib.DupeGroup
+	decisions lib.ReviewDecisions
+	// Tracks the running scan or update, so Close can wait for it
+	work   sync.WaitGroup
+	closed bool
+}
+
+type statusResponse struct {
+	State    State            `json:"state"`
+	Error    string           `json:"error,omitempty"`
+	Progress *models.Progress `json:"progress,omitempty"`
+	// Only set while nothing is running, because it isn't safe to
+	// read while working
+	Status *models.ProcessStatus `json:"status,omitempty"`
+}
+
+type groupResponse struct {
+	Hash       string         `json:"hash"`
+	KeeperName string         `json:"keeper_name"`
+	Files      []fileResponse `json:"files"`
+}
+
+type fileResponse struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Cached  bool      `json:"cached"`
+	Keeper  bool      `json:"keeper"`
+}
+
+type decisionsRequest struct {
+	// Hashes of groups where nothing is deleted
+	KeepGroups []string `json:"keep_groups"`
+	// Paths of the files to keep instead, by hash
+	Keepers map[string]string `json:"keepers"`
+	// Paths of dupes that are not deleted
+	KeepFiles []string `json:"keep_files"`
+}
+
+type summaryResponse struct {
+	Groups         int   `json:"groups"`
+	KeptGroups     int   `json:"kept_groups"`
+	ChangedKeepers int   `json:"changed_keepers"`
+	KeptFiles      int   `json:"kept_files"`
+	DeletedFiles   int   `json:"deleted_files"`
+	DeletedBytes   int64 `json:"deleted_bytes"`
+	MovedFiles     int   `json:"moved_files"`
+	MovedBytes     int64 `json:"moved_bytes"`
+}
+
+func New(cfg Config) *Server {
+	return &Server{cfg: cfg, state: StateIdle, decisions: lib.NewReviewDecisions()}
+}
+
+// NewToken returns a random token that's hard to guess.
+func NewToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+/*
+Handler serves the web UI and the API:
+
+	GET  /api/status                        state and progress
+	GET  /api/events                        progress as server-sent events
+	POST /api/scan                          start hashing the images
+	GET  /api/groups                        dupe groups of the last scan
+	GET  /api/groups/{hash}/files/{i}/thumbnail
+	POST /api/decisions                     review decisions, which are
+	                                        applied when updating
+	POST /api/update                        dispose of dupes and rename
+*/
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	// The page holds no data, the token is read from the URL fragment
+	mux.HandleFunc("GET /{$}", s.handleIndex)
+	mux.Handle("GET /api/status", s.auth(s.handleStatus))
+	mux.Handle("GET /api/events", s.auth(s.handleEvents))
+	mux.Handle("POST /api/scan", s.auth(s.handleScan))
+	mux.Handle("GET /api/groups", s.auth(s.handleGroups))
+	mux.Handle("GET /api/groups/{hash}/files/{index}/thumbnail", s.auth(s.handleThumbnail))
+	mux.Handle("POST /api/decisions", s.auth(s.handleDecisions))
+	mux.Handle("POST /api/update", s.auth(s.handleUpdate))
+	return mux
+}
+
+func (s *Server) auth(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Query().Get("token")
+		if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			token = bearer
+		}
+		// Without a configured token, an empty one would match and open the api
+		if s.cfg.Token == "" {
+			writeError(w, http.StatusUnauthorized, ErrNoToken)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.Token)) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("invalid token"))
+			return
+		}
+		next(w, r)
+	})
+}
+
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write(indexHTML)
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.status())
+}
+
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	ticker := time.NewTicker(eventInterval)
+	defer ticker.Stop()
+	for {
+		data, err := json.Marshal(s.status())
+		if err != nil {
+			return
+		}
+		if _, err := fmt.Fprintf(w, "event: status\ndata: %s\n\n", data); err != nil {
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) handleScan(w http.ResponseWriter, r *http.Request) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.closed {
+		writeError(w, http.StatusServiceUnavailable, ErrClosed)
+		return
+	}
+	if s.state == StateScanning || s.state == StateUpdating {
+		writeError(w, http.StatusConflict, ErrBusy)
+		return
+	}
+
+	iMap, err := lib.MapImagesWithConfig(s.cfg.Mapper)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, lib.ErrNoImages) {
+			status = http.StatusUnprocessableEntity
+		}
+		writeError(w, status, err)
+		return
+	}
+
+	ipCfg := s.cfg.Processor
+	ipCfg.ImageMap = iMap
+	s.processor = lib.NewImageProcessor(ipCfg)
+	s.startedAt = time.Now()
+	s.groups = nil
+	s.decisions = lib.NewReviewDecisions()
+	s.setState(StateScanning, nil)
+	s.work.Add(1)
+	go s.scan(s.processor)
+
+	writeJSON(w, http.StatusAccepted, s.statusLocked())
+}
+
+func (s *Server) scan(ip *lib.ImageProcessor) {
+	defer s.work.Done()
+	err := ip.ProcessImages(s.cfg.IsHDD)
+	var groups []lib.DupeGroup
+	if err == nil {
+		groups, err = ip.DupeGroups()
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.groups = groups
+	s.setState(StateScanned, err)
+}
+
+func (s *Server) handleGroups(w http.ResponseWriter, r *http.Request) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.state != StateScanned {
+		writeError(w, http.StatusConflict, ErrNotScanned)
+		return
+	}
+
+	groups := make([]groupResponse, 0, len(s.groups))
+	for _, g := range s.groups {
+		gr := groupResponse{Hash: g.Hash, KeeperName: s.processor.KeeperName(g)}
+		for _, f := range g.Files {
+			gr.Files = append(gr.Files, fileResponse{
+				Path:    f.Path,
+				Size:    f.Size,
+				ModTime: f.ModTime,
+				Cached:  f.Cached,
+				Keeper:  f.Keeper,
+			})
+		}
+		groups = append(groups, gr)
+	}
+	writeJSON(w, http.StatusOK, groups)
+}
+
+func (s *Server) handleThumbnail(w http.ResponseWriter, r *http.Request) {
+	file, err := s.findFile(r.PathValue("hash"), r.PathValue("index"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	mime, data, ok := lib.Thumbnail(s.cfg.Processor.FS, file)
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("no preview available"))
+		return
+	}
+	w.Header().Set("Content-Type", mime)
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	_, _ = w.Write(data)
+}
+
+func (s *Server) findFile(hash, index string) (lib.DupeFile, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.state != StateScanned {
+		return lib.DupeFile{}, ErrNotScanned
+	}
+
+	i, err := strconv.Atoi(index)
+	if err != nil {
+		return lib.DupeFile{}, lib.ErrUnknownDupe
+	}
+	for _, g := range s.groups {
+		if g.Hash == hash && i >= 0 && i < len(g.Files) {
+			return g.Files[i], nil
+		}
+	}
+	return lib.DupeFile{}, lib.ErrUnknownDupe
+}
+
+/*
+handleDecisions replaces the review decisions and returns what applying
+them will do. Nothing changes on disk until updating.
+*/
+func (s *Server) handleDecisions(w http.ResponseWriter, r *http.Request) {
+	req := decisionsRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.state != StateScanned {
+		writeError(w, http.StatusConflict, ErrNotScanned)
+		return
+	}
+
+	rd, err := s.newDecisions(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	s.decisions = rd
+
+	summary := rd.Summarize(s.groups, s.processor.MovesDupes())
+	writeJSON(w, http.StatusOK, summaryResponse{
+		Groups:         summary.Groups,
+		KeptGroups:     summary.KeptGroups,
+		ChangedKeepers: summary.ChangedKeepers,
+		KeptFiles:      summary.KeptFiles,
+		DeletedFiles:   summary.DeletedFiles,
+		DeletedBytes:   summary.DeletedBytes,
+		MovedFiles:     summary.MovedFiles,
+		MovedBytes:     summary.MovedBytes,
+	})
+}
+
+// newDecisions checks that every hash and path belongs to a dupe group
+func (s *Server) newDecisions(req decisionsRequest) (lib.ReviewDecisions, error) {
+	groups := map[string]lib.DupeGroup{}
+	paths := map[string]string{}
+	for _, g := range s.groups {
+		groups[g.Hash] = g
+		for _, f := range g.Files {
+			paths[f.Path] = g.Hash
+		}
+	}
+
+	rd := lib.NewReviewDecisions()
+	for _, hash := range req.KeepGroups {
+		if _, ok := groups[hash]; !ok {
+			return rd, fmt.Errorf("%w: %s", lib.ErrUnknownDupe, hash)
+		}
+		rd.KeepGroups[hash] = true
+	}
+	for hash, path := range req.Keepers {
+		if paths[path] != hash {
+			return rd, fmt.Errorf("%w: %s", lib.ErrUnknownDupe, path)
+		}
+		rd.Keepers[hash] = path
+	}
+	for _, path := range req.KeepFiles {
+		if _, ok := paths[path]; !ok {
+			return rd, fmt.Errorf("%w: %s", lib.ErrUnknownDupe, path)
+		}
+		rd.KeepFiles[path] = true
+	}
+	return rd, nil
+}
+
+func (s *Server) handleUpdate(w http.ResponseWriter, r *http.Request) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.closed {
+		writeError(w, http.StatusServiceUnavailable, ErrClosed)
+		return
+	}
+	if s.state != StateScanned {
+		writeError(w, http.StatusConflict, ErrNotScanned)
+		return
+	}
+
+	if err := s.processor.ApplyDecisions(s.decisions); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.setState(StateUpdating, nil)
+	s.work.Add(1)
+	go s.update(s.processor, s.startedAt)
+
+	writeJSON(w, http.StatusAccepted, s.statusLocked())
+}
+
+func (s *Server) update(ip *lib.ImageProcessor, startedAt time.Time) {
+	defer s.work.Done()
+	err := ip.UpdateImages()
+	if err == nil && s.cfg.OnUpdate != nil {
+		s.cfg.OnUpdate(ip, startedAt)
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.groups = nil
+	s.setState(StateDone, err)
+}
+
+/*
+Close refuses new scans and updates, then waits for the running one to
+finish, so an update isn't cut off halfway through moving files.
+*/
+func (s *Server) Close() {
+	s.mux.Lock()
+	s.closed = true
+	s.mux.Unlock()
+	s.work.Wait()
+}
+
+// setState fails instead, when there's an error
+func (s *Server) setState(state State, err error) {
+	if err != nil {
+		state = StateFailed
+	}
+	s.state = state
+	s.err = err
+}
+
+func (s *Server) status() statusResponse {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.statusLocked()
+}
+
+func (s *Server) statusLocked() statusResponse {
+	resp := statusResponse{State: s.state}
+	if s.err != nil {
+		resp.Error = s.err.Error()
+	}
+	if s.processor == nil {
+		return resp
+	}
+	progress := s.processor.Status.Progress()
+	resp.Progress = &progress
+	if s.state != StateScanning && s.state != StateUpdating {
+		resp.Status = s.processor.Status
+	}
+	return resp
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,216 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaeiya/hashimg/lib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testToken = "secret"
+
+func newTestServer(t *testing.T) (string, *httptest.Server) {
+	dir, _, ts := newTestAPI(t)
+	return dir, ts
+}
+
+func newTestAPI(t *testing.T) (string, *Server, *httptest.Server) {
+	dir := t.TempDir()
+	img := bytes.Buffer{}
+	require.NoError(t, png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 4, 4))))
+	files := map[string][]byte{
+		"a.png": img.Bytes(),
+		"b.png": img.Bytes(),
+		"c.jpg": []byte("novel"),
+	}
+	for name, data := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), data, 0o600))
+	}
+
+	s := New(Config{
+		Mapper: lib.MapperConfig{Dir: dir, Prefix: "0x@"},
+		Processor: lib.ImageProcessorConfig{
+			WorkingDir: dir,
+			Prefix:     "0x@",
+			HashLength: 32,
+		},
+		Token: testToken,
+	})
+	ts := httptest.NewServer(s.Handler())
+	t.Cleanup(ts.Close)
+	return dir, s, ts
+}
+
+func request(t *testing.T, ts *httptest.Server, method, path string, body any) *http.Response {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		require.NoError(t, err)
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, ts.URL+path, reader)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	res, err := ts.Client().Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { res.Body.Close() })
+	return res
+}
+
+func decode[T any](t *testing.T, res *http.Response) T {
+	var v T
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&v))
+	return v
+}
+
+func waitForState(t *testing.T, ts *httptest.Server, state State) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		status := decode[statusResponse](t, request(t, ts, "GET", "/api/status", nil))
+		require.Empty(t, status.Error)
+		if status.State == state {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("never reached state %s", state)
+}
+
+func TestServer(t *testing.T) {
+	t.Run("should require the token for the api", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		_, ts := newTestServer(t)
+
+		res, err := ts.Client().Get(ts.URL + "/api/status")
+		require.NoError(t, err)
+		res.Body.Close()
+		a.Equal(http.StatusUnauthorized, res.StatusCode)
+
+		res, err = ts.Client().Get(ts.URL + "/api/status?token=" + testToken)
+		require.NoError(t, err)
+		res.Body.Close()
+		a.Equal(http.StatusOK, res.StatusCode)
+
+		res, err = ts.Client().Get(ts.URL + "/")
+		require.NoError(t, err)
+		res.Body.Close()
+		a.Equal(http.StatusOK, res.StatusCode)
+	})
+
+	t.Run("should reject every request without a configured token", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		ts := httptest.NewServer(New(Config{}).Handler())
+		t.Cleanup(ts.Close)
+
+		for _, path := range []string{"/api/status", "/api/status?token="} {
+			res, err := ts.Client().Get(ts.URL + path)
+			require.NoError(t, err)
+			res.Body.Close()
+			a.Equal(http.StatusUnauthorized, res.StatusCode, path)
+		}
+
+		req, err := http.NewRequest("GET", ts.URL+"/api/status", nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer ")
+		res, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		res.Body.Close()
+		a.Equal(http.StatusUnauthorized, res.StatusCode)
+	})
+
+	t.Run("should scan, review and update", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		dir, ts := newTestServer(t)
+
+		res := request(t, ts, "GET", "/api/groups", nil)
+		a.Equal(http.StatusConflict, res.StatusCode)
+
+		res = request(t, ts, "POST", "/api/scan", nil)
+		require.Equal(t, http.StatusAccepted, res.StatusCode)
+		waitForState(t, ts, StateScanned)
+
+		groups := decode[[]groupResponse](t, request(t, ts, "GET", "/api/groups", nil))
+		require.Len(t, groups, 1)
+		require.Len(t, groups[0].Files, 2)
+
+		res = request(t, ts, "GET", "/api/groups/"+groups[0].Hash+"/files/0/thumbnail", nil)
+		a.Equal(http.StatusOK, res.StatusCode)
+		a.Equal("image/jpeg", res.Header.Get("Content-Type"))
+		res = request(t, ts, "GET", "/api/groups/"+groups[0].Hash+"/files/2/thumbnail", nil)
+		a.Equal(http.StatusNotFound, res.StatusCode)
+
+		res = request(t, ts, "POST", "/api/decisions", decisionsRequest{
+			Keepers: map[string]string{groups[0].Hash: filepath.Join(dir, "c.jpg")},
+		})
+		a.Equal(http.StatusBadRequest, res.StatusCode)
+
+		// Keeps the file that isn't the keeper yet
+		other := groups[0].Files[1].Path
+		if groups[0].Files[1].Keeper {
+			other = groups[0].Files[0].Path
+		}
+		res = request(t, ts, "POST", "/api/decisions", decisionsRequest{
+			Keepers: map[string]string{groups[0].Hash: other},
+		})
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		summary := decode[summaryResponse](t, res)
+		a.Equal(1, summary.ChangedKeepers)
+		a.Equal(1, summary.DeletedFiles)
+
+		res = request(t, ts, "POST", "/api/update", nil)
+		require.Equal(t, http.StatusAccepted, res.StatusCode)
+		waitForState(t, ts, StateDone)
+
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		a.Len(entries, 2)
+		for _, entry := range entries {
+			a.True(strings.HasPrefix(entry.Name(), "0x@"))
+		}
+		a.Equal(".png", filepath.Ext(groups[0].KeeperName))
+	})
+
+	t.Run("should stream progress", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		_, ts := newTestServer(t)
+
+		res := request(t, ts, "GET", "/api/events", nil)
+		a.Equal("text/event-stream", res.Header.Get("Content-Type"))
+		buf := make([]byte, 64)
+		n, err := io.ReadAtLeast(res.Body, buf, len("event: status\ndata: "))
+		require.NoError(t, err)
+		a.True(strings.HasPrefix(string(buf[:n]), "event: status\ndata: {\"state\":\"idle\""))
+	})
+
+	t.Run("should finish the running scan and refuse new ones when closed", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		_, s, ts := newTestAPI(t)
+
+		res := request(t, ts, "POST", "/api/scan", nil)
+		require.Equal(t, http.StatusAccepted, res.StatusCode)
+		s.Close()
+		a.Equal(StateScanned, s.status().State)
+
+		res = request(t, ts, "POST", "/api/scan", nil)
+		a.Equal(http.StatusServiceUnavailable, res.StatusCode)
+		res = request(t, ts, "POST", "/api/update", nil)
+		a.Equal(http.StatusServiceUnavailable, res.StatusCode)
+	})
+}
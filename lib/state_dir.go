@@ -0,0 +1,46 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+/*
+StateDir is where hashimg keeps what it wants to remember between runs,
+which is $XDG_STATE_HOME/hashimg. It defaults to ~/.local/state/hashimg,
+except on Windows and macOS, which keep it along with the data of other
+apps.
+*/
+func StateDir() (string, error) {
+	if dir, ok := xdgStateHome(); ok {
+		return filepath.Join(dir, "hashimg"), nil
+	}
+
+	switch runtime.GOOS {
+	case "windows":
+		dir, err := os.UserCacheDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, "hashimg"), nil
+	case "darwin":
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, "hashimg"), nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "state", "hashimg"), nil
+}
+
+// Relative paths are invalid, according to the XDG spec
+func xdgStateHome() (string, bool) {
+	dir := os.Getenv("XDG_STATE_HOME")
+	return dir, dir != "" && filepath.IsAbs(dir)
+}
@@ -0,0 +1,50 @@
+package lib
+
+import "path/filepath"
+
+// ImageStats counts the images in a directory, without hashing them.
+type ImageStats struct {
+	// Loose images, which include the videos
+	Images int   `json:"images"`
+	Cached int   `json:"cached"`
+	New    int   `json:"new"`
+	Stale  int   `json:"stale"`
+	Videos int   `json:"videos"`
+	Bytes  int64 `json:"bytes"`
+	// Images inside archives
+	Archived int `json:"archived"`
+}
+
+/*
+Stats counts the images in the map, which was mapped with cfg. Cached
+images are stale when they don't fit the hash length.
+*/
+func (im ImageMap) Stats(cfg MapperConfig, length int) (ImageStats, error) {
+	fsys := orOS(cfg.FS)
+	stats := ImageStats{}
+	for fileName, cs := range im {
+		if _, _, isArchived := splitArchived(fileName); isArchived {
+			stats.Archived += 1
+			continue
+		}
+
+		info, err := fsys.Stat(filepath.Join(cfg.Dir, fileName))
+		if err != nil {
+			return stats, err
+		}
+		stats.Images += 1
+		stats.Bytes += info.Size()
+		if IsVideo(fileName) {
+			stats.Videos += 1
+		}
+		switch {
+		case cs == NotCached:
+			stats.New += 1
+		case isHashName(cachedHash(fileName, cfg.Prefix), length):
+			stats.Cached += 1
+		default:
+			stats.Stale += 1
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,32 @@
+package lib
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImageStats(t *testing.T) {
+	const hashPrefix = "0x@"
+
+	t.Run("should count images without reading them", func(t *testing.T) {
+		t.Parallel()
+		fsys := NewMemFS()
+		dir := filepath.Join(string(filepath.Separator), "images")
+		require.NoError(t, fsys.MkdirAll(dir, 0o755))
+		cached := hashPrefix + calcSha256("1") + ".png"
+		stale := hashPrefix + calcSha256("3")[:10] + ".png"
+		err := writeFiles(fsys, dir, []string{cached, stale, "new.png", "clip.mp4"}, []string{"1", "22", "333", "4"})
+		require.NoError(t, err)
+
+		mapperCfg := MapperConfig{Dir: dir, Prefix: hashPrefix, IncludeVideos: true, FS: fsys}
+		iMap, err := MapImagesWithConfig(mapperCfg)
+		require.NoError(t, err)
+
+		stats, err := iMap.Stats(mapperCfg, hashLength)
+		require.NoError(t, err)
+		assert.Equal(t, ImageStats{Images: 4, Cached: 1, New: 2, Stale: 1, Videos: 1, Bytes: 7}, stats)
+	})
+}
@@ -0,0 +1,130 @@
+package ui
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/lipgloss/table"
+	"github.com/jaeiya/hashimg/lib"
+)
+
+const (
+	historyDateFormat = "2006-01-02 15:04"
+	// How many of the latest runs a trend shows
+	trendLength = 12
+)
+
+var (
+	sparkLevels = []rune("▁▂▃▄▅▆▇█")
+
+	tableHeaderStyle = lipgloss.NewStyle().
+				Bold(true).
+				Padding(0, 1).
+				Foreground(lipgloss.Color(whiteColor))
+	tableCellStyle = lipgloss.NewStyle().Padding(0, 1)
+)
+
+/*
+RenderHistory renders the totals of every run, then a table of every
+directory and one of the runs that reclaimed the most space.
+*/
+func RenderHistory(hs lib.HistoryStats) string {
+	s := fmt.Sprintf("\n%s\n\n", resultsHeaderStyle.Render("Hashimg History"))
+	if hs.Runs == 0 {
+		return s + footerStyle.Render("No runs yet") + "\n"
+	}
+
+	items := []ResultDisplayItem{
+		{"Runs", strconv.Itoa(hs.Runs), resultsTImagesStyle},
+		{"First Run", hs.FirstRun.Local().Format(historyDateFormat), resultsValueStyle},
+		{"Last Run", hs.LastRun.Local().Format(historyDateFormat), resultsValueStyle},
+		{"Images Scanned", strconv.FormatInt(hs.Images, 10), resultsTImagesStyle},
+		{"Dupes", strconv.FormatInt(hs.Dupes, 10), resultsDupeStyle},
+		{"New", strconv.FormatInt(hs.New, 10), resultsNewStyle},
+		{"Bytes Hashed", formatBytes(hs.HashedBytes), resultsValueStyle},
+		{"Space Reclaimed", formatBytes(hs.ReclaimedBytes), resultsValueStyle},
+		{"Total Time", formatDuration(hs.TotalTime), resultsTTimeStyle},
+	}
+	for _, item := range items {
+		s += fmt.Sprintf(
+			"%s %s\n",
+			resultsLabelStyle.Render(item.label),
+			item.valueStyle.Render(item.value),
+		)
+	}
+
+	dirs := newHistoryTable("Directory", "Runs", "Images", "Dupes", "Reclaimed", "Last Run", "Dupes Trend")
+	for _, dh := range hs.Dirs {
+		dirs.Row(
+			dh.Dir,
+			strconv.Itoa(dh.Runs),
+			formatImageTrend(dh.Trend),
+			strconv.FormatInt(dh.Dupes, 10),
+			formatBytes(dh.ReclaimedBytes),
+			dh.LastRun.Local().Format(historyDateFormat),
+			resultsDupeStyle.Render(sparkline(dh.Trend)),
+		)
+	}
+	s += "\n" + brightStyle.Render("Directories") + "\n" + baseStyle.Render(dirs.Render()) + "\n"
+
+	if len(hs.LargestSavings) == 0 {
+		return s
+	}
+	savings := newHistoryTable("Date", "Directory", "Dupes", "Reclaimed", "Took")
+	for _, rec := range hs.LargestSavings {
+		savings.Row(
+			rec.StartedAt.Local().Format(historyDateFormat),
+			rec.Dir,
+			strconv.Itoa(int(rec.Dupes)),
+			formatBytes(rec.ReclaimedBytes),
+			formatDuration(rec.TotalTime),
+		)
+	}
+	s += "\n" + brightStyle.Render("Largest Space Savings") + "\n" + baseStyle.Render(savings.Render()) + "\n"
+	return s
+}
+
+func newHistoryTable(headers ...string) *table.Table {
+	return table.New().
+		Border(lipgloss.NormalBorder()).
+		BorderStyle(lipgloss.NewStyle().Foreground(lipgloss.Color(borderColor))).
+		Headers(headers...).
+		StyleFunc(func(row, _ int) lipgloss.Style {
+			if row == table.HeaderRow {
+				return tableHeaderStyle
+			}
+			return tableCellStyle
+		})
+}
+
+// formatImageTrend is the image count of the last run, and how it changed since the first
+func formatImageTrend(trend []lib.TrendPoint) string {
+	first, last := trend[0].Images, trend[len(trend)-1].Images
+	if first == last {
+		return strconv.Itoa(int(last))
+	}
+	return fmt.Sprintf("%d %s", last, timeNotationStyle.Render(fmt.Sprintf("(%+d)", last-first)))
+}
+
+// sparkline draws the dupes found by the latest runs, relative to each other
+func sparkline(trend []lib.TrendPoint) string {
+	if len(trend) > trendLength {
+		trend = trend[len(trend)-trendLength:]
+	}
+	most := int32(0)
+	for _, p := range trend {
+		most = max(most, p.Dupes)
+	}
+
+	sb := strings.Builder{}
+	for _, p := range trend {
+		level := 0
+		if most > 0 {
+			level = int(p.Dupes) * (len(sparkLevels) - 1) / int(most)
+		}
+		sb.WriteRune(sparkLevels[level])
+	}
+	return sb.String()
+}
@@ -0,0 +1,284 @@
+package ui
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"strings"
+
+	"github.com/jaeiya/hashimg/lib"
+)
+
+const (
+	GraphicsAuto GraphicsProtocol = iota
+	GraphicsHalfBlock
+	GraphicsKitty
+	GraphicsITerm
+	GraphicsSixel
+)
+
+const (
+	// Most terminals don't report their cell size, so we assume
+	// a common one when sizing images for graphics protocols.
+	cellWidthPx  = 8
+	cellHeightPx = 16
+	// Kitty requires payloads to be sent in chunks of this size
+	kittyChunkSize = 4096
+	// The sixel palette is a 6x6x6 color cube
+	sixelLevels = 6
+)
+
+type GraphicsProtocol int
+
+var graphicsProtocolNames = map[string]GraphicsProtocol{
+	"auto":   GraphicsAuto,
+	"blocks": GraphicsHalfBlock,
+	"kitty":  GraphicsKitty,
+	"iterm":  GraphicsITerm,
+	"sixel":  GraphicsSixel,
+}
+
+// ParseGraphicsProtocol converts a protocol name from the CLI.
+func ParseGraphicsProtocol(name string) (GraphicsProtocol, error) {
+	p, ok := graphicsProtocolNames[strings.ToLower(name)]
+	if !ok {
+		return GraphicsAuto, fmt.Errorf("unknown preview protocol: %q", name)
+	}
+	return p, nil
+}
+
+/*
+DetectGraphicsProtocol guesses which inline image protocol the terminal
+supports from its environment. Terminals that can't be identified fall
+back to half-block characters, which work almost everywhere.
+*/
+func DetectGraphicsProtocol() GraphicsProtocol {
+	term := os.Getenv("TERM")
+	termProgram := os.Getenv("TERM_PROGRAM")
+
+	switch {
+	case os.Getenv("KITTY_WINDOW_ID") != "",
+		term == "xterm-kitty",
+		term == "xterm-ghostty",
+		termProgram == "ghostty":
+		return GraphicsKitty
+
+	case termProgram == "iTerm.app",
+		termProgram == "WezTerm",
+		os.Getenv("LC_TERMINAL") == "iTerm2":
+		return GraphicsITerm
+
+	case strings.Contains(term, "sixel"),
+		term == "foot",
+		strings.HasPrefix(term, "mlterm"),
+		strings.HasPrefix(term, "yaft"):
+		return GraphicsSixel
+
+	default:
+		return GraphicsHalfBlock
+	}
+}
+
+/*
+renderPreview renders the image at path so it fits within the given
+amount of terminal cells. An empty string is returned if the image
+can't be decoded.
+
+🟡 Only kitty leaves the cursor in place after drawing, so the other
+protocols may leave artifacts behind when the screen is redrawn.
+*/
+func renderPreview(p GraphicsProtocol, path string, cols, rows int) string {
+	var render func(image.Image) string
+	switch p {
+	case GraphicsKitty:
+		render = renderKitty
+	case GraphicsITerm:
+		render = renderITerm
+	case GraphicsSixel:
+		render = renderSixel
+	default:
+		// Every terminal can draw half blocks, so they're the fallback
+		img, err := lib.LoadImage(path, cols, rows*2)
+		if err != nil {
+			return ""
+		}
+		return renderHalfBlocks(img)
+	}
+
+	img, err := lib.LoadImage(path, cols*cellWidthPx, rows*cellHeightPx)
+	if err != nil {
+		return ""
+	}
+	return render(img)
+}
+
+// clearPreview removes any images left on screen by the protocol.
+func clearPreview(p GraphicsProtocol) string {
+	if p == GraphicsKitty {
+		return "\x1b_Ga=d,q=2\x1b\\"
+	}
+	return ""
+}
+
+/*
+renderHalfBlocks draws two pixels per cell, using the foreground color
+for the top pixel and the background color for the bottom one.
+*/
+func renderHalfBlocks(img image.Image) string {
+	b := img.Bounds()
+	margin := strings.Repeat(" ", leftMargin)
+	sb := strings.Builder{}
+
+	for y := b.Min.Y; y < b.Max.Y; y += 2 {
+		sb.WriteString(margin)
+		for x := b.Min.X; x < b.Max.X; x++ {
+			tr, tg, tb := rgb8(img, x, y)
+			fmt.Fprintf(&sb, "\x1b[38;2;%d;%d;%dm", tr, tg, tb)
+			if y+1 < b.Max.Y {
+				br, bg, bb := rgb8(img, x, y+1)
+				fmt.Fprintf(&sb, "\x1b[48;2;%d;%d;%dm", br, bg, bb)
+			}
+			sb.WriteString("▀")
+		}
+		sb.WriteString("\x1b[0m\n")
+	}
+
+	return sb.String()
+}
+
+func renderKitty(img image.Image) string {
+	data, err := encodePNG(img)
+	if err != nil {
+		return ""
+	}
+
+	sb := strings.Builder{}
+	sb.WriteString(strings.Repeat(" ", leftMargin))
+	for first := true; len(data) > 0; first = false {
+		chunk := data[:min(kittyChunkSize, len(data))]
+		data = data[len(chunk):]
+		more := 0
+		if len(data) > 0 {
+			more = 1
+		}
+		if first {
+			// C=1 keeps the cursor in place, so we can reserve the rows ourselves
+			fmt.Fprintf(&sb, "\x1b_Ga=T,f=100,q=2,C=1,m=%d;%s\x1b\\", more, chunk)
+		} else {
+			fmt.Fprintf(&sb, "\x1b_Gm=%d;%s\x1b\\", more, chunk)
+		}
+	}
+
+	rows := (img.Bounds().Dy() + cellHeightPx - 1) / cellHeightPx
+	sb.WriteString(strings.Repeat("\n", rows))
+	return sb.String()
+}
+
+func renderITerm(img image.Image) string {
+	data, err := encodePNG(img)
+	if err != nil {
+		return ""
+	}
+	b := img.Bounds()
+	return fmt.Sprintf(
+		"%s\x1b]1337;File=inline=1;width=%dpx;height=%dpx;preserveAspectRatio=1:%s\a\n",
+		strings.Repeat(" ", leftMargin),
+		b.Dx(),
+		b.Dy(),
+		data,
+	)
+}
+
+/*
+renderSixel encodes the image with a fixed 216 color palette, which is
+good enough for a preview and avoids having to quantize every image.
+*/
+func renderSixel(img image.Image) string {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	colors := sixelLevels * sixelLevels * sixelLevels
+
+	sb := strings.Builder{}
+	sb.WriteString(strings.Repeat(" ", leftMargin))
+	fmt.Fprintf(&sb, "\x1bPq\"1;1;%d;%d", w, h)
+	for i := range colors {
+		r, g, bl := i/36, (i/6)%6, i%6
+		// Sixel colors are percentages
+		fmt.Fprintf(&sb, "#%d;2;%d;%d;%d", i, r*20, g*20, bl*20)
+	}
+
+	indexes := make([]int, w*h)
+	for y := range h {
+		for x := range w {
+			r, g, bl := rgb8(img, b.Min.X+x, b.Min.Y+y)
+			indexes[y*w+x] = sixelIndex(r)*36 + sixelIndex(g)*6 + sixelIndex(bl)
+		}
+	}
+
+	for band := 0; band < h; band += 6 {
+		used := make([]bool, colors)
+		for y := band; y < min(band+6, h); y++ {
+			for x := range w {
+				used[indexes[y*w+x]] = true
+			}
+		}
+
+		for c := range colors {
+			if !used[c] {
+				continue
+			}
+			fmt.Fprintf(&sb, "#%d", c)
+			run, last := 0, byte(0)
+			for x := range w {
+				bits := 0
+				for k := 0; k < 6 && band+k < h; k++ {
+					if indexes[(band+k)*w+x] == c {
+						bits |= 1 << k
+					}
+				}
+				ch := byte(63 + bits)
+				if run > 0 && ch != last {
+					writeSixelRun(&sb, last, run)
+					run = 0
+				}
+				last = ch
+				run++
+			}
+			writeSixelRun(&sb, last, run)
+			// Return to the start of the band for the next color
+			sb.WriteByte('$')
+		}
+		sb.WriteByte('-')
+	}
+
+	sb.WriteString("\x1b\\\n")
+	return sb.String()
+}
+
+func writeSixelRun(sb *strings.Builder, ch byte, run int) {
+	if run > 3 {
+		fmt.Fprintf(sb, "!%d%c", run, ch)
+		return
+	}
+	sb.WriteString(strings.Repeat(string(ch), run))
+}
+
+func sixelIndex(v uint8) int {
+	return (int(v)*(sixelLevels-1) + 127) / 255
+}
+
+func encodePNG(img image.Image) (string, error) {
+	buf := bytes.Buffer{}
+	if err := png.Encode(&buf, img); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+func rgb8(img image.Image, x, y int) (uint8, uint8, uint8) {
+	r, g, b, _ := img.At(x, y).RGBA()
+	return uint8(r >> 8), uint8(g >> 8), uint8(b >> 8)
+}
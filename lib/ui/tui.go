@@ -9,6 +9,7 @@ import (
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/jaeiya/hashimg/lib"
+	"github.com/jaeiya/hashimg/lib/models"
 )
 
 const (
@@ -21,6 +22,8 @@ const (
 	StateHDDSelection
 	StateReviewConsentSelection
 	StateUserReview
+	StateTerminalReview
+	StateReviewSummary
 	StateDoAllWork
 	StateDoHashWork
 	StateDoUpdateWork
@@ -59,11 +62,45 @@ type ResultDisplayItem struct {
 	valueStyle lipgloss.Style
 }
 
+const (
+	DriveUnknown DriveKind = iota
+	DriveHDD
+	DriveSSD
+)
+
+type DriveKind int
+
+const (
+	ReviewAsk ReviewChoice = iota
+	ReviewAlways
+	ReviewNever
+)
+
+// ReviewChoice decides whether dupes are reviewed before they're deleted.
+type ReviewChoice int
+
+type TuiConfig struct {
+	// The kind of drive the images are on. When known, the user
+	// isn't asked for it.
+	Drive DriveKind
+	// Name of the drive the images are on, if known
+	DriveName string
+	// Always ask for the kind of drive, even when it's known
+	AskDrive bool
+	// Review duplicates inside the terminal instead of opening a folder
+	TerminalReview bool
+	// How image previews are drawn during a terminal review
+	Graphics GraphicsProtocol
+	// The user is only asked whether to review when it's ReviewAsk
+	Review ReviewChoice
+}
+
 type TuiModel struct {
+	cfg                   TuiConfig
 	state                 State
 	hasConsent            bool
 	wantsReview           bool
-	keepDupes             bool
+	reviewOptionIndex     int
 	hddIndex              int
 	isHDD                 bool
 	imgProcessor          *lib.ImageProcessor
@@ -72,10 +109,34 @@ type TuiModel struct {
 	updateProgressBar     progress.Model
 	hashProgressPercent   float64
 	updateProgressPercent float64
+	hashThroughput        models.Throughput
+	updateThroughput      models.Throughput
+	review                reviewState
 }
 
-func NewTUI(ip *lib.ImageProcessor) TuiModel {
+type reviewState struct {
+	groups    []lib.DupeGroup
+	groupIdx  int
+	fileIdx   int
+	decisions lib.ReviewDecisions
+	preview   string
+	// The summary is confirmed when this is true
+	apply bool
+	// Where to go if the summary is declined
+	prevState State
+}
+
+func NewTUI(ip *lib.ImageProcessor, cfg TuiConfig) TuiModel {
+	if cfg.Graphics == GraphicsAuto {
+		cfg.Graphics = DetectGraphicsProtocol()
+	}
+	hddIndex := 0
+	if cfg.Drive == DriveSSD {
+		hddIndex = 1
+	}
 	return TuiModel{
+		cfg:               cfg,
+		hddIndex:          hddIndex,
 		state:             StateConsentSelection,
 		hashProgressBar:   progress.New(progress.WithGradient("#34C8FF", brightColor)),
 		updateProgressBar: progress.New(progress.WithGradient("#34C8FF", brightColor)),
@@ -164,6 +225,12 @@ func (m TuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case StateUserReview:
 		return m.updateUserReviewSelection(msg)
 
+	case StateTerminalReview:
+		return m.updateTerminalReview(msg)
+
+	case StateReviewSummary:
+		return m.updateReviewSummary(msg)
+
 	case StateHashProgressing, StateUpdateProgressing:
 		return m.updateProgress(msg)
 
@@ -175,6 +242,15 @@ func (m TuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 }
 
 func (m TuiModel) View() string {
+	s := m.viewState()
+	// Some protocols leave images on screen until they're removed
+	if m.review.groups != nil && m.state != StateTerminalReview {
+		s = clearPreview(m.cfg.Graphics) + s
+	}
+	return s
+}
+
+func (m TuiModel) viewState() string {
 	switch m.state {
 
 	case StateAbort:
@@ -202,6 +278,12 @@ func (m TuiModel) View() string {
 	case StateUserReview:
 		return m.viewUserReviewSelection()
 
+	case StateTerminalReview:
+		return m.viewTerminalReview()
+
+	case StateReviewSummary:
+		return m.viewReviewSummary()
+
 	case StateResults:
 		return m.viewResults()
 
@@ -225,6 +307,10 @@ func (m TuiModel) updateConsentSelection(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.state = StateAbort
 				return m.Update(msg)
 			}
+			if m.cfg.Drive != DriveUnknown && !m.cfg.AskDrive {
+				m.isHDD = m.cfg.Drive == DriveHDD
+				return m.askReview()
+			}
 			m.state = StateHDDSelection
 			return m, nil
 		}
@@ -252,13 +338,22 @@ func (m TuiModel) updateHDDSelection(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 		case "enter":
 			m.isHDD = m.hddIndex == 0
-			m.state = StateReviewConsentSelection
-			return m, nil
+			return m.askReview()
 		}
 	}
 	return m, nil
 }
 
+// askReview asks whether to review the dupes, unless it was already decided
+func (m TuiModel) askReview() (tea.Model, tea.Cmd) {
+	m.state = StateReviewConsentSelection
+	if m.cfg.Review == ReviewAsk {
+		return m, nil
+	}
+	m.wantsReview = m.cfg.Review == ReviewAlways
+	return m.updateReviewConsentSelection(tea.KeyMsg{Type: tea.KeyEnter})
+}
+
 func (m TuiModel) updateReviewConsentSelection(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
@@ -270,7 +365,8 @@ func (m TuiModel) updateReviewConsentSelection(msg tea.Msg) (tea.Model, tea.Cmd)
 			m.wantsReview = true
 
 		case "enter":
-			if m.wantsReview {
+			// Terminal reviews don't need the dupes moved to a folder
+			if m.wantsReview && !m.cfg.TerminalReview {
 				m.state = StateDoHashReviewWork
 				return m.Update(msg)
 			}
@@ -286,23 +382,170 @@ func (m TuiModel) updateUserReviewSelection(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case tea.KeyMsg:
 		switch msg.String() {
 		case "up", "k":
-			m.keepDupes = false
+			m.reviewOptionIndex = max(m.reviewOptionIndex-1, 0)
 
 		case "down", "j":
-			m.keepDupes = true
+			m.reviewOptionIndex = min(m.reviewOptionIndex+1, len(reviewOptionTextList)-1)
 
 		case "enter":
-			if m.keepDupes {
+			switch m.reviewOptionIndex {
+			case reviewOptionDeleteAll:
+				m, err := m.loadReviewGroups()
+				if err != nil {
+					return m.reviewErr(err)
+				}
+				return m.showReviewSummary(StateUserReview), nil
+
+			case reviewOptionDecide:
+				return m.startTerminalReview()
+
+			case reviewOptionKeepAll:
+				// Put everything back where it was found
+				if err := m.imgProcessor.UndoReview(); err != nil {
+					return m.reviewErr(err)
+				}
 				m.state = StateAbort
 				return m.Update(msg)
 			}
-			m.state = StateDoUpdateReviewWork
+		}
+	}
+	return m, nil
+}
+
+func (m TuiModel) loadReviewGroups() (TuiModel, error) {
+	groups, err := m.imgProcessor.DupeGroups()
+	if err != nil {
+		return m, err
+	}
+	m.review = reviewState{
+		groups:    groups,
+		decisions: lib.NewReviewDecisions(),
+	}
+	return m, nil
+}
+
+func (m TuiModel) startTerminalReview() (tea.Model, tea.Cmd) {
+	m, err := m.loadReviewGroups()
+	if err != nil {
+		return m.reviewErr(err)
+	}
+	m.state = StateTerminalReview
+	m.review.preview = m.renderReviewPreview()
+	return m, nil
+}
+
+func (m TuiModel) reviewErr(err error) (tea.Model, tea.Cmd) {
+	m.state = StateError
+	m.workErr.name = "Reviewing"
+	m.workErr.err = err
+	return m, tea.Quit
+}
+
+func (m TuiModel) showReviewSummary(prevState State) TuiModel {
+	m.review.prevState = prevState
+	m.review.apply = false
+	m.state = StateReviewSummary
+	return m
+}
+
+func (m TuiModel) updateTerminalReview(msg tea.Msg) (tea.Model, tea.Cmd) {
+	r := &m.review
+	group := r.groups[r.groupIdx]
+
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "up", "k":
+			r.fileIdx = max(r.fileIdx-1, 0)
+
+		case "down", "j":
+			r.fileIdx = min(r.fileIdx+1, len(group.Files)-1)
+
+		case "left", "h":
+			if r.groupIdx > 0 {
+				r.groupIdx--
+				r.fileIdx = 0
+			}
+
+		case "right", "l":
+			if r.groupIdx < len(r.groups)-1 {
+				r.groupIdx++
+				r.fileIdx = 0
+			}
+
+		case " ", "x":
+			file := group.Files[r.fileIdx]
+			// Keepers can't be deleted
+			if r.decisions.KeeperPath(group) != file.Path {
+				r.decisions.KeepFiles[file.Path] = !r.decisions.KeepFiles[file.Path]
+			}
+			return m, nil
+
+		case "g":
+			r.decisions.KeepGroups[group.Hash] = !r.decisions.KeepGroups[group.Hash]
+			return m, nil
+
+		case "s":
+			file := group.Files[r.fileIdx]
+			r.decisions.Keepers[group.Hash] = file.Path
+			// A keeper is always kept, so it can't also be excluded
+			delete(r.decisions.KeepFiles, file.Path)
+			return m, nil
+
+		case "enter":
+			if r.groupIdx < len(r.groups)-1 {
+				r.groupIdx++
+				r.fileIdx = 0
+				break
+			}
+			return m.showReviewSummary(StateTerminalReview), nil
+
+		default:
+			return m, nil
+		}
+
+		r.preview = m.renderReviewPreview()
+	}
+	return m, nil
+}
+
+func (m TuiModel) updateReviewSummary(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "up", "k":
+			m.review.apply = false
+
+		case "down", "j":
+			m.review.apply = true
+
+		case "enter":
+			if !m.review.apply {
+				m.state = m.review.prevState
+				return m, nil
+			}
+			err := m.imgProcessor.ApplyDecisions(m.review.decisions)
+			if err != nil {
+				return m.reviewErr(err)
+			}
+			// Folder reviews need their images restored before updating
+			if m.cfg.TerminalReview {
+				m.state = StateDoUpdateWork
+			} else {
+				m.state = StateDoUpdateReviewWork
+			}
 			return m.Update(msg)
 		}
 	}
 	return m, nil
 }
 
+func (m TuiModel) renderReviewPreview() string {
+	r := m.review
+	file := r.groups[r.groupIdx].Files[r.fileIdx]
+	return renderPreview(m.cfg.Graphics, file.CurrentPath(), previewCols, previewRows)
+}
+
 func (m TuiModel) updateProgress(msg tea.Msg) (tea.Model, tea.Cmd) {
 	status := m.imgProcessor.Status
 	switch msg.(type) {
@@ -310,12 +553,16 @@ func (m TuiModel) updateProgress(msg tea.Msg) (tea.Model, tea.Cmd) {
 		switch msg {
 
 		case ProgressHash:
-			progressBy := 100 / float64(status.MaxHashProgress)
-			m.hashProgressPercent = progressBy / 100 * float64(status.HashProgress)
+			m.hashThroughput = status.HashThroughput()
+			m.hashProgressPercent = hashPercent(status, m.hashThroughput)
 			return m, m.pollProgressStatus()
 
 		case ProgressHashComplete:
 			m.hashProgressPercent = 1
+			m.hashThroughput = status.HashThroughput()
+			if m.wantsReview && m.imgProcessor.HasDupes && m.cfg.TerminalReview {
+				return m.startTerminalReview()
+			}
 			if m.wantsReview && m.imgProcessor.HasDupes {
 				m.state = StateUserReview
 				return m.Update(msg)
@@ -326,10 +573,12 @@ func (m TuiModel) updateProgress(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case ProgressUpdate:
 			progressBy := 100 / float64(status.MaxUpdateProgress)
 			m.updateProgressPercent = progressBy / 100 * float64(status.UpdateProgress)
+			m.updateThroughput = status.UpdateThroughput()
 			return m, m.pollProgressStatus()
 
 		case ProgressUpdateComplete:
 			m.updateProgressPercent = 1
+			m.updateThroughput = status.UpdateThroughput()
 			m.state = StateResults
 			return m, tea.Quit
 
@@ -360,6 +609,18 @@ func (m TuiModel) updateProgress(msg tea.Msg) (tea.Model, tea.Cmd) {
 	}
 }
 
+/*
+hashPercent follows the bytes read rather than the files hashed, so a
+few huge images don't leave the bar stuck. Folders of only cached
+images have nothing to read.
+*/
+func hashPercent(status *models.ProcessStatus, t models.Throughput) float64 {
+	if t.Total > 0 {
+		return float64(t.Done) / float64(t.Total)
+	}
+	return float64(status.HashProgress) / float64(status.MaxHashProgress)
+}
+
 func (m TuiModel) pollProgressStatus() tea.Cmd {
 	status := m.imgProcessor.Status
 	return tea.Tick(time.Millisecond*pollingRateMilli, func(t time.Time) tea.Msg {
@@ -3,17 +3,21 @@ package ui
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/charmbracelet/lipgloss"
 	"github.com/jaeiya/hashimg/lib"
+	"github.com/jaeiya/hashimg/lib/utils"
 )
 
 const (
 	leftMargin       = 4
 	maxProgressWidth = 60
+	previewCols      = 60
+	previewRows      = 14
 
 	CautionForeColor = "#FFF10E"
 	CautionBackColor = "#131313"
@@ -37,19 +41,40 @@ const (
 		" 100 images, you probably won't notice much difference between the two options."
 
 	reviewSelectionText = "If you would like, you can review the duplicate images before" +
-		" they are deleted. A separate folder will be opened for you to review them."
+		" they are deleted."
+
+	reviewInTerminalText = " You'll review them right here, in the terminal."
+
+	reviewInOpenedFolderText = " A separate folder will be opened for you to review them."
+
+	reviewInFolderText = " They'll be moved into a separate folder for you to review them."
+
+	terminalReviewHelpText = "↑/↓ select file • space keep/delete file • s make keeper" +
+		" • g keep whole group • ←/→ change group • enter next group"
 
 	userReviewSelectionText = "I've opened a folder containing the duplicate images." +
 		" Images with the same hash name are considered identical. When you're done" +
 		" reviewing the images, you can decide to keep or delete them below."
 )
 
+const (
+	reviewOptionDeleteAll = iota
+	reviewOptionDecide
+	reviewOptionKeepAll
+)
+
 var (
 	driveTextList = []string{
 		"HDD - Hard Disk Drive (Noisy)",
 		"SSD - Solid State Drive (Flash)",
 	}
 
+	reviewOptionTextList = []string{
+		"Delete all duplicates",
+		"Decide for each group",
+		"Keep all duplicates and quit (nothing changes)",
+	}
+
 	footerText = fmt.Sprintf(
 		"\n%s %s %s",
 		footerStyle.Render("Hashimg"),
@@ -122,20 +147,23 @@ func (m TuiModel) viewHardDriveSelection() string {
 	}
 	s := "\n" + headerStyle.Render(hddSelectionText) + "\n\n"
 
-	driveStr := lipgloss.NewStyle().
-		Foreground(lipgloss.Color(brightColor)).
-		Render(wd[:3])
-
-	s += baseStyle.Foreground(lipgloss.Color(whiteColor)).
-		Render("Which type of drive is your "+driveStr+" drive?") + "\n\n"
+	// Only Windows has volume names, like C:
+	driveName := m.cfg.DriveName
+	if driveName == "" {
+		driveName = filepath.VolumeName(wd)
+	}
 
-	for i, hd := range driveTextList {
-		if m.hddIndex == i {
-			s += brightStyle.Render("> "+hd) + "\n"
-		} else {
-			s += baseStyle.Render("  "+hd) + "\n"
-		}
+	question := "Which type of drive are your images stored on?"
+	if driveName != "" {
+		driveStr := lipgloss.NewStyle().
+			Foreground(lipgloss.Color(brightColor)).
+			Render(driveName)
+		question = "Which type of drive is your " + driveStr + " drive?"
 	}
+
+	s += baseStyle.Foreground(lipgloss.Color(whiteColor)).Render(question) + "\n\n"
+
+	s += viewList(driveTextList, m.hddIndex)
 	s += "\n" + footerText + "\n"
 	return s
 }
@@ -143,20 +171,116 @@ func (m TuiModel) viewHardDriveSelection() string {
 func (m TuiModel) viewReviewConsentSelection() string {
 	return viewYesNo(
 		"Would you like to review any duplicate images found?",
-		reviewSelectionText,
+		reviewSelectionText+m.reviewLocationText(),
 		func() bool {
 			return m.wantsReview
 		},
 	)
 }
 
+// reviewLocationText tells where the dupes will be reviewed
+func (m TuiModel) reviewLocationText() string {
+	switch {
+	case m.cfg.TerminalReview:
+		return reviewInTerminalText
+	case m.imgProcessor.OpenReviewFolder && utils.CanOpenFolder():
+		return reviewInOpenedFolderText
+	}
+	return reviewInFolderText
+}
+
 func (m TuiModel) viewUserReviewSelection() string {
-	return viewYesNo(
-		"Would you like to keep the duplicate images?",
-		userReviewSelectionText,
-		func() bool {
-			return m.keepDupes
-		},
+	s := "\n" + headerStyle.Render(userReviewSelectionText) + "\n\n"
+	s += baseStyle.Foreground(lipgloss.Color(whiteColor)).
+		Render("What would you like to do with the duplicate images?") + "\n\n"
+	s += viewList(reviewOptionTextList, m.reviewOptionIndex)
+	s += "\n" + footerText + "\n"
+	return s
+}
+
+func (m TuiModel) viewTerminalReview() string {
+	r := m.review
+	group := r.groups[r.groupIdx]
+
+	s := "\n" + brightStyle.Render(
+		fmt.Sprintf("Duplicate Group %d of %d", r.groupIdx+1, len(r.groups)),
+	) + "\n"
+	s += footerStyle.Render(group.Hash) + "\n\n"
+
+	if r.preview == "" {
+		s += footerStyle.Render("No preview available") + "\n\n"
+	} else {
+		s += r.preview + "\n"
+	}
+
+	if r.decisions.KeepGroups[group.Hash] {
+		s += resultsDupeStyle.MarginLeft(leftMargin).Render("Keeping every file in this group") +
+			"\n\n"
+	}
+
+	for i, file := range group.Files {
+		cursor := "  "
+		if i == r.fileIdx {
+			cursor = "> "
+		}
+
+		label := noStyle.UnsetMarginLeft().Render("[delete]")
+		switch {
+		case r.decisions.KeeperPath(group) == file.Path:
+			label = brightStyle.UnsetMarginLeft().Render("[keeper]")
+		case r.decisions.IsKept(group, file):
+			label = resultsDupeStyle.Bold(true).Render("[keep]  ")
+		}
+
+		name := filepath.Base(file.Path)
+		if i == r.fileIdx {
+			name = lipgloss.NewStyle().Foreground(lipgloss.Color(whiteColor)).Render(name)
+		}
+
+		s += baseStyle.Render(cursor+label+" "+name) +
+			" " + footerStyle.UnsetMarginLeft().Render(formatBytes(file.Size)) + "\n"
+	}
+
+	s += "\n" + footerStyle.Render(terminalReviewHelpText) + "\n"
+	s += "\n" + footerText + "\n"
+	return s
+}
+
+func (m TuiModel) viewReviewSummary() string {
+	moveDupes := m.imgProcessor.MovesDupes()
+	summary := m.review.decisions.Summarize(m.review.groups, moveDupes)
+	s := fmt.Sprintf("\n%s\n\n", resultsHeaderStyle.Render("Review Summary"))
+
+	items := []ResultDisplayItem{
+		{"Groups", strconv.Itoa(summary.Groups), resultsTImagesStyle},
+		{"Kept Groups", strconv.Itoa(summary.KeptGroups), resultsCacheStyle},
+		{"New Keepers", strconv.Itoa(summary.ChangedKeepers), resultsNewStyle},
+		{"Kept Dupes", strconv.Itoa(summary.KeptFiles), resultsDupeStyle},
+	}
+	if moveDupes {
+		items = append(items,
+			ResultDisplayItem{"Moved Dupes", strconv.Itoa(summary.MovedFiles), resultsDupeStyle},
+			ResultDisplayItem{"Space Moved", formatBytes(summary.MovedBytes), resultsValueStyle},
+		)
+	} else {
+		items = append(items,
+			ResultDisplayItem{"Deleted Dupes", strconv.Itoa(summary.DeletedFiles), resultsDupeStyle},
+			ResultDisplayItem{"Space Freed", formatBytes(summary.DeletedBytes), resultsValueStyle},
+		)
+	}
+	for _, item := range items {
+		s += fmt.Sprintf(
+			"%s %s\n",
+			resultsLabelStyle.Render(item.label),
+			item.valueStyle.Render(item.value),
+		)
+	}
+	s += "\n"
+
+	return s + viewYesNo(
+		"Apply these changes?",
+		"",
+		func() bool { return m.review.apply },
 	)
 }
 
@@ -171,11 +295,29 @@ func (m TuiModel) viewProgress() string {
 	if m.hashProgressPercent > 0 {
 		s = "\n" + brightStyle.Render("Hashing...") + "\n"
 		s += "\n" + margin + m.hashProgressBar.ViewAs(m.hashProgressPercent) + "\n"
+		if m.hashThroughput.Total > 0 {
+			t := m.hashThroughput
+			s += "\n" + margin + fmt.Sprintf(
+				"%s / %s  %s/s%s\n",
+				formatBytes(t.Done),
+				formatBytes(t.Total),
+				formatBytes(int64(t.PerSecond)),
+				formatETA(t.Remaining),
+			)
+		}
 	}
 
 	if m.updateProgressPercent > 0 {
 		s += "\n" + brightStyle.Render("Updating...") + "\n"
 		s += "\n" + margin + m.updateProgressBar.ViewAs(m.updateProgressPercent) + "\n"
+		t := m.updateThroughput
+		s += "\n" + margin + fmt.Sprintf(
+			"%d / %d files  %.0f files/s%s\n",
+			t.Done,
+			t.Total,
+			t.PerSecond,
+			formatETA(t.Remaining),
+		)
 	}
 
 	s += fmt.Sprintf("\n\n%s\n", footerText)
@@ -190,10 +332,63 @@ func (m TuiModel) viewResults() string {
 	items := []ResultDisplayItem{
 		{"Total Images", strconv.Itoa(int(status.TotalImageCount)), resultsTImagesStyle},
 		{"Dupes", strconv.Itoa(int(status.DupeImageCount)), resultsDupeStyle},
+	}
+
+	if status.KeptDupeCount > 0 {
+		items = append(items, ResultDisplayItem{
+			"Kept Dupes",
+			strconv.Itoa(int(status.KeptDupeCount)),
+			resultsDupeStyle,
+		})
+	}
+
+	items = append(items, ResultDisplayItem{
+		"Space Reclaimed",
+		formatBytes(status.ReclaimedBytes),
+		resultsValueStyle,
+	})
+	if status.MovedBytes > 0 {
+		items = append(items, ResultDisplayItem{
+			"Moved to Disposal",
+			formatBytes(status.MovedBytes),
+			resultsValueStyle,
+		})
+	}
+
+	items = append(items, []ResultDisplayItem{
 		{"Cached", strconv.Itoa(int(status.CachedImageCount)), resultsCacheStyle},
 		{"New", strconv.Itoa(int(status.NewImageCount)), resultsNewStyle},
+	}...)
+
+	// Videos are counted as images too
+	if status.TotalVideoCount > 0 {
+		items = append(items, []ResultDisplayItem{
+			{"Videos", strconv.Itoa(int(status.TotalVideoCount)), resultsTImagesStyle},
+			{"Video Dupes", strconv.Itoa(int(status.DupeVideoCount)), resultsDupeStyle},
+		}...)
+	}
+
+	// Archived images are never changed, so they're counted apart
+	if status.ArchivedImageCount > 0 {
+		items = append(items, []ResultDisplayItem{
+			{"Archived", strconv.Itoa(int(status.ArchivedImageCount)), resultsTImagesStyle},
+			{"Archive Dupes", strconv.Itoa(int(status.ArchiveDupeCount)), resultsDupeStyle},
+		}...)
+	}
+
+	// Images whose hash name was already taken by another file
+	if status.ConflictCount > 0 {
+		items = append(items, ResultDisplayItem{
+			"Conflicts",
+			strconv.Itoa(int(status.ConflictCount)),
+			resultsDupeStyle,
+		})
+	}
+
+	items = append(items, []ResultDisplayItem{
 		{"", "", resultsValueStyle},
-		{"Buffer Size", formatBytes(status.BufferSize), resultsValueStyle},
+		{"Readers", strconv.Itoa(int(status.Readers)), resultsValueStyle},
+		{"Chunk Size", formatBytes(status.ChunkSize), resultsValueStyle},
 		{"Analyze Speed", formatDuration(status.AnalyzeTook), resultsValueStyle},
 		{"Hash Speed", formatDuration(status.HashingTook), resultsValueStyle},
 		{"Filter Speed", formatDuration(status.FilterTook), resultsValueStyle},
@@ -204,7 +399,7 @@ func (m TuiModel) viewResults() string {
 		},
 		{"", "", resultsValueStyle},
 		{"Total Time", formatDuration(m.imgProcessor.ProcessTime), resultsTTimeStyle},
-	}
+	}...)
 
 	for _, item := range items {
 		isInstant := strings.Contains(item.value, "0") &&
@@ -241,6 +436,18 @@ func (m TuiModel) viewAbort() string {
 	return s
 }
 
+func viewList(options []string, selected int) string {
+	s := ""
+	for i, option := range options {
+		if selected == i {
+			s += brightStyle.Render("> "+option) + "\n"
+		} else {
+			s += baseStyle.Render("  "+option) + "\n"
+		}
+	}
+	return s
+}
+
 func viewYesNo(question string, header string, isYes func() bool) string {
 	s := ""
 	if len(header) > 0 {
@@ -261,19 +468,9 @@ func viewYesNo(question string, header string, isYes func() bool) string {
 }
 
 func formatBytes(bytes int64) string {
-	if bytes == 0 {
-		return "0 Bytes"
-	}
-
-	suffixes := []string{"Bytes", "KiB", "MiB", "GiB", "TiB"}
-	for _, suffix := range suffixes {
-		if bytes < 1024 {
-			return fmt.Sprintf("%d %s", bytes, timeNotationStyle.Render(suffix))
-		}
-		bytes /= 1024
-	}
-
-	panic("unsupported size when formatting bytes")
+	// Sizes are formatted by lib.ByteSize everywhere, only the unit is styled
+	size, unit, _ := strings.Cut(lib.ByteSize(bytes).String(), " ")
+	return size + " " + timeNotationStyle.Render(unit)
 }
 
 func formatDuration(d time.Duration) string {
@@ -290,6 +487,14 @@ func formatDuration(d time.Duration) string {
 	}
 }
 
+// formatETA is empty until there's enough progress to estimate it.
+func formatETA(d time.Duration) string {
+	if d <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("  %s %s", d.Round(time.Second), timeNotationStyle.Render("left"))
+}
+
 func getPrettyVersion() string {
 	v := lib.GetVersion()
 	if strings.Contains(v, "build-") {
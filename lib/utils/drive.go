@@ -0,0 +1,12 @@
+package utils
+
+import "errors"
+
+var ErrDriveDetectionUnsupported = errors.New("drive detection is not supported on this platform")
+
+type DriveInfo struct {
+	// Name of the block device, like sda or nvme0n1
+	Name string
+	// Spinning disks are rotational, flash storage is not
+	Rotational bool
+}
@@ -0,0 +1,173 @@
+//go:build linux
+
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+/*
+DetectDrive finds the block device that backs path and whether it's a
+spinning disk. Partitions are resolved to their disk, and virtual
+devices (LVM, dm-crypt, md) are resolved through their slaves; they're
+considered rotational if any of the underlying disks are.
+*/
+func DetectDrive(path string) (DriveInfo, error) {
+	return detectDrive(path, "/proc/self/mountinfo", "/sys")
+}
+
+func detectDrive(path, mountInfoPath, sysRoot string) (DriveInfo, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return DriveInfo{}, err
+	}
+	if resolved, err := filepath.EvalSymlinks(absPath); err == nil {
+		absPath = resolved
+	}
+
+	devID, source, err := findMount(absPath, mountInfoPath)
+	if err != nil {
+		return DriveInfo{}, err
+	}
+
+	devDir := filepath.Join(sysRoot, "dev", "block", devID)
+	if _, err := os.Stat(devDir); err != nil {
+		// Filesystems like btrfs report an anonymous device, so
+		// we fall back to the device they were mounted from.
+		devID, err = deviceID(source)
+		if err != nil {
+			return DriveInfo{}, err
+		}
+		devDir = filepath.Join(sysRoot, "dev", "block", devID)
+	}
+
+	devDir, err = filepath.EvalSymlinks(devDir)
+	if err != nil {
+		return DriveInfo{}, err
+	}
+
+	rotational, err := isRotational(devDir, sysRoot, 0)
+	if err != nil {
+		return DriveInfo{}, err
+	}
+
+	return DriveInfo{
+		Name:       filepath.Base(diskDir(devDir)),
+		Rotational: rotational,
+	}, nil
+}
+
+/*
+findMount returns the device ID (major:minor) and source of the mount
+that contains path, which is the one with the longest mount point.
+*/
+func findMount(path, mountInfoPath string) (string, string, error) {
+	file, err := os.Open(mountInfoPath)
+	if err != nil {
+		return "", "", err
+	}
+	defer file.Close()
+
+	var devID, source, mountPoint string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw
+		fields := strings.Fields(scanner.Text())
+		sep := -1
+		for i, f := range fields {
+			if f == "-" {
+				sep = i
+				break
+			}
+		}
+		if len(fields) < 5 || sep == -1 || sep+2 >= len(fields) {
+			continue
+		}
+
+		mp := unescapeMountPath(fields[4])
+		if !isWithin(path, mp) || len(mp) < len(mountPoint) {
+			continue
+		}
+		devID, source, mountPoint = fields[2], fields[sep+2], mp
+	}
+
+	if err := scanner.Err(); err != nil {
+		return "", "", err
+	}
+	if devID == "" {
+		return "", "", fmt.Errorf("no mount found for %s", path)
+	}
+	return devID, source, nil
+}
+
+func deviceID(devicePath string) (string, error) {
+	var st unix.Stat_t
+	if err := unix.Stat(devicePath, &st); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d:%d", unix.Major(st.Rdev), unix.Minor(st.Rdev)), nil
+}
+
+/*
+isRotational reads the rotational flag of the device in devDir. Devices
+with slaves are rotational when any of their slaves are.
+*/
+func isRotational(devDir, sysRoot string, depth int) (bool, error) {
+	// Protects against cycles in a broken sysfs
+	if depth > 8 {
+		return false, fmt.Errorf("too many nested devices at %s", devDir)
+	}
+
+	slaves, _ := os.ReadDir(filepath.Join(devDir, "slaves"))
+	if len(slaves) > 0 {
+		for _, slave := range slaves {
+			slaveDir, err := filepath.EvalSymlinks(
+				filepath.Join(sysRoot, "class", "block", slave.Name()),
+			)
+			if err != nil {
+				return false, err
+			}
+			rotational, err := isRotational(slaveDir, sysRoot, depth+1)
+			if err != nil {
+				return false, err
+			}
+			if rotational {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(diskDir(devDir), "queue", "rotational"))
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(string(data)) == "1", nil
+}
+
+// diskDir resolves a partition to the disk that contains it.
+func diskDir(devDir string) string {
+	if _, err := os.Stat(filepath.Join(devDir, "partition")); err == nil {
+		return filepath.Dir(devDir)
+	}
+	return devDir
+}
+
+func isWithin(path, dir string) bool {
+	if dir == "/" || path == dir {
+		return true
+	}
+	return strings.HasPrefix(path, dir+"/")
+}
+
+// Mount paths escape whitespace and backslashes as octal
+func unescapeMountPath(p string) string {
+	r := strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`)
+	return r.Replace(p)
+}
@@ -0,0 +1,95 @@
+//go:build linux
+
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectDrive(t *testing.T) {
+	const mountInfo = `22 1 8:2 / / rw,relatime shared:1 - ext4 /dev/sda2 rw
+23 22 259:1 / /home rw,relatime shared:2 - ext4 /dev/nvme0n1p1 rw
+24 22 253:0 / /mnt/vault rw,relatime shared:3 - ext4 /dev/mapper/vault rw
+25 22 253:1 / /mnt/my\040pics rw,relatime shared:4 - xfs /dev/mapper/pics rw
+26 22 0:40 / /tmp rw,relatime shared:5 - tmpfs tmpfs rw
+`
+
+	sysRoot := t.TempDir()
+	mountInfoPath := filepath.Join(t.TempDir(), "mountinfo")
+	require.NoError(t, os.WriteFile(mountInfoPath, []byte(mountInfo), 0o644))
+
+	// sda is a spinning disk and nvme0n1 is flash
+	writeSysDisk(t, sysRoot, "sda", "1", "sda2", "8:2")
+	writeSysDisk(t, sysRoot, "nvme0n1", "0", "nvme0n1p1", "259:1")
+	writeSysDisk(t, sysRoot, "sdb", "0", "sdb1", "8:17")
+	// vault is on the flash drive, pics spans both kinds of drives
+	writeSysVirtual(t, sysRoot, "dm-0", "253:0", "nvme0n1p1")
+	writeSysVirtual(t, sysRoot, "dm-1", "253:1", "sdb1", "sda2")
+
+	md := []struct {
+		should     string
+		path       string
+		name       string
+		rotational bool
+	}{
+		{"resolve partitions to their disk", "/var/pics", "sda", true},
+		{"use the longest mount point", "/home/user/pics", "nvme0n1", false},
+		{"resolve virtual devices through slaves", "/mnt/vault/pics", "dm-0", false},
+		{"be rotational if any slave is rotational", "/mnt/my pics/2024", "dm-1", true},
+	}
+
+	for _, d := range md {
+		t.Run("should "+d.should, func(t *testing.T) {
+			t.Parallel()
+			a := assert.New(t)
+			info, err := detectDrive(d.path, mountInfoPath, sysRoot)
+			require.NoError(t, err)
+			a.Equal(d.name, info.Name)
+			a.Equal(d.rotational, info.Rotational)
+		})
+	}
+
+	t.Run("should error without a block device", func(t *testing.T) {
+		t.Parallel()
+		_, err := detectDrive("/tmp/pics", mountInfoPath, sysRoot)
+		assert.Error(t, err)
+	})
+}
+
+func writeSysDisk(t *testing.T, sysRoot, disk, rotational, part, devID string) {
+	diskDir := filepath.Join(sysRoot, "devices", "pci0000:00", "block", disk)
+	require.NoError(t, os.MkdirAll(filepath.Join(diskDir, "queue"), 0o755))
+	require.NoError(t, os.MkdirAll(filepath.Join(diskDir, part), 0o755))
+	require.NoError(t, os.WriteFile(
+		filepath.Join(diskDir, "queue", "rotational"),
+		[]byte(rotational+"\n"),
+		0o644,
+	))
+	require.NoError(t, os.WriteFile(filepath.Join(diskDir, part, "partition"), []byte("1"), 0o644))
+	linkSysDevice(t, sysRoot, filepath.Join(diskDir, part), part, devID)
+}
+
+func writeSysVirtual(t *testing.T, sysRoot, name, devID string, slaves ...string) {
+	devDir := filepath.Join(sysRoot, "devices", "virtual", "block", name)
+	require.NoError(t, os.MkdirAll(filepath.Join(devDir, "slaves"), 0o755))
+	for _, slave := range slaves {
+		require.NoError(t, os.Symlink(
+			filepath.Join(sysRoot, "class", "block", slave),
+			filepath.Join(devDir, "slaves", slave),
+		))
+	}
+	linkSysDevice(t, sysRoot, devDir, name, devID)
+}
+
+func linkSysDevice(t *testing.T, sysRoot, devDir, name, devID string) {
+	for _, dir := range []string{"dev/block", "class/block"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(sysRoot, dir), 0o755))
+	}
+	require.NoError(t, os.Symlink(devDir, filepath.Join(sysRoot, "dev", "block", devID)))
+	require.NoError(t, os.Symlink(devDir, filepath.Join(sysRoot, "class", "block", name)))
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package utils
+
+// DetectDrive is only supported on Linux.
+func DetectDrive(path string) (DriveInfo, error) {
+	return DriveInfo{}, ErrDriveDetectionUnsupported
+}
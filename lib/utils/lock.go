@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// LockFileName is the name of the lock file inside a locked directory
+const LockFileName = ".hashimg.lock"
+
+var (
+	ErrLocked       = errors.New("directory is locked by another process")
+	ErrLockNotStale = errors.New("lock is held by a running process")
+)
+
+// LockError is returned when a directory is already locked.
+type LockError struct {
+	// Zero when the holder is unknown
+	PID int
+}
+
+func (le *LockError) Error() string {
+	if le.PID == 0 {
+		return ErrLocked.Error()
+	}
+	return fmt.Sprintf("directory is locked by another process (PID %d)", le.PID)
+}
+
+func (le *LockError) Unwrap() error {
+	return ErrLocked
+}
+
+/*
+DirLock is an advisory lock on a directory, which prevents two processes
+from renaming and deleting the same files. It's held until Unlock is
+called or the process exits.
+*/
+type DirLock struct {
+	file *os.File
+	path string
+}
+
+func lockPath(dir string) string {
+	return filepath.Join(dir, LockFileName)
+}
+
+/*
+BreakLock removes a stale lock, which is one whose holder is no longer
+running. Locks held by running processes are never broken, even when
+their PID can't be read, like while the holder is still writing it.
+*/
+func BreakLock(dir string) error {
+	path := lockPath(dir)
+	if isLockHeld(path) {
+		return ErrLockNotStale
+	}
+	pid := readLockPID(path)
+	if pid != 0 && processExists(pid) {
+		return fmt.Errorf("%w: PID %d", ErrLockNotStale, pid)
+	}
+
+	err := os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func readLockPID(path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
+func writeLockPID(file *os.File) error {
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	_, err := file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	return err
+}
@@ -0,0 +1,49 @@
+//go:build !unix
+
+package utils
+
+import (
+	"errors"
+	"os"
+)
+
+/*
+LockDir locks dir by creating the lock file, which fails if it already
+exists. A crash leaves the lock behind, which can be removed with
+BreakLock.
+*/
+func LockDir(dir string) (*DirLock, error) {
+	path := lockPath(dir)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o644)
+	if errors.Is(err, os.ErrExist) {
+		return nil, &LockError{PID: readLockPID(path)}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeLockPID(file); err != nil {
+		file.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	return &DirLock{file: file, path: path}, nil
+}
+
+func (dl *DirLock) Unlock() error {
+	if err := dl.file.Close(); err != nil {
+		return err
+	}
+	return os.Remove(dl.path)
+}
+
+// isLockHeld can't tell without flock, so only the PID is checked
+func isLockHeld(path string) bool {
+	return false
+}
+
+func processExists(pid int) bool {
+	// Finding a process fails on Windows when it isn't running
+	_, err := os.FindProcess(pid)
+	return err == nil
+}
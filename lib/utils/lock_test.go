@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirLock(t *testing.T) {
+	t.Run("should name the process holding the lock", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		dir := t.TempDir()
+
+		lock, err := LockDir(dir)
+		require.NoError(t, err)
+
+		_, err = LockDir(dir)
+		a.ErrorIs(err, ErrLocked)
+		var lockErr *LockError
+		require.ErrorAs(t, err, &lockErr)
+		a.Equal(os.Getpid(), lockErr.PID)
+
+		require.NoError(t, lock.Unlock())
+		a.NoFileExists(filepath.Join(dir, LockFileName))
+	})
+
+	t.Run("should lock again after unlocking", func(t *testing.T) {
+		t.Parallel()
+		dir := t.TempDir()
+		lock, err := LockDir(dir)
+		require.NoError(t, err)
+		require.NoError(t, lock.Unlock())
+
+		lock, err = LockDir(dir)
+		require.NoError(t, err)
+		assert.NoError(t, lock.Unlock())
+	})
+
+	t.Run("should not break locks of running processes", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		dir := t.TempDir()
+		lock, err := LockDir(dir)
+		require.NoError(t, err)
+		defer lock.Unlock()
+
+		a.ErrorIs(BreakLock(dir), ErrLockNotStale)
+		a.FileExists(filepath.Join(dir, LockFileName))
+	})
+
+	t.Run("should break stale locks", func(t *testing.T) {
+		t.Parallel()
+		dir := t.TempDir()
+		// A PID far above the limit of any system
+		stale := []byte("2147483600\n")
+		require.NoError(t, os.WriteFile(filepath.Join(dir, LockFileName), stale, 0o644))
+
+		require.NoError(t, BreakLock(dir))
+		lock, err := LockDir(dir)
+		require.NoError(t, err)
+		assert.NoError(t, lock.Unlock())
+	})
+}
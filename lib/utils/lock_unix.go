@@ -0,0 +1,86 @@
+//go:build unix
+
+package utils
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+/*
+LockDir locks dir with flock, so the lock is released by the kernel when
+the process exits, even when it crashes.
+*/
+func LockDir(dir string) (*DirLock, error) {
+	path := lockPath(dir)
+	for {
+		file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+		if err != nil {
+			return nil, err
+		}
+
+		err = unix.Flock(int(file.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+		if errors.Is(err, unix.EWOULDBLOCK) {
+			file.Close()
+			return nil, &LockError{PID: readLockPID(path)}
+		}
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+
+		// The lock file may have been removed by its last holder
+		// or broken while we were waiting, so we'd hold a lock
+		// nobody else can see.
+		if !isSameFile(file, path) {
+			file.Close()
+			continue
+		}
+
+		if err := writeLockPID(file); err != nil {
+			file.Close()
+			return nil, err
+		}
+		return &DirLock{file: file, path: path}, nil
+	}
+}
+
+// Unlock removes the lock file before releasing the lock.
+func (dl *DirLock) Unlock() error {
+	removeErr := os.Remove(dl.path)
+	if err := dl.file.Close(); err != nil {
+		return err
+	}
+	return removeErr
+}
+
+func isSameFile(file *os.File, path string) bool {
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	pathInfo, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(fileInfo, pathInfo)
+}
+
+// isLockHeld tries to lock the file without waiting, which fails while it's held
+func isLockHeld(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	err = unix.Flock(int(file.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	return errors.Is(err, unix.EWOULDBLOCK)
+}
+
+func processExists(pid int) bool {
+	err := unix.Kill(pid, 0)
+	// EPERM means it exists, but belongs to another user
+	return err == nil || errors.Is(err, unix.EPERM)
+}
@@ -0,0 +1,29 @@
+//go:build unix
+
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBreakLock(t *testing.T) {
+	t.Run("should not break held locks without a PID", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		dir := t.TempDir()
+		lock, err := LockDir(dir)
+		require.NoError(t, err)
+		defer lock.Unlock()
+
+		path := filepath.Join(dir, LockFileName)
+		require.NoError(t, os.WriteFile(path, nil, 0o644))
+
+		a.ErrorIs(BreakLock(dir), ErrLockNotStale)
+		a.FileExists(path)
+	})
+}
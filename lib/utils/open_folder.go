@@ -2,6 +2,7 @@ package utils
 
 import (
 	"fmt"
+	"os"
 	"os/exec"
 	"runtime"
 )
@@ -24,3 +25,25 @@ func OpenFolder(path string) error {
 
 	return cmd.Start()
 }
+
+/*
+CanOpenFolder reports whether there is likely a desktop available to open
+a folder in. Linux sessions without a display, like most SSH sessions,
+have nowhere to show the folder.
+*/
+func CanOpenFolder() bool {
+	switch runtime.GOOS {
+
+	case "windows", "darwin":
+		return os.Getenv("SSH_CONNECTION") == ""
+	case "linux":
+		if os.Getenv("DISPLAY") == "" && os.Getenv("WAYLAND_DISPLAY") == "" {
+			return false
+		}
+		_, err := exec.LookPath("xdg-open")
+		return err == nil
+	default:
+		return false
+
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package utils
+
+import "os"
+
+// ReadOrderKey is not supported on this platform.
+func ReadOrderKey(info os.FileInfo) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,21 @@
+//go:build unix
+
+package utils
+
+import (
+	"os"
+	"syscall"
+)
+
+/*
+ReadOrderKey returns a key that sorts files roughly by where they are
+stored on disk, which is their inode number. Most filesystems allocate
+inodes near the data of files created in the same directory.
+*/
+func ReadOrderKey(info os.FileInfo) (uint64, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(st.Ino), true
+}
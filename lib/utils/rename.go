@@ -0,0 +1,23 @@
+package utils
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+)
+
+/*
+renameChecked is RenameNoReplace for systems without an atomic way to
+do it. Another process could still create newPath between the check and
+the rename.
+*/
+func renameChecked(oldPath, newPath string) error {
+	_, err := os.Lstat(newPath)
+	if err == nil {
+		return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: fs.ErrExist}
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return os.Rename(oldPath, newPath)
+}
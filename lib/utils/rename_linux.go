@@ -0,0 +1,27 @@
+//go:build linux
+
+package utils
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+/*
+RenameNoReplace renames oldPath to newPath, unless newPath exists, in
+which case the error wraps fs.ErrExist. The check and the rename are a
+single atomic step, when the filesystem supports it.
+*/
+func RenameNoReplace(oldPath, newPath string) error {
+	err := unix.Renameat2(unix.AT_FDCWD, oldPath, unix.AT_FDCWD, newPath, unix.RENAME_NOREPLACE)
+	// Old kernels and some filesystems don't support the flag
+	if errors.Is(err, unix.ENOSYS) || errors.Is(err, unix.EINVAL) {
+		return renameChecked(oldPath, newPath)
+	}
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: err}
+	}
+	return nil
+}
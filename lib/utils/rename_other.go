@@ -0,0 +1,11 @@
+//go:build !linux
+
+package utils
+
+/*
+RenameNoReplace renames oldPath to newPath, unless newPath exists, in
+which case the error wraps fs.ErrExist.
+*/
+func RenameNoReplace(oldPath, newPath string) error {
+	return renameChecked(oldPath, newPath)
+}
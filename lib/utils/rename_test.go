@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenameNoReplace(t *testing.T) {
+	t.Run("should rename when the target is free", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		dir := t.TempDir()
+		src := filepath.Join(dir, "a.jpg")
+		dst := filepath.Join(dir, "b.jpg")
+		require.NoError(t, os.WriteFile(src, []byte("a"), 0o600))
+
+		require.NoError(t, RenameNoReplace(src, dst))
+		a.NoFileExists(src)
+		a.FileExists(dst)
+	})
+
+	t.Run("should never replace the target", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		dir := t.TempDir()
+		src := filepath.Join(dir, "a.jpg")
+		dst := filepath.Join(dir, "b.jpg")
+		require.NoError(t, os.WriteFile(src, []byte("a"), 0o600))
+		require.NoError(t, os.WriteFile(dst, []byte("b"), 0o600))
+
+		a.ErrorIs(RenameNoReplace(src, dst), fs.ErrExist)
+
+		data, err := os.ReadFile(src)
+		require.NoError(t, err)
+		a.Equal("a", string(data))
+		data, err = os.ReadFile(dst)
+		require.NoError(t, err)
+		a.Equal("b", string(data))
+	})
+}
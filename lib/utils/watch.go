@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrWatchOverflow is sent when events were lost, so the directory should be rescanned
+var ErrWatchOverflow = errors.New("too many file events, some were lost")
+
+/*
+FileWatcher reports the names of files that finished being written to,
+or were moved into, a directory. Subdirectories aren't watched.
+*/
+type FileWatcher struct {
+	// Closed after the watcher is closed
+	Events chan string
+	Errors chan error
+
+	done      chan struct{}
+	closeOnce sync.Once
+	close     func() error
+}
+
+func newFileWatcher(close func() error) *FileWatcher {
+	return &FileWatcher{
+		Events: make(chan string),
+		Errors: make(chan error, 1),
+		done:   make(chan struct{}),
+		close:  close,
+	}
+}
+
+func (fw *FileWatcher) Close() error {
+	var err error
+	fw.closeOnce.Do(func() {
+		close(fw.done)
+		err = fw.close()
+	})
+	return err
+}
+
+// send returns false once the watcher is closed
+func (fw *FileWatcher) send(name string) bool {
+	select {
+	case fw.Events <- name:
+		return true
+	case <-fw.done:
+		return false
+	}
+}
+
+// sendErr drops the error when an earlier one wasn't received yet
+func (fw *FileWatcher) sendErr(err error) {
+	select {
+	case fw.Errors <- err:
+	default:
+	}
+}
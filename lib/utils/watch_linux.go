@@ -0,0 +1,66 @@
+//go:build linux
+
+package utils
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const watchMask = unix.IN_CLOSE_WRITE | unix.IN_MOVED_TO | unix.IN_ONLYDIR
+
+// WatchDir watches dir with inotify.
+func WatchDir(dir string) (*FileWatcher, error) {
+	// Non-blocking, so that closing the file interrupts reading it
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	if _, err := unix.InotifyAddWatch(fd, dir, watchMask); err != nil {
+		unix.Close(fd)
+		return nil, &os.PathError{Op: "inotify_add_watch", Path: dir, Err: err}
+	}
+
+	file := os.NewFile(uintptr(fd), "inotify")
+	fw := newFileWatcher(file.Close)
+	go fw.readEvents(file)
+	return fw, nil
+}
+
+func (fw *FileWatcher) readEvents(file *os.File) {
+	defer close(fw.Events)
+
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		n, err := file.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				fw.sendErr(err)
+			}
+			return
+		}
+
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + unix.SizeofInotifyEvent
+			offset = nameStart + int(event.Len)
+
+			if event.Mask&unix.IN_Q_OVERFLOW != 0 {
+				fw.sendErr(ErrWatchOverflow)
+				continue
+			}
+			if event.Mask&unix.IN_ISDIR != 0 || event.Len == 0 {
+				continue
+			}
+			// Names are padded with null bytes
+			name := strings.TrimRight(string(buf[nameStart:offset]), "\x00")
+			if !fw.send(name) {
+				return
+			}
+		}
+	}
+}
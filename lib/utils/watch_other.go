@@ -0,0 +1,81 @@
+//go:build !linux
+
+package utils
+
+import (
+	"os"
+	"time"
+)
+
+const watchPollInterval = time.Second
+
+type watchedFile struct {
+	size    int64
+	modTime time.Time
+}
+
+/*
+WatchDir polls dir for changes. Files that are still being written are
+reported every time they change, so callers should wait for them to
+settle.
+*/
+func WatchDir(dir string) (*FileWatcher, error) {
+	seen, err := scanWatchedDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	fw := newFileWatcher(func() error { return nil })
+	go fw.poll(dir, seen)
+	return fw, nil
+}
+
+func (fw *FileWatcher) poll(dir string, seen map[string]watchedFile) {
+	defer close(fw.Events)
+
+	ticker := time.NewTicker(watchPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-fw.done:
+			return
+		case <-ticker.C:
+		}
+
+		current, err := scanWatchedDir(dir)
+		if err != nil {
+			fw.sendErr(err)
+			continue
+		}
+		for name, file := range current {
+			if prev, ok := seen[name]; ok && prev == file {
+				continue
+			}
+			if !fw.send(name) {
+				return
+			}
+		}
+		seen = current
+	}
+}
+
+func scanWatchedDir(dir string) (map[string]watchedFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]watchedFile, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			// Removed since reading the directory
+			continue
+		}
+		files[entry.Name()] = watchedFile{info.Size(), info.ModTime()}
+	}
+	return files, nil
+}
@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchDir(t *testing.T) {
+	t.Run("should report written and moved files", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		dir := t.TempDir()
+		other := t.TempDir()
+		fw, err := WatchDir(dir)
+		require.NoError(t, err)
+		defer fw.Close()
+
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "a.png"), []byte("a"), 0o600))
+		require.NoError(t, os.WriteFile(filepath.Join(other, "b.png"), []byte("b"), 0o600))
+		require.NoError(t, os.Rename(filepath.Join(other, "b.png"), filepath.Join(dir, "b.png")))
+
+		names := map[string]bool{}
+		timeout := time.After(5 * time.Second)
+		for len(names) < 2 {
+			select {
+			case name := <-fw.Events:
+				names[name] = true
+			case err := <-fw.Errors:
+				require.NoError(t, err)
+			case <-timeout:
+				t.Fatalf("only got events for %v", names)
+			}
+		}
+		a.Equal(map[string]bool{"a.png": true, "b.png": true}, names)
+	})
+
+	t.Run("should close the events when closed", func(t *testing.T) {
+		t.Parallel()
+		fw, err := WatchDir(t.TempDir())
+		require.NoError(t, err)
+		require.NoError(t, fw.Close())
+
+		select {
+		case _, ok := <-fw.Events:
+			assert.False(t, ok)
+		case <-time.After(5 * time.Second):
+			t.Fatal("events were never closed")
+		}
+	})
+}
@@ -0,0 +1,65 @@
+package lib
+
+import (
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+)
+
+// CacheMismatch is a cached image whose content doesn't match its name.
+type CacheMismatch struct {
+	Path string
+	// The hash in the name of the image
+	NameHash string
+	// The hash of what's in the image now
+	Hash string
+}
+
+/*
+VerifyCache rehashes every cached image and returns the ones that no
+longer match the hash in their name, like images that were edited or
+corrupted after they were renamed. Nothing is renamed or deleted.
+
+Stale cached images are skipped, because their hash can't be compared.
+*/
+func (ip *ImageProcessor) VerifyCache(isHDD bool) ([]CacheMismatch, error) {
+	if len(ip.imageMap) == 0 {
+		return nil, ErrNoImages
+	}
+
+	// Cached images are read like new ones, then compared with their name
+	cached := ImageMap{}
+	for fileName, cs := range ip.imageMap {
+		if cs == Cached && isHashName(cachedHash(fileName, ip.hashPrefix), ip.hashLength) {
+			cached[fileName] = NotCached
+		}
+	}
+	imageMap := ip.imageMap
+	ip.imageMap = cached
+	defer func() { ip.imageMap = imageMap }()
+
+	ip.Status.TotalImageCount = int32(len(cached))
+	atomic.StoreInt32(&ip.Status.MaxHashProgress, int32(len(cached)))
+	readers, ordered := ip.readStrategy(isHDD)
+
+	fileNames, err := ip.planReads(ordered)
+	if err != nil {
+		return nil, err
+	}
+	hashResult, err := ip.calcImageHashes(fileNames, readers)
+	if err != nil {
+		return nil, err
+	}
+
+	mismatches := []CacheMismatch{}
+	for _, hi := range hashResult.newHashesInfo {
+		nameHash := cachedHash(filepath.Base(hi.path), ip.hashPrefix)
+		if hi.hash != nameHash {
+			mismatches = append(mismatches, CacheMismatch{Path: hi.path, NameHash: nameHash, Hash: hi.hash})
+		}
+	}
+	sort.Slice(mismatches, func(i, j int) bool {
+		return mismatches[i].Path < mismatches[j].Path
+	})
+	return mismatches, nil
+}
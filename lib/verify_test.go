@@ -0,0 +1,52 @@
+package lib
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyCache(t *testing.T) {
+	const hashPrefix = "0x@"
+	dir := filepath.Join(string(filepath.Separator), "images")
+
+	newProcessor := func(t *testing.T, files, content []string) *ImageProcessor {
+		fsys := NewMemFS()
+		require.NoError(t, fsys.MkdirAll(dir, 0o755))
+		require.NoError(t, writeFiles(fsys, dir, files, content))
+		iMap, err := MapImagesWithConfig(MapperConfig{Dir: dir, Prefix: hashPrefix, FS: fsys})
+		require.NoError(t, err)
+		return NewImageProcessor(ImageProcessorConfig{
+			WorkingDir: dir,
+			Prefix:     hashPrefix,
+			ImageMap:   iMap,
+			HashLength: hashLength,
+			FS:         fsys,
+		})
+	}
+
+	t.Run("should find cached images that changed", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		good := hashPrefix + calcSha256("1") + ".png"
+		edited := hashPrefix + calcSha256("2") + ".png"
+		stale := hashPrefix + calcSha256("3")[:10] + ".png"
+		ip := newProcessor(t, []string{good, edited, stale, "new.png"}, []string{"1", "edited", "4", "5"})
+
+		mismatches, err := ip.VerifyCache(false)
+		require.NoError(t, err)
+		a.Equal([]CacheMismatch{{
+			Path:     filepath.Join(dir, edited),
+			NameHash: calcSha256("2"),
+			Hash:     calcSha256("edited"),
+		}}, mismatches)
+		a.EqualValues(2, ip.Status.HashProgress, "should only read cached images")
+
+		fileNames, err := readDir(ip.fs, dir)
+		require.NoError(t, err)
+		a.ElementsMatch([]string{good, edited, stale, "new.png"}, fileNames, "should never change images")
+	})
+
+}
@@ -1,26 +1,114 @@
 package lib
 
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Set by the build process with ldflags
 var (
 	appVersion string
 	goVersion  string
 	commitSha  string
+	buildDate  string
 )
 
+// Version of the main module in builds that aren't from a module proxy
+const develVersion = "(devel)"
+
+// BuildInfo describes how the binary was built.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"build_date,omitempty"`
+	// The build had uncommitted changes
+	Modified  bool         `json:"modified,omitempty"`
+	GoVersion string       `json:"go_version"`
+	OS        string       `json:"os"`
+	Arch      string       `json:"arch"`
+	Deps      []Dependency `json:"dependencies"`
+}
+
+type Dependency struct {
+	Path    string `json:"path"`
+	Version string `json:"version"`
+	// Path of the module that replaced this one, if any
+	Replace string `json:"replace,omitempty"`
+}
+
 /*
 GetVersion returns the application version set by the build process.
-If the binary is a dev build, then it returns a truncated version
-of the latest commit hash.
+Builds without it, like the ones from go install, fall back to the
+module version, then to a truncated version of the latest commit hash.
 */
 func GetVersion() string {
-	if appVersion == "" {
-		if commitSha == "" {
-			return "invalid version"
-		}
-		return commitSha[:8]
-	}
-	return appVersion
+	return GetBuildInfo().Version
 }
 
 func GetGoVersion() string {
-	return goVersion
+	return GetBuildInfo().GoVersion
+}
+
+/*
+GetBuildInfo returns the build info set by the build process, filling
+whatever wasn't set from the info Go embeds into every binary.
+*/
+func GetBuildInfo() BuildInfo {
+	bi, _ := debug.ReadBuildInfo()
+	return newBuildInfo(bi)
+}
+
+// newBuildInfo prefers the ldflags over bi, which is nil when it's not available
+func newBuildInfo(bi *debug.BuildInfo) BuildInfo {
+	info := BuildInfo{
+		Version:   appVersion,
+		Commit:    commitSha,
+		BuildDate: buildDate,
+		GoVersion: goVersion,
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		Deps:      []Dependency{},
+	}
+
+	if bi != nil {
+		if info.Version == "" && bi.Main.Version != develVersion {
+			info.Version = bi.Main.Version
+		}
+		if info.GoVersion == "" {
+			info.GoVersion = bi.GoVersion
+		}
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = s.Value
+				}
+			case "vcs.time":
+				if info.BuildDate == "" {
+					info.BuildDate = s.Value
+				}
+			case "vcs.modified":
+				info.Modified = s.Value == "true"
+			}
+		}
+		for _, dep := range bi.Deps {
+			d := Dependency{Path: dep.Path, Version: dep.Version}
+			if dep.Replace != nil {
+				d.Replace = dep.Replace.Path
+				d.Version = dep.Replace.Version
+			}
+			info.Deps = append(info.Deps, d)
+		}
+	}
+
+	if info.GoVersion == "" {
+		info.GoVersion = runtime.Version()
+	}
+	if info.Version == "" {
+		info.Version = develVersion
+		if len(info.Commit) >= 8 {
+			info.Version = info.Commit[:8]
+		}
+	}
+	return info
 }
@@ -0,0 +1,65 @@
+package lib
+
+import (
+	"runtime"
+	"runtime/debug"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// The ldflags are never set in tests, so the build info is all there is
+func TestBuildInfo(t *testing.T) {
+	t.Run("should fall back to the commit of dev builds", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		info := newBuildInfo(&debug.BuildInfo{
+			GoVersion: "go1.22.0",
+			Main:      debug.Module{Path: "github.com/jaeiya/hashimg", Version: "(devel)"},
+			Deps: []*debug.Module{
+				{Path: "gopkg.in/yaml.v3", Version: "v3.0.1"},
+				{
+					Path:    "golang.org/x/sys",
+					Version: "v0.1.0",
+					Replace: &debug.Module{Path: "../sys", Version: "v0.2.0"},
+				},
+			},
+			Settings: []debug.BuildSetting{
+				{Key: "vcs.revision", Value: "0123456789abcdef"},
+				{Key: "vcs.time", Value: "2024-01-31T12:00:00Z"},
+				{Key: "vcs.modified", Value: "true"},
+			},
+		})
+
+		a.Equal(BuildInfo{
+			Version:   "01234567",
+			Commit:    "0123456789abcdef",
+			BuildDate: "2024-01-31T12:00:00Z",
+			Modified:  true,
+			GoVersion: "go1.22.0",
+			OS:        runtime.GOOS,
+			Arch:      runtime.GOARCH,
+			Deps: []Dependency{
+				{Path: "gopkg.in/yaml.v3", Version: "v3.0.1"},
+				{Path: "golang.org/x/sys", Version: "v0.2.0", Replace: "../sys"},
+			},
+		}, info)
+	})
+
+	t.Run("should use the module version of installed builds", func(t *testing.T) {
+		t.Parallel()
+		info := newBuildInfo(&debug.BuildInfo{
+			Main: debug.Module{Path: "github.com/jaeiya/hashimg", Version: "v1.4.0"},
+		})
+		assert.Equal(t, "v1.4.0", info.Version)
+	})
+
+	t.Run("should never be empty without build info", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		info := newBuildInfo(nil)
+		a.Equal(develVersion, info.Version)
+		a.Equal(runtime.Version(), info.GoVersion)
+		a.NotNil(info.Deps)
+	})
+}
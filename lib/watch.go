@@ -0,0 +1,169 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jaeiya/hashimg/lib/utils"
+)
+
+const defaultDebounce = 2 * time.Second
+
+type WatchConfig struct {
+	Mapper MapperConfig
+	// The ImageMap is set for every batch
+	Processor ImageProcessorConfig
+	// How long the directory must be quiet before new images are
+	// processed, which defaults to 2 seconds
+	Debounce time.Duration
+	// Called after every batch, with the totals so far
+	OnBatch func(stats WatchStats, err error)
+	// Called after every batch that updated the images, like to record it
+	OnUpdate func(ip *ImageProcessor, startedAt time.Time)
+}
+
+type WatchStats struct {
+	Batches    int
+	NewImages  int
+	DupeImages int
+	Conflicts  int
+	// Bytes of the dupes that were disposed of
+	ReclaimedBytes int64
+	LastBatch      time.Time
+}
+
+/*
+Watcher dedupes images as they arrive in a directory. New images are
+hashed against the cached images, then dupes are disposed of and novel
+images renamed, like in a normal run. Images that were already there
+before watching are left alone.
+*/
+type Watcher struct {
+	Stats       WatchStats
+	cfg         WatchConfig
+	fileWatcher *utils.FileWatcher
+}
+
+// NewWatcher starts watching right away, so no image is missed before Run.
+func NewWatcher(cfg WatchConfig) (*Watcher, error) {
+	if cfg.Debounce <= 0 {
+		cfg.Debounce = defaultDebounce
+	}
+
+	fw, err := utils.WatchDir(cfg.Mapper.Dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Watcher{cfg: cfg, fileWatcher: fw}, nil
+}
+
+/*
+Run processes new images until the context is done. Images that already
+arrived are processed before returning, so that stopping never leaves
+them behind.
+*/
+func (w *Watcher) Run(ctx context.Context) error {
+	defer w.fileWatcher.Close()
+
+	pending := map[string]bool{}
+	rescan := false
+	var settled <-chan time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			if len(pending) == 0 && !rescan {
+				return nil
+			}
+			w.batch(pending, rescan)
+			return nil
+
+		case name, ok := <-w.fileWatcher.Events:
+			if !ok {
+				return nil
+			}
+			pending[name] = true
+			settled = time.After(w.cfg.Debounce)
+
+		case err := <-w.fileWatcher.Errors:
+			if !errors.Is(err, utils.ErrWatchOverflow) {
+				return err
+			}
+			rescan = true
+			settled = time.After(w.cfg.Debounce)
+
+		case <-settled:
+			w.batch(pending, rescan)
+			pending = map[string]bool{}
+			rescan = false
+			settled = nil
+		}
+	}
+}
+
+func (w *Watcher) batch(pending map[string]bool, rescan bool) {
+	err := w.processBatch(pending, rescan)
+	if w.cfg.OnBatch != nil {
+		w.cfg.OnBatch(w.Stats, err)
+	}
+}
+
+/*
+processBatch processes the pending images along with every cached image,
+which is never read. When events were lost, every image that isn't
+cached is processed instead.
+*/
+func (w *Watcher) processBatch(pending map[string]bool, rescan bool) error {
+	iMap, err := MapImagesWithConfig(w.cfg.Mapper)
+	if errors.Is(err, ErrNoImages) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	batch := ImageMap{}
+	hasNew := false
+	for fileName, cs := range iMap {
+		if cs == Cached {
+			batch[fileName] = cs
+			continue
+		}
+		// Archived images arrive along with their archive
+		name := fileName
+		if archive, _, isArchived := splitArchived(fileName); isArchived {
+			name = archive
+		}
+		// Pending images can be gone already, or be filtered out
+		if pending[name] || rescan {
+			batch[fileName] = cs
+			hasNew = true
+		}
+	}
+	if !hasNew {
+		return nil
+	}
+
+	startedAt := time.Now()
+	ipCfg := w.cfg.Processor
+	ipCfg.ImageMap = batch
+	ip := NewImageProcessor(ipCfg)
+	if err := ip.ProcessImages(false); err != nil {
+		return err
+	}
+	if err := ip.UpdateImages(); err != nil {
+		return err
+	}
+	if w.cfg.OnUpdate != nil {
+		w.cfg.OnUpdate(ip, startedAt)
+	}
+
+	w.Stats.Batches += 1
+	w.Stats.NewImages += int(ip.Status.NewImageCount)
+	w.Stats.DupeImages += int(ip.Status.DupeImageCount)
+	w.Stats.Conflicts += int(ip.Status.ConflictCount)
+	w.Stats.ReclaimedBytes += ip.Status.ReclaimedBytes
+	w.Stats.LastBatch = time.Now()
+	return nil
+}
@@ -0,0 +1,94 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatcher(t *testing.T) {
+	const hashPrefix = "0x@"
+
+	newWatcher := func(t *testing.T, dir string, batches chan<- WatchStats) *Watcher {
+		w, err := NewWatcher(WatchConfig{
+			Mapper: MapperConfig{Dir: dir, Prefix: hashPrefix},
+			Processor: ImageProcessorConfig{
+				WorkingDir: dir,
+				Prefix:     hashPrefix,
+				HashLength: hashLength,
+			},
+			Debounce: 200 * time.Millisecond,
+			OnBatch: func(stats WatchStats, err error) {
+				assert.NoError(t, err)
+				batches <- stats
+			},
+		})
+		require.NoError(t, err)
+		return w
+	}
+
+	t.Run("should dedupe images as they arrive", func(t *testing.T) {
+		t.Parallel()
+		a := assert.New(t)
+		dir := t.TempDir()
+		cached := fmt.Sprintf("0x@%s.png", calcSha256("1"))
+		require.NoError(t, writeFiles(OSFS{}, dir, []string{cached, "old.png"}, []string{"1", "1"}))
+
+		batches := make(chan WatchStats, 10)
+		w := newWatcher(t, dir, batches)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() { done <- w.Run(ctx) }()
+
+		require.NoError(t, writeFiles(OSFS{}, dir, []string{"t1.png", "t2.jpg"}, []string{"1", "2"}))
+
+		select {
+		case stats := <-batches:
+			a.Equal(1, stats.Batches)
+			a.Equal(1, stats.NewImages)
+			a.Equal(1, stats.DupeImages)
+			a.EqualValues(len("1"), stats.ReclaimedBytes)
+		case <-time.After(5 * time.Second):
+			t.Fatal("images were never processed")
+		}
+
+		cancel()
+		require.NoError(t, <-done)
+
+		fileNames, err := readDir(OSFS{}, dir)
+		require.NoError(t, err)
+		a.ElementsMatch([]string{
+			cached,
+			// Images from before watching are left alone
+			"old.png",
+			fmt.Sprintf("0x@%s.jpg", calcSha256("2")),
+		}, fileNames)
+	})
+
+	t.Run("should process arrived images when stopped", func(t *testing.T) {
+		t.Parallel()
+		dir := t.TempDir()
+		batches := make(chan WatchStats, 10)
+		w := newWatcher(t, dir, batches)
+		w.cfg.Debounce = time.Hour
+
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "t1.png"), []byte("1"), 0o600))
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			// The event must be received before stopping, which takes
+			// a poll on platforms without inotify
+			time.Sleep(1500 * time.Millisecond)
+			cancel()
+		}()
+		require.NoError(t, w.Run(ctx))
+
+		assert.Equal(t, 1, (<-batches).NewImages)
+		assert.FileExists(t, filepath.Join(dir, fmt.Sprintf("0x@%s.png", calcSha256("1"))))
+	})
+}
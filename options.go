@@ -0,0 +1,243 @@
+package hashimg
+
+import (
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jaeiya/hashimg/lib"
+)
+
+// Option changes how a Scanner finds and processes images.
+type Option func(*options)
+
+type options struct {
+	base        *Config
+	configFiles bool
+	// Applied on top of the config files, in order
+	overrides        []func(*lib.Config)
+	isHDD            bool
+	readers          int
+	migrate          bool
+	cachedAlgorithm  HashAlgorithm
+	collectReport    bool
+	reportThumbnails bool
+	openReviewFolder bool
+	fs               FS
+	logger           *slog.Logger
+	onSkip           func(path string, err error)
+	history          bool
+}
+
+func configOption(override func(*lib.Config)) Option {
+	return func(o *options) {
+		o.overrides = append(o.overrides, override)
+	}
+}
+
+// WithConfig replaces the default config. Other options still override it.
+func WithConfig(cfg Config) Option {
+	return func(o *options) {
+		o.base = &cfg
+	}
+}
+
+/*
+WithConfigFiles loads the user config and the .hashimg file of the
+directory, like the command line does. Other options override them.
+*/
+func WithConfigFiles() Option {
+	return func(o *options) {
+		o.configFiles = true
+	}
+}
+
+// WithPrefix sets the prefix of renamed images, which marks them as cached.
+func WithPrefix(prefix string) Option {
+	return configOption(func(cfg *lib.Config) { cfg.Prefix = prefix })
+}
+
+func WithHashLength(length int) Option {
+	return configOption(func(cfg *lib.Config) { cfg.Length = length })
+}
+
+func WithAlgorithm(algorithm HashAlgorithm) Option {
+	return configOption(func(cfg *lib.Config) { cfg.Algorithm = algorithm })
+}
+
+func WithKeeperPolicy(policy KeeperPolicy) Option {
+	return configOption(func(cfg *lib.Config) { cfg.KeeperPolicy = policy })
+}
+
+/*
+WithDisposalFolder moves dupes into the folder, relative to the
+directory, instead of deleting them.
+*/
+func WithDisposalFolder(folder string) Option {
+	return configOption(func(cfg *lib.Config) {
+		cfg.Disposal = lib.DisposeMove
+		cfg.DisposalFolder = folder
+	})
+}
+
+// WithExtensions replaces the extensions of files that are treated as images.
+func WithExtensions(extensions ...string) Option {
+	return configOption(func(cfg *lib.Config) { cfg.Extensions = extensions })
+}
+
+// WithEnabledExtensions treats more extensions as images, even disabled ones.
+func WithEnabledExtensions(extensions ...string) Option {
+	return configOption(func(cfg *lib.Config) {
+		for _, ext := range extensions {
+			cfg.DisableExtensions = slices.DeleteFunc(cfg.DisableExtensions, func(e string) bool {
+				return strings.EqualFold(e, ext)
+			})
+			cfg.EnableExtensions = append(cfg.EnableExtensions, ext)
+		}
+	})
+}
+
+func WithDisabledExtensions(extensions ...string) Option {
+	return configOption(func(cfg *lib.Config) {
+		cfg.DisableExtensions = append(cfg.DisableExtensions, extensions...)
+	})
+}
+
+// WithVideos dedupes videos along with images.
+func WithVideos(videos bool) Option {
+	return configOption(func(cfg *lib.Config) { cfg.Videos = videos })
+}
+
+/*
+WithArchives finds dupes of the images inside zip and tar archives.
+Archives are never changed, so their dupes are only reported.
+*/
+func WithArchives(archives bool) Option {
+	return configOption(func(cfg *lib.Config) { cfg.Archives = archives })
+}
+
+// WithIgnore skips files matching the gitignore-style patterns.
+func WithIgnore(patterns ...string) Option {
+	return configOption(func(cfg *lib.Config) { cfg.Ignore = append(cfg.Ignore, patterns...) })
+}
+
+// WithMinSize skips images smaller than size.
+func WithMinSize(size ByteSize) Option {
+	return configOption(func(cfg *lib.Config) { cfg.MinSize = size })
+}
+
+// WithMaxSize skips images larger than size.
+func WithMaxSize(size ByteSize) Option {
+	return configOption(func(cfg *lib.Config) { cfg.MaxSize = size })
+}
+
+// WithModifiedAfter skips images that weren't modified after t.
+func WithModifiedAfter(t time.Time) Option {
+	return configOption(func(cfg *lib.Config) { cfg.ModifiedAfter = lib.FilterTime{Time: t} })
+}
+
+// WithModifiedBefore skips images that weren't modified before t.
+func WithModifiedBefore(t time.Time) Option {
+	return configOption(func(cfg *lib.Config) { cfg.ModifiedBefore = lib.FilterTime{Time: t} })
+}
+
+// WithReviewFolder sets the folder, relative to the directory, that dupes are reviewed in.
+func WithReviewFolder(folder string) Option {
+	return configOption(func(cfg *lib.Config) { cfg.ReviewFolder = folder })
+}
+
+// WithHDD reads images one at a time, in the order they're stored on disk.
+func WithHDD(isHDD bool) Option {
+	return func(o *options) {
+		o.isHDD = isHDD
+	}
+}
+
+// WithReaders sets how many images are read at the same time.
+func WithReaders(readers int) Option {
+	return func(o *options) {
+		o.readers = readers
+	}
+}
+
+/*
+WithMigrate rehashes the images that were renamed with another hash
+length, prefix or algorithm, instead of trusting their names.
+*/
+func WithMigrate(migrate bool) Option {
+	return func(o *options) {
+		o.migrate = migrate
+	}
+}
+
+/*
+WithCachedAlgorithm sets the algorithm the cached images were named
+with, like CachedAlgorithm finds in the history. When it's not the
+configured algorithm, every cached image is stale. Without it, only the
+hash length and prefix are checked, since names don't show the algorithm.
+*/
+func WithCachedAlgorithm(algorithm HashAlgorithm) Option {
+	return func(o *options) {
+		o.cachedAlgorithm = algorithm
+	}
+}
+
+// WithReport collects a report of every dupe group while processing.
+func WithReport(thumbnails bool) Option {
+	return func(o *options) {
+		o.collectReport = true
+		o.reportThumbnails = thumbnails
+	}
+}
+
+// WithOpenReviewFolder opens the review folder once dupes are moved into it.
+func WithOpenReviewFolder(open bool) Option {
+	return func(o *options) {
+		o.openReviewFolder = open
+	}
+}
+
+/*
+WithFS finds and processes images in fsys instead of the OS filesystem,
+like a MemFS in tests. Config files are still read from the OS.
+*/
+func WithFS(fsys FS) Option {
+	return func(o *options) {
+		o.fs = fsys
+	}
+}
+
+/*
+WithLogger logs every scan and file operation, like renaming or
+deleting an image, along with its path and hash. Nothing is logged
+without it.
+*/
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+/*
+WithHistory records every run that updates the images in the history,
+whether it's a plan that's applied, a batch of a watcher or an update
+of a server. Processors are run by the caller, who records them with
+RecordRun instead.
+*/
+func WithHistory() Option {
+	return func(o *options) {
+		o.history = true
+	}
+}
+
+/*
+WithOnSkip calls fn with every archive that can't be read, like a
+corrupt zip. Those archives are skipped instead of failing the scan,
+and logged as warnings.
+*/
+func WithOnSkip(fn func(path string, err error)) Option {
+	return func(o *options) {
+		o.onSkip = fn
+	}
+}
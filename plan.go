@@ -0,0 +1,299 @@
+package hashimg
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/jaeiya/hashimg/lib"
+)
+
+var ErrPlanApplied = errors.New("plan was already applied")
+
+type Image struct {
+	Path    string
+	Hash    string
+	Size    int64
+	ModTime time.Time
+	// Cached images were renamed to their hash by an earlier scan
+	Cached bool
+}
+
+// Group is every image that has the same hash.
+type Group struct {
+	Hash string
+	// The image that survives, unless the whole group is kept
+	Keeper Image
+	// The name the keeper will have once the plan is applied
+	KeeperName string
+	Dupes      []Image
+	// Nothing in the group is disposed of
+	Kept bool
+}
+
+// Rename is a new image that will be, or was, renamed to its hash.
+type Rename struct {
+	Image
+	Target string
+}
+
+/*
+Plan is what applying a scan will do. Every dupe is disposed of, unless
+it's kept, and every other new image is renamed to its hash.
+*/
+type Plan struct {
+	Groups  []Group
+	Renames []Rename
+	// Images inside archives, when archives are scanned. They're
+	// only reported, since archives are never changed.
+	Archived []ArchivedImage
+	// Details of the scan, like how long hashing took
+	Status *Status
+
+	ip        *lib.ImageProcessor
+	groups    []lib.DupeGroup
+	decisions lib.ReviewDecisions
+	applied   bool
+	// Recorded in the history, along with the plan once it's applied
+	startedAt time.Time
+	scanner   *Scanner
+}
+
+type Result struct {
+	// Dupes that were deleted, or moved to the disposal folder
+	Disposed []Image
+	Renamed  []Rename
+	// Images that kept their name, because another file had it
+	Conflicts []Conflict
+	// Status.ReclaimedBytes is how much space the deleted dupes took,
+	// and Status.MovedBytes how much the moved ones took
+	Status *Status
+}
+
+// Scan hashes the images and plans what to do with them.
+func (s *Scanner) Scan() (*Plan, error) {
+	startedAt := time.Now()
+	ip, err := s.NewProcessor()
+	if err != nil {
+		return nil, err
+	}
+	if err := ip.ProcessImages(s.opts.isHDD); err != nil {
+		return nil, err
+	}
+
+	groups, err := ip.DupeGroups()
+	if err != nil {
+		return nil, err
+	}
+	p := &Plan{
+		Archived:  ip.Archived,
+		Status:    ip.Status,
+		ip:        ip,
+		groups:    groups,
+		decisions: lib.NewReviewDecisions(),
+		startedAt: startedAt,
+		scanner:   s,
+	}
+
+	newImages, err := ip.NewImages()
+	if err != nil {
+		return nil, err
+	}
+	for hash, f := range newImages {
+		p.Renames = append(p.Renames, p.rename(hash, f))
+	}
+	sort.Slice(p.Renames, func(i, j int) bool {
+		return p.Renames[i].Path < p.Renames[j].Path
+	})
+
+	p.refreshGroups()
+	return p, nil
+}
+
+// SetKeeper keeps the image at path instead of the current keeper.
+func (p *Plan) SetKeeper(hash, path string) error {
+	if _, err := p.findFile(hash, path); err != nil {
+		return err
+	}
+	p.decisions.Keepers[hash] = path
+	p.refreshGroups()
+	return nil
+}
+
+// KeepGroup keeps every image in the group.
+func (p *Plan) KeepGroup(hash string) error {
+	if _, err := p.findGroup(hash); err != nil {
+		return err
+	}
+	p.decisions.KeepGroups[hash] = true
+	p.refreshGroups()
+	return nil
+}
+
+// KeepFile keeps the dupe at path, along with the keeper.
+func (p *Plan) KeepFile(hash, path string) error {
+	if _, err := p.findFile(hash, path); err != nil {
+		return err
+	}
+	p.decisions.KeepFiles[path] = true
+	p.refreshGroups()
+	return nil
+}
+
+// Apply disposes of the dupes and renames the new images. It can only be called once.
+func (p *Plan) Apply() (*Result, error) {
+	if p.applied {
+		return nil, ErrPlanApplied
+	}
+	p.applied = true
+
+	if err := p.ip.ApplyDecisions(p.decisions); err != nil {
+		return nil, err
+	}
+	if err := p.ip.UpdateImages(); err != nil {
+		return nil, err
+	}
+	if p.scanner.opts.history {
+		p.scanner.recordRun(p.ip, p.startedAt)
+	}
+
+	conflicts := map[string]bool{}
+	for _, c := range p.ip.Conflicts {
+		conflicts[c.Path] = true
+	}
+
+	result := &Result{Conflicts: p.ip.Conflicts, Status: p.ip.Status}
+	for _, g := range p.Groups {
+		if !g.Keeper.Cached && !conflicts[g.Keeper.Path] {
+			result.Renamed = append(result.Renamed, Rename{
+				Image:  g.Keeper,
+				Target: filepath.Join(filepath.Dir(g.Keeper.Path), g.KeeperName),
+			})
+		}
+		if !g.Kept {
+			result.Disposed = append(result.Disposed, p.disposedDupes(g)...)
+		}
+	}
+	for _, r := range p.Renames {
+		if !conflicts[r.Path] {
+			result.Renamed = append(result.Renamed, r)
+		}
+	}
+	return result, nil
+}
+
+/*
+ReclaimableBytes is how much space applying the plan would free. It's
+zero when dupes are moved to the disposal folder, since they still take
+up the same space there.
+*/
+func (p *Plan) ReclaimableBytes() int64 {
+	if p.ip.MovesDupes() {
+		return 0
+	}
+	return p.DisposableBytes()
+}
+
+// DisposableBytes is the size of every dupe that isn't kept.
+func (p *Plan) DisposableBytes() int64 {
+	total := int64(0)
+	for _, g := range p.Groups {
+		if g.Kept {
+			continue
+		}
+		for _, dupe := range p.disposedDupes(g) {
+			total += dupe.Size
+		}
+	}
+	return total
+}
+
+/*
+ExtractNovel extracts the archived images that aren't dupes into the
+directory, named after their hash. Images whose name is taken are
+skipped and returned as conflicts.
+*/
+func (p *Plan) ExtractNovel() (extracted []string, conflicts []Conflict, err error) {
+	before := len(p.ip.Conflicts)
+	extracted, err = p.ip.ExtractNovel()
+	return extracted, p.ip.Conflicts[before:], err
+}
+
+// refreshGroups rebuilds the groups from the decisions so far
+func (p *Plan) refreshGroups() {
+	p.Groups = make([]Group, 0, len(p.groups))
+	for _, dg := range p.groups {
+		keeperPath := p.decisions.KeeperPath(dg)
+		g := Group{Hash: dg.Hash, Kept: p.decisions.KeepGroups[dg.Hash]}
+		for _, f := range dg.Files {
+			img := newImage(dg.Hash, f)
+			if f.Path == keeperPath {
+				g.Keeper = img
+				continue
+			}
+			g.Dupes = append(g.Dupes, img)
+		}
+		g.KeeperName = hashName(p.ip, g.Keeper)
+		p.Groups = append(p.Groups, g)
+	}
+}
+
+func (p *Plan) disposedDupes(g Group) []Image {
+	disposed := []Image{}
+	for _, dupe := range g.Dupes {
+		if !p.decisions.KeepFiles[dupe.Path] {
+			disposed = append(disposed, dupe)
+		}
+	}
+	return disposed
+}
+
+func (p *Plan) rename(hash string, f lib.DupeFile) Rename {
+	img := newImage(hash, f)
+	return Rename{
+		Image:  img,
+		Target: filepath.Join(filepath.Dir(f.Path), hashName(p.ip, img)),
+	}
+}
+
+func (p *Plan) findGroup(hash string) (lib.DupeGroup, error) {
+	for _, dg := range p.groups {
+		if dg.Hash == hash {
+			return dg, nil
+		}
+	}
+	return lib.DupeGroup{}, fmt.Errorf("%w: %s", lib.ErrUnknownDupe, hash)
+}
+
+func (p *Plan) findFile(hash, path string) (lib.DupeFile, error) {
+	dg, err := p.findGroup(hash)
+	if err != nil {
+		return lib.DupeFile{}, err
+	}
+	for _, f := range dg.Files {
+		if f.Path == path {
+			return f, nil
+		}
+	}
+	return lib.DupeFile{}, fmt.Errorf("%w: %s", lib.ErrUnknownDupe, path)
+}
+
+// hashName is the name the image has once it's renamed to its hash
+func hashName(ip *lib.ImageProcessor, img Image) string {
+	if img.Cached {
+		return filepath.Base(img.Path)
+	}
+	return ip.HashName(img.Hash, img.Path)
+}
+
+func newImage(hash string, f lib.DupeFile) Image {
+	return Image{
+		Path:    f.Path,
+		Hash:    hash,
+		Size:    f.Size,
+		ModTime: f.ModTime,
+		Cached:  f.Cached,
+	}
+}